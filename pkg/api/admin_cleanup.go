@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/util"
+)
+
+// AdminRunCleanup runs all cleanup tasks once and returns what each task removed.
+// POST /api/admin/cleanup
+func (hs *HTTPServer) AdminRunCleanup(c *models.ReqContext) Response {
+	return JSON(200, hs.CleanUpService.RunOnce(c.Req.Context()))
+}
+
+// AdminGetCleanupStatus returns the latest run of each cleanup task on this instance.
+// GET /api/admin/cleanup/status
+func (hs *HTTPServer) AdminGetCleanupStatus(c *models.ReqContext) Response {
+	return JSON(200, hs.CleanUpService.Status())
+}
+
+// AdminPauseCleanup stops the scheduled cleanup on this instance until it's resumed.
+// POST /api/admin/cleanup/pause
+func (hs *HTTPServer) AdminPauseCleanup(c *models.ReqContext) Response {
+	if !hs.CleanUpService.Pause() {
+		return JSON(200, util.DynMap{"message": "Cleanup is already paused", "paused": true})
+	}
+
+	return JSON(200, util.DynMap{"message": "Cleanup paused", "paused": true})
+}
+
+// AdminResumeCleanup lets the scheduled cleanup on this instance run again.
+// POST /api/admin/cleanup/resume
+func (hs *HTTPServer) AdminResumeCleanup(c *models.ReqContext) Response {
+	if !hs.CleanUpService.Resume() {
+		return JSON(200, util.DynMap{"message": "Cleanup isn't paused", "paused": false})
+	}
+
+	return JSON(200, util.DynMap{"message": "Cleanup resumed", "paused": false})
+}
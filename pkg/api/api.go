@@ -407,6 +407,10 @@ func (hs *HTTPServer) registerRoutes() {
 		adminRoute.Post("/provisioning/plugins/reload", Wrap(hs.AdminProvisioningReloadPlugins))
 		adminRoute.Post("/provisioning/datasources/reload", Wrap(hs.AdminProvisioningReloadDatasources))
 		adminRoute.Post("/provisioning/notifications/reload", Wrap(hs.AdminProvisioningReloadNotifications))
+		adminRoute.Post("/cleanup", Wrap(hs.AdminRunCleanup))
+		adminRoute.Get("/cleanup/status", Wrap(hs.AdminGetCleanupStatus))
+		adminRoute.Post("/cleanup/pause", Wrap(hs.AdminPauseCleanup))
+		adminRoute.Post("/cleanup/resume", Wrap(hs.AdminResumeCleanup))
 		adminRoute.Post("/ldap/reload", Wrap(hs.ReloadLDAPCfg))
 		adminRoute.Post("/ldap/sync/:id", Wrap(hs.PostSyncUserWithLDAP))
 		adminRoute.Get("/ldap/:username", Wrap(hs.GetUserFromLDAP))
@@ -13,6 +13,7 @@ import (
 	"github.com/grafana/grafana/pkg/infra/metrics"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/guardian"
+	"github.com/grafana/grafana/pkg/services/snapshots"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/grafana/grafana/pkg/util"
 )
@@ -170,33 +171,6 @@ func GetDashboardSnapshot(c *models.ReqContext) {
 	c.JSON(200, dto)
 }
 
-func deleteExternalDashboardSnapshot(externalUrl string) error {
-	response, err := client.Get(externalUrl)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode == 200 {
-		return nil
-	}
-
-	// Gracefully ignore "snapshot not found" errors as they could have already
-	// been removed either via the cleanup script or by request.
-	if response.StatusCode == 500 {
-		var respJson map[string]interface{}
-		if err := json.NewDecoder(response.Body).Decode(&respJson); err != nil {
-			return err
-		}
-
-		if respJson["message"] == "Failed to get dashboard snapshot" {
-			return nil
-		}
-	}
-
-	return fmt.Errorf("Unexpected response when deleting external snapshot. Status code: %d", response.StatusCode)
-}
-
 // GET /api/snapshots-delete/:deleteKey
 func DeleteDashboardSnapshotByDeleteKey(c *models.ReqContext) Response {
 	key := c.Params(":deleteKey")
@@ -209,7 +183,7 @@ func DeleteDashboardSnapshotByDeleteKey(c *models.ReqContext) Response {
 	}
 
 	if query.Result.External {
-		err := deleteExternalDashboardSnapshot(query.Result.ExternalDeleteUrl)
+		err := snapshots.DeleteExternalSnapshot(c.Req.Context(), query.Result.ExternalDeleteUrl)
 		if err != nil {
 			return Error(500, "Failed to delete external dashboard", err)
 		}
@@ -252,7 +226,7 @@ func DeleteDashboardSnapshot(c *models.ReqContext) Response {
 	}
 
 	if query.Result.External {
-		err := deleteExternalDashboardSnapshot(query.Result.ExternalDeleteUrl)
+		err := snapshots.DeleteExternalSnapshot(c.Req.Context(), query.Result.ExternalDeleteUrl)
 		if err != nil {
 			return Error(500, "Failed to delete external dashboard", err)
 		}
@@ -26,6 +26,7 @@ import (
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/plugins"
 	"github.com/grafana/grafana/pkg/registry"
+	"github.com/grafana/grafana/pkg/services/cleanup"
 	"github.com/grafana/grafana/pkg/services/datasources"
 	"github.com/grafana/grafana/pkg/services/hooks"
 	"github.com/grafana/grafana/pkg/services/login"
@@ -70,6 +71,7 @@ type HTTPServer struct {
 	BackendPluginManager backendplugin.Manager            `inject:""`
 	PluginManager        *plugins.PluginManager           `inject:""`
 	SearchService        *search.SearchService            `inject:""`
+	CleanUpService       *cleanup.CleanUpService          `inject:""`
 	Live                 *live.GrafanaLive
 	Listener             net.Listener
 }
@@ -383,11 +385,19 @@ func (hs *HTTPServer) healthHandler(ctx *macaron.Context) {
 
 	data := simplejson.New()
 	data.Set("database", "ok")
+	data.Set("cleanup", "ok")
 	if !hs.Cfg.AnonymousHideVersion {
 		data.Set("version", setting.BuildVersion)
 		data.Set("commit", setting.BuildCommit)
 	}
 
+	// a failing cleanup doesn't keep the instance from serving requests, it's
+	// reported without changing the status code
+	if degraded := hs.CleanUpService.DegradedTasks(); len(degraded) > 0 {
+		data.Set("cleanup", "degraded")
+		data.Set("cleanupDegradedTasks", degraded)
+	}
+
 	if err := bus.Dispatch(&models.GetDBHealthQuery{}); err != nil {
 		data.Set("database", "failing")
 		ctx.Resp.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/logger"
+	"github.com/grafana/grafana/pkg/cmd/grafana-cli/utils"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/services/cleanup"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/util/errutil"
+)
+
+// runCleanupCommand runs the cleanup tasks enabled in the configuration once,
+// or only the task given with --task. Tasks use the same server locks as the
+// Grafana servers, so it's safe to run while they are up.
+func runCleanupCommand(c utils.CommandLine, sqlStore *sqlstore.SqlStore) error {
+	lockService := &serverlock.ServerLockService{SQLStore: sqlStore}
+	if err := lockService.Init(); err != nil {
+		return errutil.Wrap("failed to initialize server lock service", err)
+	}
+
+	service := &cleanup.CleanUpService{Cfg: sqlStore.Cfg, ServerLockService: lockService}
+	if err := service.Init(); err != nil {
+		return errutil.Wrap("failed to initialize cleanup service", err)
+	}
+
+	var report cleanup.CleanupReport
+	if name := c.String("task"); name != "" {
+		result, err := service.RunTask(context.Background(), name)
+		if err != nil {
+			return err
+		}
+		report.Tasks = []cleanup.TaskResult{result}
+	} else {
+		report = service.RunOnce(context.Background())
+	}
+
+	return printCleanupReport(report)
+}
+
+// printCleanupReport prints the outcome of every task and returns an error
+// if any of them failed.
+func printCleanupReport(report cleanup.CleanupReport) error {
+	for _, task := range report.Tasks {
+		switch {
+		case task.Err != nil:
+			logger.Infof("%s %s: %s\n", color.RedString("✗"), task.Name, task.Err)
+		case task.Skipped:
+			logger.Infof("- %s: skipped, another instance ran it recently\n", task.Name)
+		default:
+			logger.Infof("%s %s: deleted %d\n", color.GreenString("✔"), task.Name, task.Deleted)
+		}
+	}
+
+	if failed := report.Failed(); len(failed) > 0 {
+		return fmt.Errorf("%d of %d cleanup tasks failed", len(failed), len(report.Tasks))
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/services/cleanup"
+)
+
+func TestPrintCleanupReport(t *testing.T) {
+	require.NoError(t, printCleanupReport(cleanup.CleanupReport{Tasks: []cleanup.TaskResult{
+		{Name: "expired_snapshots", Deleted: 2},
+		{Name: "old_annotations", Skipped: true},
+	}}))
+
+	err := printCleanupReport(cleanup.CleanupReport{Tasks: []cleanup.TaskResult{
+		{Name: "expired_snapshots", Deleted: 2},
+		{Name: "old_annotations", Err: errors.New("database is locked")},
+	}})
+	require.EqualError(t, err, "1 of 2 cleanup tasks failed")
+}
@@ -130,6 +130,23 @@ var adminCommands = []*cli.Command{
 			},
 		},
 	},
+	{
+		Name:  "cleanup",
+		Usage: "Removes expired data like old dashboard versions, snapshots and temporary images",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "run",
+				Usage:  "Runs the cleanup tasks enabled in the [cleanup] section once. Fails if any of them fails.",
+				Action: runDbCommand(runCleanupCommand),
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "task",
+						Usage: "Only run the task with this name, e.g. expired_dashboard_versions",
+					},
+				},
+			},
+		},
+	},
 }
 
 var Commands = []*cli.Command{
@@ -146,14 +146,20 @@ func validPackaging(packaging string) string {
 func listenToSystemSignals(s *server.Server) {
 	signalChan := make(chan os.Signal, 1)
 	sighupChan := make(chan os.Signal, 1)
+	cleanupChan := make(chan os.Signal, 1)
 
 	signal.Notify(sighupChan, syscall.SIGHUP)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	if cleanupSignal != nil {
+		signal.Notify(cleanupChan, cleanupSignal)
+	}
 
 	for {
 		select {
 		case <-sighupChan:
 			log.Reload()
+		case sig := <-cleanupChan:
+			go s.RunCleanup(sig)
 		case sig := <-signalChan:
 			s.Shutdown(fmt.Sprintf("System signal: %s", sig))
 		}
@@ -0,0 +1,11 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// cleanupSignal triggers a run of all cleanup tasks.
+var cleanupSignal os.Signal = syscall.SIGUSR1
@@ -0,0 +1,8 @@
+package main
+
+import (
+	"os"
+)
+
+// cleanupSignal is unset, there's no user defined signal on Windows.
+var cleanupSignal os.Signal
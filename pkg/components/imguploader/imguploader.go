@@ -29,34 +29,7 @@ var (
 func NewImageUploader() (ImageUploader, error) {
 	switch setting.ImageUploadProvider {
 	case "s3":
-		s3sec, err := setting.Raw.GetSection("external_image_storage.s3")
-		if err != nil {
-			return nil, err
-		}
-
-		endpoint := s3sec.Key("endpoint").MustString("")
-		pathStyleAccess := s3sec.Key("path_style_access").MustBool(false)
-		bucket := s3sec.Key("bucket").MustString("")
-		region := s3sec.Key("region").MustString("")
-		path := s3sec.Key("path").MustString("")
-		bucketUrl := s3sec.Key("bucket_url").MustString("")
-		accessKey := s3sec.Key("access_key").MustString("")
-		secretKey := s3sec.Key("secret_key").MustString("")
-
-		if path != "" && path[len(path)-1:] != "/" {
-			path += "/"
-		}
-
-		if bucket == "" || region == "" {
-			info, err := getRegionAndBucketFromUrl(bucketUrl)
-			if err != nil {
-				return nil, err
-			}
-			bucket = info.bucket
-			region = info.region
-		}
-
-		return NewS3Uploader(endpoint, region, bucket, path, "public-read", accessKey, secretKey, pathStyleAccess), nil
+		return NewS3UploaderFromSettings()
 	case "webdav":
 		webdavSec, err := setting.Raw.GetSection("external_image_storage.webdav")
 		if err != nil {
@@ -106,6 +79,39 @@ func NewImageUploader() (ImageUploader, error) {
 	return NopImageUploader{}, nil
 }
 
+// NewS3UploaderFromSettings creates an S3Uploader from the
+// [external_image_storage.s3] section of the configuration.
+func NewS3UploaderFromSettings() (*S3Uploader, error) {
+	s3sec, err := setting.Raw.GetSection("external_image_storage.s3")
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := s3sec.Key("endpoint").MustString("")
+	pathStyleAccess := s3sec.Key("path_style_access").MustBool(false)
+	bucket := s3sec.Key("bucket").MustString("")
+	region := s3sec.Key("region").MustString("")
+	path := s3sec.Key("path").MustString("")
+	bucketUrl := s3sec.Key("bucket_url").MustString("")
+	accessKey := s3sec.Key("access_key").MustString("")
+	secretKey := s3sec.Key("secret_key").MustString("")
+
+	if path != "" && path[len(path)-1:] != "/" {
+		path += "/"
+	}
+
+	if bucket == "" || region == "" {
+		info, err := getRegionAndBucketFromUrl(bucketUrl)
+		if err != nil {
+			return nil, err
+		}
+		bucket = info.bucket
+		region = info.region
+	}
+
+	return NewS3Uploader(endpoint, region, bucket, path, "public-read", accessKey, secretKey, pathStyleAccess), nil
+}
+
 type s3Info struct {
 	region string
 	bucket string
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/defaults"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/grafana/grafana/pkg/infra/log"
@@ -31,6 +33,10 @@ type S3Uploader struct {
 	accessKey       string
 	pathStyleAccess bool
 	log             log.Logger
+
+	// client is shared by ListImages and DeleteImage, see s3Client
+	clientMtx sync.Mutex
+	client    *s3.S3
 }
 
 func NewS3Uploader(endpoint, region, bucket, path, acl, accessKey, secretKey string, pathStyleAccess bool) *S3Uploader {
@@ -47,10 +53,17 @@ func NewS3Uploader(endpoint, region, bucket, path, acl, accessKey, secretKey str
 	}
 }
 
-func (u *S3Uploader) Upload(ctx context.Context, imageDiskPath string) (string, error) {
+// StoredImage is an image uploaded to the bucket.
+type StoredImage struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+func (u *S3Uploader) newSession() (*session.Session, error) {
 	sess, err := session.NewSession()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	creds := credentials.NewChainCredentials(
 		[]credentials.Provider{
@@ -69,6 +82,10 @@ func (u *S3Uploader) Upload(ctx context.Context, imageDiskPath string) (string,
 		Credentials:      creds,
 	}
 
+	return session.NewSession(cfg)
+}
+
+func (u *S3Uploader) Upload(ctx context.Context, imageDiskPath string) (string, error) {
 	rand, err := util.GetRandomString(20)
 	if err != nil {
 		return "", err
@@ -82,7 +99,7 @@ func (u *S3Uploader) Upload(ctx context.Context, imageDiskPath string) (string,
 	}
 	defer file.Close()
 
-	sess, err = session.NewSession(cfg)
+	sess, err := u.newSession()
 	if err != nil {
 		return "", err
 	}
@@ -100,6 +117,68 @@ func (u *S3Uploader) Upload(ctx context.Context, imageDiskPath string) (string,
 	return result.Location, nil
 }
 
+// s3Client returns a client that is created once, so deleting many images
+// doesn't resolve the credentials for every single one.
+func (u *S3Uploader) s3Client() (*s3.S3, error) {
+	u.clientMtx.Lock()
+	defer u.clientMtx.Unlock()
+
+	if u.client == nil {
+		sess, err := u.newSession()
+		if err != nil {
+			return nil, err
+		}
+		u.client = s3.New(sess)
+	}
+
+	return u.client, nil
+}
+
+// ListImages returns the images stored below the configured path of the bucket.
+func (u *S3Uploader) ListImages(ctx context.Context) ([]StoredImage, error) {
+	client, err := u.s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	var images []StoredImage
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(u.bucket),
+		Prefix: aws.String(u.path),
+	}
+	err = client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			images = append(images, StoredImage{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+
+	return images, err
+}
+
+// Path returns the prefix of the keys the images are uploaded with.
+func (u *S3Uploader) Path() string {
+	return u.path
+}
+
+// DeleteImage deletes the image with the given key from the bucket.
+func (u *S3Uploader) DeleteImage(ctx context.Context, key string) error {
+	client, err := u.s3Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(u.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
 func webIdentityProvider(sess client.ConfigProvider) credentials.Provider {
 	svc := sts.New(sess)
 
@@ -89,3 +89,28 @@ type UserUpdated struct {
 	Login     string    `json:"login"`
 	Email     string    `json:"email"`
 }
+
+// CleanupDeletionThresholdExceeded is published when a single run of a
+// cleanup task deleted more than the configured deletion threshold.
+type CleanupDeletionThresholdExceeded struct {
+	Timestamp time.Time `json:"timestamp"`
+	Task      string    `json:"task"`
+	Deleted   int64     `json:"deleted"`
+	Threshold int64     `json:"threshold"`
+}
+
+// CleanupTaskDeleted is published for the audit trail after every run of a
+// cleanup task that deleted rows or files. Services caching data of the
+// deleted rows can listen to it to drop their entries.
+type CleanupTaskDeleted struct {
+	Timestamp time.Time `json:"timestamp"`
+	Task      string    `json:"task"`
+	// Predicate describes which rows or files the task deleted, e.g. the retention cutoff.
+	Predicate string `json:"predicate"`
+	Deleted   int64  `json:"deleted"`
+	// Instance is the instance_name of the Grafana instance that ran the task.
+	Instance string `json:"instance"`
+	// Lock is the server lock the instance held while running the task, empty
+	// for the node local tasks.
+	Lock string `json:"lock,omitempty"`
+}
@@ -104,6 +104,15 @@ var (
 
 	// MRenderingQueue is a metric gauge for image rendering queue size
 	MRenderingQueue prometheus.Gauge
+
+	// MCleanupDeletedTotal is a metric counter for rows/files removed by cleanup tasks
+	MCleanupDeletedTotal *prometheus.CounterVec
+
+	// MCleanupErrorsTotal is a metric counter for failed cleanup task runs
+	MCleanupErrorsTotal *prometheus.CounterVec
+
+	// MCleanupLockSkippedTotal is a metric counter for cleanup task runs skipped because of the server lock
+	MCleanupLockSkippedTotal *prometheus.CounterVec
 )
 
 // Timers
@@ -116,6 +125,12 @@ var (
 
 	// MRenderingSummary is a metric summary for image rendering request duration
 	MRenderingSummary *prometheus.SummaryVec
+
+	// MCleanupDuration is a metric histogram for cleanup task duration
+	MCleanupDuration *prometheus.HistogramVec
+
+	// MTempFilesAge is a metric histogram for the age of the temp files seen by the cleanup
+	MTempFilesAge prometheus.Histogram
 )
 
 // StatTotals
@@ -386,6 +401,50 @@ func init() {
 		Namespace: ExporterName,
 	})
 
+	MCleanupDeletedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "cleanup_deleted_total",
+			Help:      "counter for rows and files removed by cleanup tasks",
+			Namespace: ExporterName,
+		},
+		[]string{"task"},
+	)
+
+	MCleanupErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "cleanup_errors_total",
+			Help:      "counter for failed cleanup task runs",
+			Namespace: ExporterName,
+		},
+		[]string{"task"},
+	)
+
+	MCleanupLockSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "cleanup_lock_skipped_total",
+			Help:      "counter for cleanup task runs skipped because another instance holds or recently held the server lock",
+			Namespace: ExporterName,
+		},
+		[]string{"task"},
+	)
+
+	MCleanupDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:      "cleanup_duration_seconds",
+			Help:      "histogram of cleanup task duration",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+			Namespace: ExporterName,
+		},
+		[]string{"task"},
+	)
+
+	MTempFilesAge = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:      "tempfiles_age_seconds",
+		Help:      "histogram of the age of the temp files at every run of the cleanup",
+		Buckets:   []float64{60, 600, 3600, 6 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600, 30 * 24 * 3600},
+		Namespace: ExporterName,
+	})
+
 	MDataSourceProxyReqTimer = prometheus.NewSummary(prometheus.SummaryOpts{
 		Name:       "api_dataproxy_request_all_milliseconds",
 		Help:       "summary for dataproxy request duration",
@@ -553,6 +612,11 @@ func initMetricVars() {
 		MRenderingRequestTotal,
 		MRenderingSummary,
 		MRenderingQueue,
+		MCleanupDeletedTotal,
+		MCleanupErrorsTotal,
+		MCleanupLockSkippedTotal,
+		MCleanupDuration,
+		MTempFilesAge,
 		MAlertingActiveAlerts,
 		MStatTotalDashboards,
 		MStatTotalUsers,
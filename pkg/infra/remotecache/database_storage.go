@@ -4,7 +4,9 @@ import (
 	"context"
 	"time"
 
+	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 )
 
@@ -12,6 +14,9 @@ var getTime = time.Now
 
 const databaseCacheType = "database"
 
+// expiredCacheDataCleanupTask is the cleanup service task deleting the expired entries in batches.
+const expiredCacheDataCleanupTask = "expired_cache_data"
+
 type databaseCache struct {
 	SQLStore *sqlstore.SqlStore
 	log      log.Logger
@@ -33,11 +38,26 @@ func (dc *databaseCache) Run(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
+			if dc.cleanupTaskActive() {
+				dc.log.Debug("Leaving the garbage collection to the cleanup service")
+				continue
+			}
+
 			dc.internalRunGC()
 		}
 	}
 }
 
+// cleanupTaskActive reports whether the cleanup service currently deletes the
+// expired entries, the garbage collection only runs while it doesn't.
+func (dc *databaseCache) cleanupTaskActive() bool {
+	query := models.IsCleanupTaskActiveQuery{Task: expiredCacheDataCleanupTask}
+	if err := bus.Dispatch(&query); err != nil {
+		return false
+	}
+	return query.Result
+}
+
 func (dc *databaseCache) internalRunGC() {
 	err := dc.SQLStore.WithDbSession(context.Background(), func(session *sqlstore.DBSession) error {
 		now := getTime().Unix()
@@ -4,7 +4,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/stretchr/testify/assert"
 )
@@ -56,6 +58,25 @@ func TestDatabaseStorageGarbageCollection(t *testing.T) {
 	assert.Equal(t, err, nil)
 }
 
+func TestDatabaseStorageGarbageCollectionHandOver(t *testing.T) {
+	t.Cleanup(bus.ClearBusHandlers)
+	db := &databaseCache{log: log.New("remotecache.database")}
+
+	// without the cleanup service the cache collects its own garbage
+	bus.ClearBusHandlers()
+	assert.False(t, db.cleanupTaskActive())
+
+	active := false
+	bus.AddHandler("test", func(query *models.IsCleanupTaskActiveQuery) error {
+		query.Result = active && query.Task == expiredCacheDataCleanupTask
+		return nil
+	})
+	assert.False(t, db.cleanupTaskActive())
+
+	active = true
+	assert.True(t, db.cleanupTaskActive())
+}
+
 func TestSecondSet(t *testing.T) {
 	var err error
 	sqlstore := sqlstore.InitTestDB(t)
@@ -1,5 +1,13 @@
 package serverlock
 
+import "time"
+
+// Lock is a lock of an operation that is run by one server at a time.
+type Lock struct {
+	OperationUID  string
+	LastExecution time.Time
+}
+
 type serverLock struct {
 	Id            int64
 	OperationUid  string
@@ -30,31 +30,180 @@ func (sl *ServerLockService) Init() error {
 // `fn` function when successful. This should not be used at low internal. But services
 // that needs to be run once every ex 10m.
 func (sl *ServerLockService) LockAndExecute(ctx context.Context, actionName string, maxInterval time.Duration, fn func()) error {
+	rowLock, err := sl.tryLock(ctx, actionName, maxInterval)
+	if err != nil {
+		return err
+	}
+
+	if rowLock != nil {
+		fn()
+	}
+
+	return nil
+}
+
+// LockExecuteAndRenew works like LockAndExecute, but keeps renewing the lock
+// every half of `maxInterval` while `fn` runs, so other servers don't take over
+// work that runs longer than `maxInterval`. The context passed to `fn` is
+// cancelled when the lock is lost to another server.
+func (sl *ServerLockService) LockExecuteAndRenew(ctx context.Context, actionName string, maxInterval time.Duration, fn func(ctx context.Context)) error {
+	rowLock, err := sl.tryLock(ctx, actionName, maxInterval)
+	if err != nil || rowLock == nil {
+		return err
+	}
+
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	renewInterval := maxInterval / 2
+	if renewInterval <= 0 {
+		fn(fnCtx)
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(renewInterval)
+		defer ticker.Stop()
+
+		version := rowLock.Version + 1
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewed, err := sl.renewLock(ctx, rowLock.Id, version)
+				if err != nil {
+					// the lock stays ours until another server can take it over
+					sl.log.Warn("Failed to renew server lock", "action", actionName, "error", err)
+					continue
+				}
+				if !renewed {
+					sl.log.Warn("Lost server lock to another server", "action", actionName)
+					cancel()
+					return
+				}
+				version++
+			}
+		}
+	}()
+
+	fn(fnCtx)
+	close(done)
+
+	return nil
+}
+
+// tryLock returns the lock row of the action if this server acquired it, or
+// nil if it was acquired less than `maxInterval` ago.
+func (sl *ServerLockService) tryLock(ctx context.Context, actionName string, maxInterval time.Duration) (*serverLock, error) {
 	// gets or creates a lockable row
 	rowLock, err := sl.getOrCreate(ctx, actionName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// avoid execution if last lock happened less than `maxInterval` ago
 	if rowLock.LastExecution != 0 {
 		lastExecutionTime := time.Unix(rowLock.LastExecution, 0)
 		if lastExecutionTime.Unix() > time.Now().Add(-maxInterval).Unix() {
-			return nil
+			return nil, nil
 		}
 	}
 
 	// try to get lock based on rowLow version
 	acquiredLock, err := sl.acquireLock(ctx, rowLock)
-	if err != nil {
+	if err != nil || !acquiredLock {
+		return nil, err
+	}
+
+	return rowLock, nil
+}
+
+// renewLock moves the last execution of a lock that is still at version to now.
+func (sl *ServerLockService) renewLock(ctx context.Context, id int64, version int64) (bool, error) {
+	var result bool
+
+	err := sl.SQLStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+		sql := `UPDATE server_lock SET
+			version = ?,
+			last_execution = ?
+		WHERE
+			id = ? AND version = ?`
+
+		res, err := dbSession.Exec(sql, version+1, time.Now().Unix(), id, version)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		result = affected == 1
+
 		return err
+	})
+
+	return result, err
+}
+
+// ActiveLocks returns the locks that were acquired since the given time,
+// ordered by their operation.
+func (sl *ServerLockService) ActiveLocks(ctx context.Context, since time.Time) ([]Lock, error) {
+	var rows []*serverLock
+	err := sl.SQLStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+		return dbSession.Where("last_execution >= ?", since.Unix()).Asc("operation_uid").Find(&rows)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if acquiredLock {
-		fn()
+	locks := make([]Lock, 0, len(rows))
+	for _, row := range rows {
+		locks = append(locks, Lock{OperationUID: row.OperationUid, LastExecution: time.Unix(row.LastExecution, 0)})
 	}
 
-	return nil
+	return locks, nil
+}
+
+// DeleteStaleLocks deletes the locks that haven't been acquired since
+// olderThan, e.g. for operations that no longer exist, and returns how many
+// were deleted. A lock acquired while the stale locks are deleted is kept.
+func (sl *ServerLockService) DeleteStaleLocks(ctx context.Context, olderThan time.Time, dryRun bool) (int64, error) {
+	var deleted int64
+
+	err := sl.SQLStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+		// rows that never executed are about to be acquired by getOrCreate's caller
+		var rows []*serverLock
+		err := dbSession.Where("last_execution > 0 AND last_execution < ?", olderThan.Unix()).Find(&rows)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			deleted = int64(len(rows))
+			return nil
+		}
+
+		for _, row := range rows {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// the version changes when the lock is acquired in the meantime
+			res, err := dbSession.Exec("DELETE FROM server_lock WHERE id = ? AND version = ?", row.Id, row.Version)
+			if err != nil {
+				return err
+			}
+
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += affected
+		}
+
+		return nil
+	})
+
+	return deleted, err
 }
 
 func (sl *ServerLockService) acquireLock(ctx context.Context, serverLock *serverLock) (bool, error) {
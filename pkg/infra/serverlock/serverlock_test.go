@@ -3,6 +3,7 @@ package serverlock
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -56,3 +57,90 @@ func TestServerLock(t *testing.T) {
 		assert.False(t, gotLock)
 	})
 }
+
+func TestDeleteStaleLocks(t *testing.T) {
+	sl := createTestableServerLock(t)
+	ctx := context.Background()
+
+	for _, operationUID := range []string{"abandoned-operation", "held-operation"} {
+		require.NoError(t, sl.LockAndExecute(ctx, operationUID, time.Hour, func() {}))
+	}
+	_, err := sl.getOrCreate(ctx, "new-operation")
+	require.NoError(t, err)
+
+	// simulate a lock of an operation that stopped running a year ago
+	abandoned := time.Now().Add(-time.Hour * 24 * 365)
+	err = sl.SQLStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+		_, err := dbSession.Exec("UPDATE server_lock SET last_execution = ? WHERE operation_uid = ?", abandoned.Unix(), "abandoned-operation")
+		return err
+	})
+	require.NoError(t, err)
+
+	olderThan := time.Now().Add(-time.Hour * 24 * 90)
+	deleted, err := sl.DeleteStaleLocks(ctx, olderThan, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	locks, err := sl.ActiveLocks(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, locks, 3)
+
+	deleted, err = sl.DeleteStaleLocks(ctx, olderThan, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	locks, err = sl.ActiveLocks(ctx, olderThan)
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	assert.Equal(t, "held-operation", locks[0].OperationUID)
+
+	// the lock that was never executed is kept for its upcoming run
+	row, err := sl.getOrCreate(ctx, "new-operation")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), row.Id)
+}
+
+func TestLockExecuteAndRenew(t *testing.T) {
+	sl := createTestableServerLock(t)
+	ctx := context.Background()
+	maxInterval := time.Second * 2
+
+	t.Run("task running longer than the interval keeps the lock", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		finished := make(chan error)
+		go func() {
+			finished <- sl.LockExecuteAndRenew(ctx, "long-operation", maxInterval, func(ctx context.Context) {
+				close(started)
+				<-release
+			})
+		}()
+		<-started
+
+		// without renewal the lock taken when the task started would have expired
+		time.Sleep(maxInterval + maxInterval/4)
+		executed := false
+		require.NoError(t, sl.LockAndExecute(ctx, "long-operation", maxInterval, func() { executed = true }))
+		assert.False(t, executed)
+
+		close(release)
+		require.NoError(t, <-finished)
+	})
+
+	t.Run("task is cancelled when the lock is lost", func(t *testing.T) {
+		err := sl.LockExecuteAndRenew(ctx, "lost-operation", maxInterval, func(ctx context.Context) {
+			err := sl.SQLStore.WithDbSession(ctx, func(dbSession *sqlstore.DBSession) error {
+				_, err := dbSession.Exec("UPDATE server_lock SET version = version + 10 WHERE operation_uid = ?", "lost-operation")
+				return err
+			})
+			require.NoError(t, err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(maxInterval * 2):
+				t.Error("task wasn't cancelled after losing its lock")
+			}
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,24 @@
+package models
+
+// CleanupAnnotationsCommand deletes annotations belonging to a dashboard that no longer exists.
+type CleanupAnnotationsCommand struct {
+	// BatchSize is the number of annotations deleted per statement.
+	BatchSize int64
+	// DryRun only counts the orphaned annotations into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
+// DeleteOrphanedAnnotationTagsCommand deletes the annotation_tag rows of
+// annotations that no longer exist and, with PruneUnusedTags, the tags that
+// neither an annotation nor an alert rule uses anymore.
+type DeleteOrphanedAnnotationTagsCommand struct {
+	// BatchSize is the number of rows deleted per statement.
+	BatchSize       int64
+	PruneUnusedTags bool
+	// DryRun only counts the rows into DeletedAnnotationTags and DeletedTags
+	// without deleting them.
+	DryRun                bool
+	DeletedAnnotationTags int64
+	DeletedTags           int64
+}
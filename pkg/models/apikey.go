@@ -45,6 +45,14 @@ type DeleteApiKeyCommand struct {
 	OrgId int64 `json:"-"`
 }
 
+// DeleteExpiredAPIKeysCommand deletes API keys which expired before ExpiredBefore.
+type DeleteExpiredAPIKeysCommand struct {
+	ExpiredBefore time.Time
+	// DryRun only counts the expired keys into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
 // ----------------------
 // QUERIES
 
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DeleteExpiredCacheDataCommand deletes the expired entries of the database
+// remote cache. Entries without an expiration are kept.
+type DeleteExpiredCacheDataCommand struct {
+	// Now is the time the entries are checked for expiration at.
+	Now time.Time
+	// DryRun only counts the expired entries into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
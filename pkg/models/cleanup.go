@@ -0,0 +1,10 @@
+package models
+
+// IsCleanupTaskActiveQuery asks the cleanup service whether one of its tasks
+// currently deletes rows on this instance, so services with their own cleanup
+// loops can leave the work to it. Without a cleanup service to answer, the
+// task counts as inactive.
+type IsCleanupTaskActiveQuery struct {
+	Task   string
+	Result bool
+}
@@ -102,6 +102,14 @@ type UpdateDashboardAclCommand struct {
 	Items       []*DashboardAcl
 }
 
+// DeleteOrphanedDashboardAclCommand deletes the permissions of dashboards, users
+// and teams that no longer exist.
+type DeleteOrphanedDashboardAclCommand struct {
+	// DryRun only counts the orphaned permissions into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
 //
 // QUERIES
 //
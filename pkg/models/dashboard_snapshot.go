@@ -67,9 +67,38 @@ type DeleteDashboardSnapshotCommand struct {
 }
 
 type DeleteExpiredSnapshotsCommand struct {
+	// Now is the time the snapshots are checked for expiration at.
+	Now time.Time
+	// KeepIds are expired snapshots that must not be deleted yet.
+	KeepIds []int64
+	// OrgMaxAge deletes the snapshots of single orgs once they are older, even if they haven't expired yet.
+	OrgMaxAge map[int64]time.Duration
+	// DryRun only counts the expired snapshots into DeletedRows without deleting them.
+	DryRun      bool
 	DeletedRows int64
 }
 
+// TrimSnapshotsOverLimitCommand deletes the oldest snapshots of the orgs that
+// have more snapshots than Limit.
+type TrimSnapshotsOverLimitCommand struct {
+	// Limit is the number of snapshots kept per org, below 0 means unlimited.
+	Limit int64
+	// DryRun only counts the snapshots over the limit without deleting them.
+	DryRun bool
+
+	DeletedRows int64
+	// OrgDeletedRows are the deleted snapshots of each org that was over the limit.
+	OrgDeletedRows map[int64]int64
+}
+
+// GetExpiredExternalSnapshotsQuery finds the expired snapshots published to an external snapshot server.
+type GetExpiredExternalSnapshotsQuery struct {
+	// Now is the time the snapshots are checked for expiration at.
+	Now       time.Time
+	OrgMaxAge map[int64]time.Duration
+	Result    []*DashboardSnapshot
+}
+
 type GetDashboardSnapshotQuery struct {
 	Key       string
 	DeleteKey string
@@ -82,5 +82,15 @@ type GetDashboardVersionsQuery struct {
 //
 
 type DeleteExpiredVersionsCommand struct {
+	// DryRun only counts the expired versions into DeletedRows without deleting them.
+	DryRun bool
+	// MinVersionsToKeep is the number of latest versions kept for every dashboard, defaults to 1.
+	MinVersionsToKeep int
+	// OrgVersionsToKeep overrides the number of versions to keep for the dashboards of single orgs.
+	OrgVersionsToKeep map[int64]int
+	// BatchSize is the number of versions deleted per transaction, defaults to 100.
+	BatchSize int
+	// BatchDelay is how long to pause between two batches, so dashboard saves are not stalled.
+	BatchDelay  time.Duration
 	DeletedRows int64
 }
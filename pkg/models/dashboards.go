@@ -441,6 +441,22 @@ type GetProvisionedDashboardDataQuery struct {
 	Result []*DashboardProvisioning
 }
 
+// GetDashboardProvisionerNamesQuery returns the names of the provisioners
+// that have provisioned dashboards.
+type GetDashboardProvisionerNamesQuery struct {
+	Result []string
+}
+
+// DeleteStaleDashboardProvisioningCommand deletes the provisioning records of
+// dashboards that no longer exist, as well as the records of the
+// UnknownProvisioners.
+type DeleteStaleDashboardProvisioningCommand struct {
+	UnknownProvisioners []string
+	// DryRun only counts the stale records into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
 type GetDashboardsBySlugQuery struct {
 	OrgId int64
 	Slug  string
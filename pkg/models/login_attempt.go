@@ -22,7 +22,9 @@ type CreateLoginAttemptCommand struct {
 }
 
 type DeleteOldLoginAttemptsCommand struct {
-	OlderThan   time.Time
+	OlderThan time.Time
+	// DryRun only counts the old login attempts into DeletedRows without deleting them.
+	DryRun      bool
 	DeletedRows int64
 }
 
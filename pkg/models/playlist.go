@@ -85,6 +85,17 @@ type DeletePlaylistCommand struct {
 	OrgId int64
 }
 
+// DeleteOrphanedPlaylistItemsCommand deletes the playlist items of deleted
+// playlists and the items referring to dashboards that no longer exist in
+// the org of their playlist.
+type DeleteOrphanedPlaylistItemsCommand struct {
+	// UnusedTags also deletes the items of tags no dashboard of the org has.
+	UnusedTags bool
+	// DryRun only counts the orphaned items into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
 //
 // QUERIES
 //
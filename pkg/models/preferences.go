@@ -52,3 +52,11 @@ type SavePreferencesCommand struct {
 	Timezone        string `json:"timezone"`
 	Theme           string `json:"theme"`
 }
+
+// DeleteOrphanedPreferencesCommand deletes the preferences of users and teams
+// that no longer exist.
+type DeleteOrphanedPreferencesCommand struct {
+	// DryRun only counts the orphaned preferences into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
@@ -23,6 +23,14 @@ type UnstarDashboardCommand struct {
 	DashboardId int64
 }
 
+// DeleteOrphanedStarsCommand deletes the stars of dashboards and users that no
+// longer exist.
+type DeleteOrphanedStarsCommand struct {
+	// DryRun only counts the orphaned stars into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
 // ---------------------
 // QUERIES
 
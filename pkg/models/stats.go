@@ -92,6 +92,13 @@ type GetSystemUserCountStatsQuery struct {
 	Result *SystemUserCountStats
 }
 
+// GetTableRowCountQuery counts all rows of a table, e.g. to verify the rows
+// a cleanup task deleted.
+type GetTableRowCountQuery struct {
+	Table  string
+	Result int64
+}
+
 type UserStats struct {
 	Users   int64
 	Admins  int64
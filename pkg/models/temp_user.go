@@ -17,8 +17,19 @@ const (
 	TmpUserInvitePending TempUserStatus = "InvitePending"
 	TmpUserCompleted     TempUserStatus = "Completed"
 	TmpUserRevoked       TempUserStatus = "Revoked"
+	// TmpUserInviteExpired and TmpUserSignUpExpired mark expired invites and
+	// sign ups that are kept for Cfg.UserInviteRecoveryWindow before they are deleted.
+	TmpUserInviteExpired TempUserStatus = "InviteExpired"
+	TmpUserSignUpExpired TempUserStatus = "SignUpExpired"
 )
 
+// ExpiredTempUserStatuses maps the statuses of the invites and sign ups that
+// can still be used to the status they are marked with once they expired.
+var ExpiredTempUserStatuses = map[TempUserStatus]TempUserStatus{
+	TmpUserInvitePending: TmpUserInviteExpired,
+	TmpUserSignUpStarted: TmpUserSignUpExpired,
+}
+
 // TempUser holds data for org invites and unconfirmed sign ups
 type TempUser struct {
 	Id              int64
@@ -64,6 +75,40 @@ type UpdateTempUserWithEmailSentCommand struct {
 	Code string
 }
 
+// DeleteExpiredUserInvitesCommand deletes the invites and sign ups created before OlderThan.
+type DeleteExpiredUserInvitesCommand struct {
+	OlderThan time.Time
+	// Statuses limits the deletion to rows with one of the statuses, all rows are deleted when it's empty.
+	Statuses []TempUserStatus
+	// UpdatedBefore limits the deletion to rows last updated before it, e.g.
+	// marked as expired by MarkExpiredUserInvitesCommand. Ignored when zero.
+	UpdatedBefore time.Time
+	// DryRun only counts the expired invites into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
+// MarkExpiredUserInvitesCommand changes the status of the invites and sign ups
+// with Status that were created before OlderThan to ExpiredStatus, and their
+// update time to MarkedAt.
+type MarkExpiredUserInvitesCommand struct {
+	OlderThan     time.Time
+	Status        TempUserStatus
+	ExpiredStatus TempUserStatus
+	MarkedAt      time.Time
+	// DryRun only counts the expired invites into MarkedRows without marking them.
+	DryRun     bool
+	MarkedRows int64
+}
+
+// DeleteDuplicateUserInvitesCommand deletes the pending invites that have been
+// superseded by a newer pending invite for the same email and org.
+type DeleteDuplicateUserInvitesCommand struct {
+	// DryRun only counts the duplicate invites into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
 type GetTempUsersQuery struct {
 	OrgId  int64
 	Email  string
@@ -0,0 +1,12 @@
+package models
+
+// FindUploadReferencesQuery looks for the uploaded files the dashboards and
+// data sources still refer to. A file counts as referenced when its name is
+// part of the JSON model of a dashboard, or the URL or JSON data of a data
+// source.
+type FindUploadReferencesQuery struct {
+	// Names are the names of the files, relative to the uploads directory.
+	Names []string
+	// Result holds the referenced names.
+	Result map[string]bool
+}
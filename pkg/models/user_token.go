@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 // Typed errors
@@ -30,6 +31,16 @@ type RevokeAuthTokenCmd struct {
 	AuthTokenId int64 `json:"authTokenId"`
 }
 
+// DeleteExpiredAuthTokensCommand deletes the user auth tokens created before
+// CreatedBefore or last rotated before RotatedBefore.
+type DeleteExpiredAuthTokensCommand struct {
+	CreatedBefore time.Time
+	RotatedBefore time.Time
+	// DryRun only counts the expired tokens into DeletedRows without deleting them.
+	DryRun      bool
+	DeletedRows int64
+}
+
 // UserTokenService are used for generating and validating user tokens
 type UserTokenService interface {
 	CreateToken(ctx context.Context, userId int64, clientIP, userAgent string) (*UserToken, error)
@@ -0,0 +1,9 @@
+package models
+
+// VacuumDatabaseCommand hands the pages freed by deleted rows back to the file
+// system. Only SQLite databases are vacuumed.
+type VacuumDatabaseCommand struct {
+	// Vacuumed is false when the database doesn't support vacuuming.
+	Vacuumed       bool
+	ReclaimedBytes int64
+}
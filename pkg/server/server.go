@@ -34,7 +34,7 @@ import (
 	"github.com/grafana/grafana/pkg/registry"
 	_ "github.com/grafana/grafana/pkg/services/alerting"
 	_ "github.com/grafana/grafana/pkg/services/auth"
-	_ "github.com/grafana/grafana/pkg/services/cleanup"
+	"github.com/grafana/grafana/pkg/services/cleanup"
 	_ "github.com/grafana/grafana/pkg/services/notifications"
 	_ "github.com/grafana/grafana/pkg/services/provisioning"
 	_ "github.com/grafana/grafana/pkg/services/rendering"
@@ -102,7 +102,8 @@ type Server struct {
 	commit      string
 	buildBranch string
 
-	HTTPServer *api.HTTPServer `inject:""`
+	HTTPServer     *api.HTTPServer         `inject:""`
+	CleanUpService *cleanup.CleanUpService `inject:""`
 }
 
 // init initializes the server and its services.
@@ -226,6 +227,21 @@ func (s *Server) Shutdown(reason string) {
 	}
 }
 
+// RunCleanup runs all cleanup tasks once because the process received the
+// cleanup signal. Signals received before the services are initialized are
+// ignored.
+func (s *Server) RunCleanup(sig os.Signal) {
+	s.mtx.Lock()
+	initialized := s.isInitialized && s.CleanUpService != nil
+	s.mtx.Unlock()
+	if !initialized {
+		s.log.Info("Ignoring cleanup signal received during startup", "signal", sig)
+		return
+	}
+
+	s.CleanUpService.RunOnSignal(s.context, sig)
+}
+
 // ExitCode returns an exit code for a given error.
 func (s *Server) ExitCode(reason error) int {
 	code := 1
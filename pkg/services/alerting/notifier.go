@@ -202,6 +202,7 @@ func (n *notificationService) renderAndUploadImage(evalCtx *EvalContext, timeout
 		OrgId:           evalCtx.Rule.OrgID,
 		OrgRole:         models.ROLE_ADMIN,
 		ConcurrentLimit: setting.AlertingRenderLimit,
+		FilePrefix:      rendering.AlertImagePrefix,
 	}
 
 	ref, err := evalCtx.GetDashboardUID()
@@ -4,9 +4,14 @@ import (
 	"context"
 	"time"
 
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
 )
 
+// expiredTokensCleanupTask is the cleanup service task deleting the same tokens.
+const expiredTokensCleanupTask = "expired_auth_tokens"
+
 func (srv *UserAuthTokenService) Run(ctx context.Context) error {
 	ticker := time.NewTicker(time.Hour)
 	maxInactiveLifetime := time.Duration(srv.Cfg.LoginMaxInactiveLifetimeDays) * 24 * time.Hour
@@ -24,6 +29,11 @@ func (srv *UserAuthTokenService) Run(ctx context.Context) error {
 	for {
 		select {
 		case <-ticker.C:
+			if srv.cleanupTaskActive() {
+				srv.log.Debug("Leaving the cleanup of expired auth tokens to the cleanup service")
+				continue
+			}
+
 			err := srv.ServerLockService.LockAndExecute(ctx, "cleanup expired auth tokens", time.Hour*12, func() {
 				if _, err := srv.deleteExpiredTokens(ctx, maxInactiveLifetime, maxLifetime); err != nil {
 					srv.log.Error("An error occurred while deleting expired tokens", "err", err)
@@ -39,6 +49,16 @@ func (srv *UserAuthTokenService) Run(ctx context.Context) error {
 	}
 }
 
+// cleanupTaskActive reports whether the cleanup service currently deletes the
+// expired tokens, this job only runs while it doesn't.
+func (srv *UserAuthTokenService) cleanupTaskActive() bool {
+	query := models.IsCleanupTaskActiveQuery{Task: expiredTokensCleanupTask}
+	if err := bus.Dispatch(&query); err != nil {
+		return false
+	}
+	return query.Result
+}
+
 func (srv *UserAuthTokenService) deleteExpiredTokens(ctx context.Context, maxInactiveLifetime, maxLifetime time.Duration) (int64, error) {
 	createdBefore := getTime().Add(-maxLifetime)
 	rotatedBefore := getTime().Add(-maxInactiveLifetime)
@@ -0,0 +1,26 @@
+package cleanup
+
+import (
+	"sync/atomic"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// isTaskActive answers models.IsCleanupTaskActiveQuery. A task is active while
+// it's registered and scheduled by Run, and the cleanup is neither paused, in
+// maintenance nor a dry run. A task whose latest run failed isn't active
+// until it succeeds again.
+func (srv *CleanUpService) isTaskActive(query *models.IsCleanupTaskActiveQuery) error {
+	query.Result = false
+	if atomic.LoadInt32(&srv.scheduling) == 0 || srv.Cfg.CleanupDryRun || srv.Paused() || srv.inMaintenance() {
+		return nil
+	}
+
+	for _, task := range srv.registeredTasks() {
+		if task.Name() == query.Task {
+			query.Result = srv.statuses.get(task.Name()).ConsecutiveFailures == 0
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package cleanup
+
+import (
+	"context"
+	"time"
+)
+
+// cleanUpAlertImageBucket deletes the alert notification images uploaded to
+// the S3 external image storage that are older than Cfg.AlertingImageRetention.
+func (srv *CleanUpService) cleanUpAlertImageBucket(ctx context.Context) (int64, error) {
+	return srv.pruneFiles(ctx, filePruning{
+		task:    taskAlertImageBucket,
+		storage: srv.alertImageBucket,
+		expired: srv.shouldCleanupAlertImage,
+	}, srv.clock())
+}
+
+func (srv *CleanUpService) shouldCleanupAlertImage(file TempFile, age time.Time, now time.Time) bool {
+	if srv.Cfg.AlertingImageRetention <= 0 {
+		return false
+	}
+
+	return age.Add(srv.Cfg.AlertingImageRetention).Before(now)
+}
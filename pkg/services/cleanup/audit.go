@@ -0,0 +1,99 @@
+package cleanup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// auditLog receives an entry for every task run that deleted rows or files,
+// it can be routed to its own level or output with the cleanup.audit filter.
+var auditLog = log.New("cleanup.audit")
+
+// auditDeletion records a task run that deleted rows or files in the audit
+// log and publishes it as an events.CleanupTaskDeleted. now is the time the
+// task computed its retention cutoffs from.
+func (srv *CleanUpService) auditDeletion(task CleanupTask, now time.Time, deleted int64) {
+	if deleted <= 0 || srv.Cfg.CleanupDryRun {
+		return
+	}
+
+	event := &events.CleanupTaskDeleted{
+		Timestamp: srv.clock(),
+		Task:      task.Name(),
+		Predicate: srv.taskPredicate(task.Name(), now),
+		Deleted:   deleted,
+		Instance:  setting.InstanceName,
+		Lock:      taskLockName(task),
+	}
+	auditLog.Info("Cleanup task deleted data", "task", event.Task, "predicate", event.Predicate, "deleted", event.Deleted,
+		"instance", event.Instance, "lock", event.Lock)
+	if err := bus.Publish(event); err != nil {
+		srv.log.Error("Failed to publish cleanup audit event", "task", event.Task, "error", err)
+	}
+}
+
+// taskPredicate describes which rows or files the built-in task deletes when
+// run at now. Tasks registered by other services have no description.
+func (srv *CleanUpService) taskPredicate(task string, now time.Time) string {
+	cutoff := func(retention time.Duration) string {
+		return now.Add(-retention).UTC().Format(time.RFC3339)
+	}
+
+	switch task {
+	case taskTmpFiles:
+		return fmt.Sprintf("modified before %s, or the oldest over temp_data_max_size", cutoff(srv.Cfg.TempDataLifetime))
+	case taskExportFiles:
+		return fmt.Sprintf("modified before %s, or the oldest over export_data_max_size", cutoff(srv.Cfg.ExportDataLifetime))
+	case taskAlertImageBucket:
+		return fmt.Sprintf("uploaded to the path of [external_image_storage.s3] before %s", cutoff(srv.Cfg.AlertingImageRetention))
+	case taskUploadedFiles:
+		return fmt.Sprintf("modified before %s and not referred to by a dashboard or data source", cutoff(srv.Cfg.UploadDataLifetime))
+	case taskExpiredSnapshots:
+		return fmt.Sprintf("expired before %s", cutoff(0))
+	case taskSnapshotsOverLimit:
+		return fmt.Sprintf("the oldest of an org with more than %d snapshots", srv.Cfg.SnapshotMaxPerOrg)
+	case taskExpiredDashboardVersions:
+		return fmt.Sprintf("more than %d versions of a dashboard", setting.DashboardVersionsToKeep)
+	case taskOldAnnotations:
+		return "older than max_age or above max_annotations_to_keep of their type, and their unused tags"
+	case taskOrphanedAnnotations:
+		return "dashboard deleted"
+	case taskOldLoginAttempts:
+		return fmt.Sprintf("created before %s", cutoff(srv.Cfg.LoginAttemptsRetention))
+	case taskExpiredAPIKeys:
+		return fmt.Sprintf("expired before %s", cutoff(srv.Cfg.ExpiredTokenRetention))
+	case taskExpiredUserInvites:
+		return fmt.Sprintf("created before %s", cutoff(time.Duration(srv.Cfg.UserInviteMaxLifetimeDays)*24*time.Hour))
+	case taskExpiredAuthTokens:
+		return fmt.Sprintf("created before %s or rotated before %s", cutoff(time.Duration(srv.Cfg.LoginMaxLifetimeDays)*24*time.Hour),
+			cutoff(time.Duration(srv.Cfg.LoginMaxInactiveLifetimeDays)*24*time.Hour))
+	case taskOrphanedDashboardAcl:
+		return "dashboard, user or team deleted"
+	case taskStaleServerLocks:
+		return fmt.Sprintf("last acquired before %s", cutoff(srv.Cfg.ServerLockRetention))
+	case taskOrphanedPreferences:
+		return "user or team deleted"
+	case taskOrphanedStars:
+		return "dashboard or user deleted"
+	case taskExpiredCacheData:
+		return fmt.Sprintf("expired before %s", cutoff(0))
+	case taskOrphanedPlaylistItems:
+		if srv.Cfg.CleanupUnusedPlaylistTags {
+			return "playlist or dashboard deleted, or tag unused"
+		}
+		return "playlist or dashboard deleted"
+	case taskDuplicateUserInvites:
+		return "pending invite superseded by a newer one"
+	case taskStaleDashboardProvision:
+		return "dashboard deleted or provisioner unknown"
+	case taskDeepScrub:
+		return "annotation, permission, star, preference or provisioning of a deleted dashboard, user or team"
+	}
+
+	return ""
+}
@@ -2,139 +2,1513 @@ package cleanup
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
-	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tlog "github.com/opentracing/opentracing-go/log"
+
 	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/metrics"
 	"github.com/grafana/grafana/pkg/infra/serverlock"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/registry"
 	"github.com/grafana/grafana/pkg/services/annotations"
+	"github.com/grafana/grafana/pkg/services/provisioning"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
 )
 
 type CleanUpService struct {
-	log               log.Logger
+	log log.Logger
+	// resultLogLevel is the level the outcome of every task run is logged at, see logResult.
+	resultLogLevel log.Lvl
+
 	Cfg               *setting.Cfg                  `inject:""`
 	ServerLockService *serverlock.ServerLockService `inject:""`
+
+	// ProvisioningService tells which dashboard provisioners are configured.
+	ProvisioningService provisioning.ProvisioningService `inject:""`
+
+	// runMtx makes sure a single instance never runs two cleanup cycles at once.
+	runMtx sync.Mutex
+	// paused is set to 1 while the scheduled cycles are paused, see Pause.
+	paused int32
+	// maintenanceCheck skips the scheduled cycles while it reports maintenance, see SetMaintenanceCheck.
+	maintenanceMtx   sync.RWMutex
+	maintenanceCheck func() bool
+	// scheduling is set to 1 while Run schedules the tasks, see isTaskActive.
+	scheduling int32
+	// signalRunning is set to 1 while a signal triggered cleanup runs, see RunOnSignal.
+	signalRunning int32
+
+	// clock returns the current time the tasks compare retentions against,
+	// it defaults to time.Now and is frozen by tests.
+	clock func() time.Time
+
+	tasksMtx sync.RWMutex
+	tasks    []CleanupTask
+	// retentionPolicies replace the lifetime settings of tasks, see SetRetentionPolicy.
+	retentionPolicies map[string]RetentionPolicy
+
+	// running holds the names of the tasks currently being executed on this instance.
+	runningMtx sync.Mutex
+	running    map[string]bool
+
+	statuses taskStatuses
+
+	// subscribers are called after every task run, see Subscribe.
+	subscribersMtx sync.RWMutex
+	subscribers    []func(CleanupEvent)
+
+	// workers holds a token for every task currently running on this instance.
+	workers chan struct{}
+
+	// vacuumMtx guards the rows deleted from the database since it was last vacuumed.
+	vacuumMtx          sync.Mutex
+	deletedSinceVacuum int64
+
+	// tempDataExcludePatterns holds the valid patterns of Cfg.TempDataExcludePatterns.
+	tempDataExcludePatterns []string
+	tempStorage             TempStorage
+	// alertImageBucket holds the alert notification images uploaded to S3, see Cfg.CleanupAlertImageBucket.
+	alertImageBucket TempStorage
+
+	// tempDataExtensionLifetimes are the valid Cfg.TempDataLifetimeByExtension
+	// entries by lower case extension, e.g. ".csv".
+	tempDataExtensionLifetimes map[string]time.Duration
+	// tempDataReadOnly turns off the tmp_files task when Cfg.ImagesDir isn't writable.
+	tempDataReadOnly bool
+	// foreignFiles are the temp files skipped by the latest run because they
+	// belong to another user, so each is only logged once.
+	foreignFiles map[string]bool
+}
+
+// TaskSummary is the outcome of a single cleanup task.
+type TaskSummary struct {
+	Deleted int64  `json:"deleted"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	Duration time.Duration `json:"-"`
+
+	err error
+}
+
+// runOnceLockInterval is the server lock interval used by RunOnce, so an
+// on demand cleanup isn't skipped because of the latest scheduled one.
+const runOnceLockInterval = time.Minute
+
+// maxStartupDelay bounds the random delay before the first run of the tasks
+// guarded by a server lock, so restarted HA instances don't all race for the
+// same locks at once.
+const maxStartupDelay = time.Second * 30
+
+var startupDelay = func() time.Duration {
+	// the global source isn't seeded, every instance would wait just as long
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return time.Duration(r.Int63n(int64(maxStartupDelay)))
+}
+
+// firstCycleDelay returns how long Run waits before its first cycle, between
+// Cfg.CleanupStartupDelay and twice as long so instances started together
+// spread out.
+func (srv *CleanUpService) firstCycleDelay(r *rand.Rand) time.Duration {
+	delay := srv.Cfg.CleanupStartupDelay
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay + time.Duration(r.Int63n(int64(delay)))
+}
+
+// scheduleJitter is the share of a task interval the schedule of every run is
+// moved by at random, staggering the lock attempts of the instances in a HA setup.
+const scheduleJitter = 0.1
+
+// scheduledLockInterval returns the server lock interval of the scheduled runs
+// of a task. Its runs come up to scheduleJitter of the interval early and may
+// start later in their cycle than the previous run did, so the lock leaves
+// twice the jitter as margin. Otherwise runs would find the lock still held
+// by their own previous run and skip.
+func scheduledLockInterval(interval time.Duration) time.Duration {
+	return interval - 2*time.Duration(float64(interval)*scheduleJitter)
 }
 
+// jitter returns the interval moved by up to ±scheduleJitter of it.
+func jitter(r *rand.Rand, interval time.Duration) time.Duration {
+	maxJitter := int64(float64(interval) * scheduleJitter)
+	if maxJitter <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(r.Int63n(2*maxJitter+1)-maxJitter)
+}
+
+// futureModTimeTolerance is how far in the future a temp file may have been
+// modified before clock skew is reported.
+const futureModTimeTolerance = time.Minute
+
+// Task names, used as the `task` label of the cleanup metrics.
+const (
+	taskTmpFiles                 = "tmp_files"
+	taskExpiredSnapshots         = "expired_snapshots"
+	taskSnapshotsOverLimit       = "snapshots_over_limit"
+	taskExpiredDashboardVersions = "expired_dashboard_versions"
+	taskOldAnnotations           = "old_annotations"
+	taskOldLoginAttempts         = "old_login_attempts"
+	taskExpiredAPIKeys           = "expired_api_keys"
+	taskOrphanedAnnotations      = "orphaned_annotations"
+	taskExpiredUserInvites       = "expired_user_invites"
+	taskExpiredAuthTokens        = "expired_auth_tokens"
+	taskOrphanedDashboardAcl     = "orphaned_dashboard_acl"
+	taskStaleServerLocks         = "stale_server_locks"
+	taskOrphanedPreferences      = "orphaned_preferences"
+	taskOrphanedStars            = "orphaned_stars"
+	taskOrphanedPlaylistItems    = "orphaned_playlist_items"
+	taskDuplicateUserInvites     = "duplicate_user_invites"
+	taskExportFiles              = "export_files"
+	taskUploadedFiles            = "uploaded_files"
+	taskAlertImageBucket         = "alert_image_bucket"
+	taskExpiredCacheData         = "expired_cache_data"
+	taskStaleDashboardProvision  = "stale_dashboard_provisioning"
+	taskDeepScrub                = "deep_scrub"
+)
+
 func init() {
 	registry.RegisterService(&CleanUpService{})
 }
 
 func (srv *CleanUpService) Init() error {
 	srv.log = log.New("cleanup")
+	srv.resultLogLevel = log.LvlDebug
+	if srv.Cfg.CleanupLogLevel == "info" {
+		srv.resultLogLevel = log.LvlInfo
+	}
+	if srv.clock == nil {
+		srv.clock = time.Now
+	}
+
+	srv.tempStorage = &localTempStorage{srv: srv, dir: srv.Cfg.ImagesDir, trash: true}
+	srv.tempDataReadOnly = false
+	if srv.Cfg.CleanupTempFiles.Enabled && !srv.Cfg.TempDataForceCleanup {
+		if err := probeWritable(srv.Cfg.ImagesDir); err != nil {
+			// a read-only or shared mount would fail every removal on every run
+			srv.log.Warn("Temp data directory isn't writable, not cleaning up temp files until restart. Set temp_data_force_cleanup to clean up anyway",
+				"dir", srv.Cfg.ImagesDir, "error", err)
+			srv.tempDataReadOnly = true
+		}
+	}
+
+	srv.alertImageBucket = nil
+	if srv.Cfg.CleanupAlertImageBucket.Enabled {
+		bucket, err := newAlertImageBucket()
+		if err != nil {
+			return fmt.Errorf("failed to set up the alert image bucket cleanup: %w", err)
+		}
+		srv.alertImageBucket = bucket
+	}
+
+	srv.tasksMtx.Lock()
+	// tasks registered by other services before Init run after the built-in ones
+	srv.tasks = append(srv.builtinTasks(), srv.tasks...)
+	for _, task := range srv.tasks {
+		initTaskMetrics(task.Name())
+	}
+	srv.tasksMtx.Unlock()
+
+	concurrency := srv.Cfg.CleanupConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	srv.workers = make(chan struct{}, concurrency)
+
+	bus.AddHandler("cleanup", srv.isTaskActive)
+
+	if srv.Cfg.TempDataMinFreeSpace > 0 && !diskSpaceSupported {
+		srv.log.Warn("Checking free disk space isn't supported on this platform, ignoring temp_data_min_free_space")
+	}
+	if srv.Cfg.TempDataTimeSource != "" && srv.Cfg.TempDataTimeSource != "mtime" && !fileTimesSupported {
+		srv.log.Warn("Only modification times are supported on this platform, ignoring temp_data_time_source", "source", srv.Cfg.TempDataTimeSource)
+	}
+	if srv.Cfg.TempDataCriticalFreeSpace > 0 && !diskSpaceSupported {
+		srv.log.Warn("Checking free disk space isn't supported on this platform, ignoring temp_data_critical_free_space")
+	}
+
+	srv.tempDataExcludePatterns = nil
+	for _, pattern := range srv.Cfg.TempDataExcludePatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			srv.log.Warn("Ignoring invalid temp data exclude pattern", "pattern", pattern, "error", err)
+			continue
+		}
+		srv.tempDataExcludePatterns = append(srv.tempDataExcludePatterns, pattern)
+	}
+
+	srv.tempDataExtensionLifetimes = map[string]time.Duration{}
+	for _, entry := range srv.Cfg.TempDataLifetimeByExtension {
+		ext, lifetime, err := parseExtensionLifetime(entry)
+		if err != nil {
+			srv.log.Warn("Ignoring invalid temp data lifetime by extension", "entry", entry, "error", err)
+			continue
+		}
+		srv.tempDataExtensionLifetimes[ext] = lifetime
+	}
+
+	return nil
+}
+
+// builtinTasks returns the cleanup tasks shipped with Grafana that are
+// enabled in the [cleanup] config section.
+func (srv *CleanUpService) builtinTasks() []CleanupTask {
+	builtin := []struct {
+		task    *cleanupTask
+		enabled bool
+	}{
+		// temp files live in the node local ImagesDir so every node cleans its own.
+		{&cleanupTask{name: taskTmpFiles, interval: srv.Cfg.CleanupTempFiles.Interval, run: srv.cleanUpTmpFiles}, srv.Cfg.CleanupTempFiles.Enabled && !srv.tempDataReadOnly},
+		{&cleanupTask{name: taskExportFiles, interval: srv.Cfg.CleanupExportFiles.Interval, run: srv.cleanUpExportFiles}, srv.Cfg.CleanupExportFiles.Enabled},
+		{&cleanupTask{name: taskUploadedFiles, lockName: "delete uploaded files", interval: srv.Cfg.CleanupUploadedFiles.Interval, run: srv.cleanUpUploadedFiles}, srv.Cfg.CleanupUploadedFiles.Enabled},
+		// the bucket is shared by all nodes
+		{&cleanupTask{name: taskAlertImageBucket, lockName: "delete alert image bucket", interval: srv.Cfg.CleanupAlertImageBucket.Interval, run: srv.cleanUpAlertImageBucket}, srv.Cfg.CleanupAlertImageBucket.Enabled && srv.alertImageBucket != nil},
+		{&cleanupTask{name: taskExpiredSnapshots, lockName: "delete expired snapshots", interval: srv.Cfg.CleanupExpiredSnapshots.Interval, run: srv.deleteExpiredSnapshots}, srv.Cfg.CleanupExpiredSnapshots.Enabled},
+		{&cleanupTask{name: taskSnapshotsOverLimit, lockName: "delete snapshots over limit", interval: srv.Cfg.CleanupSnapshotsOverLimit.Interval, run: srv.trimSnapshotsOverLimit}, srv.Cfg.CleanupSnapshotsOverLimit.Enabled},
+		{&cleanupTask{name: taskExpiredDashboardVersions, lockName: "delete expired dashboard versions", interval: srv.Cfg.CleanupExpiredVersions.Interval, run: srv.deleteExpiredDashboardVersions}, srv.Cfg.CleanupExpiredVersions.Enabled},
+		{&cleanupTask{name: taskOldAnnotations, lockName: "delete old annotations", interval: srv.Cfg.CleanupOldAnnotations.Interval, run: srv.cleanUpOldAnnotations}, srv.Cfg.CleanupOldAnnotations.Enabled},
+		{&cleanupTask{name: taskOrphanedAnnotations, lockName: "delete orphaned annotations", interval: srv.Cfg.CleanupOrphanedAnnotations.Interval, run: srv.deleteOrphanedAnnotations}, srv.Cfg.CleanupOrphanedAnnotations.Enabled},
+		{&cleanupTask{name: taskOldLoginAttempts, lockName: "delete old login attempts", interval: srv.Cfg.CleanupOldLoginAttempts.Interval, run: srv.deleteOldLoginAttempts}, srv.Cfg.CleanupOldLoginAttempts.Enabled},
+		{&cleanupTask{name: taskExpiredAPIKeys, lockName: "delete expired api keys", interval: srv.Cfg.CleanupExpiredAPIKeys.Interval, run: srv.deleteExpiredAPIKeys}, srv.Cfg.CleanupExpiredAPIKeys.Enabled},
+		{&cleanupTask{name: taskExpiredUserInvites, lockName: "delete expired user invites", interval: srv.Cfg.CleanupExpiredUserInvites.Interval, run: srv.deleteExpiredUserInvites}, srv.Cfg.CleanupExpiredUserInvites.Enabled},
+		{&cleanupTask{name: taskExpiredAuthTokens, lockName: "delete expired auth tokens", interval: srv.Cfg.CleanupExpiredAuthTokens.Interval, run: srv.deleteExpiredAuthTokens}, srv.Cfg.CleanupExpiredAuthTokens.Enabled},
+		{&cleanupTask{name: taskOrphanedDashboardAcl, lockName: "delete orphaned dashboard acl", interval: srv.Cfg.CleanupOrphanedDashboardAcl.Interval, run: srv.deleteOrphanedDashboardAcl}, srv.Cfg.CleanupOrphanedDashboardAcl.Enabled},
+		{&cleanupTask{name: taskStaleServerLocks, lockName: "delete stale server locks", interval: srv.Cfg.CleanupStaleServerLocks.Interval, run: srv.deleteStaleServerLocks}, srv.Cfg.CleanupStaleServerLocks.Enabled},
+		{&cleanupTask{name: taskOrphanedPreferences, lockName: "delete orphaned preferences", interval: srv.Cfg.CleanupOrphanedPreferences.Interval, run: srv.deleteOrphanedPreferences}, srv.Cfg.CleanupOrphanedPreferences.Enabled},
+		{&cleanupTask{name: taskOrphanedStars, lockName: "delete orphaned stars", interval: srv.Cfg.CleanupOrphanedStars.Interval, run: srv.deleteOrphanedStars}, srv.Cfg.CleanupOrphanedStars.Enabled},
+		{&cleanupTask{name: taskOrphanedPlaylistItems, lockName: "delete orphaned playlist items", interval: srv.Cfg.CleanupOrphanedPlaylistItems.Interval, run: srv.deleteOrphanedPlaylistItems}, srv.Cfg.CleanupOrphanedPlaylistItems.Enabled},
+		{&cleanupTask{name: taskDuplicateUserInvites, lockName: "delete duplicate user invites", interval: srv.Cfg.CleanupDuplicateUserInvites.Interval, run: srv.deleteDuplicateUserInvites}, srv.Cfg.CleanupDuplicateUserInvites.Enabled},
+		// only the database remote cache leaves expired entries behind, Redis and memcached expire them themselves
+		{&cleanupTask{name: taskExpiredCacheData, lockName: "delete expired cache data", interval: srv.Cfg.CleanupExpiredCacheData.Interval, run: srv.deleteExpiredCacheData}, srv.Cfg.CleanupExpiredCacheData.Enabled && srv.Cfg.RemoteCacheOptions != nil && srv.Cfg.RemoteCacheOptions.Name == "database"},
+		{&cleanupTask{name: taskStaleDashboardProvision, lockName: "delete stale dashboard provisioning", interval: srv.Cfg.CleanupStaleDashboardProvisioning.Interval, run: srv.deleteStaleDashboardProvisioning}, srv.Cfg.CleanupStaleDashboardProvisioning.Enabled},
+		{&cleanupTask{name: taskDeepScrub, lockName: "deep scrub", interval: srv.Cfg.CleanupDeepScrub.Interval, run: srv.deepScrub}, srv.Cfg.CleanupDeepScrub.Enabled},
+	}
+
+	var tasks []CleanupTask
+	var enabled, disabled, unlocked []string
+	for _, b := range builtin {
+		if !b.enabled {
+			disabled = append(disabled, b.task.name)
+			continue
+		}
+		// partial wirings, e.g. in tests, may leave out the server lock service
+		if b.task.lockName != "" && srv.ServerLockService == nil {
+			disabled = append(disabled, b.task.name)
+			unlocked = append(unlocked, b.task.name)
+			continue
+		}
+		tasks = append(tasks, b.task)
+		enabled = append(enabled, b.task.name)
+	}
+	if len(unlocked) > 0 {
+		srv.log.Warn("No server lock service, disabling the cleanup tasks that run behind a server lock", "tasks", strings.Join(unlocked, ","))
+	}
+	srv.log.Info("Cleanup tasks", "enabled", strings.Join(enabled, ","), "disabled", strings.Join(disabled, ","))
+
+	return tasks
+}
+
+// RegisterTask adds a task that is run by every following cleanup cycle.
+// Task names have to be unique.
+func (srv *CleanUpService) RegisterTask(task CleanupTask) error {
+	srv.tasksMtx.Lock()
+	defer srv.tasksMtx.Unlock()
+
+	for _, t := range srv.tasks {
+		if t.Name() == task.Name() {
+			return fmt.Errorf("cleanup task %q is already registered", task.Name())
+		}
+	}
+
+	srv.tasks = append(srv.tasks, task)
+	initTaskMetrics(task.Name())
+
 	return nil
 }
 
+func (srv *CleanUpService) registeredTasks() []CleanupTask {
+	srv.tasksMtx.RLock()
+	defer srv.tasksMtx.RUnlock()
+
+	return append([]CleanupTask(nil), srv.tasks...)
+}
+
+// Run schedules every registered task on its own interval until ctx is done.
+// Node local tasks also run right away on startup, the other tasks after a
+// short random delay unless run_on_startup is turned off.
 func (srv *CleanUpService) Run(ctx context.Context) error {
-	srv.cleanUpTmpFiles()
+	atomic.StoreInt32(&srv.scheduling, 1)
+	defer atomic.StoreInt32(&srv.scheduling, 0)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// seeded per process so a restart also reshuffles the schedule
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	firstCycle := srv.firstCycleDelay(rnd)
+	if firstCycle > 0 {
+		srv.log.Info("Delaying the first cleanup cycle", "delay", firstCycle)
+	}
+	firstRun := time.Now().Add(firstCycle + startupDelay())
+	starting := true
+	nextRun := map[string]time.Time{}
+	timer := time.NewTimer(firstCycle)
+	defer timer.Stop()
+	// the tmp_files task runs more often while Cfg.ImagesDir is short of space
+	var diskPressure bool
+	var diskPressureRuns int
+	// logged when it changes, see checkMaintenance
+	var maintenance bool
 
-	ticker := time.NewTicker(time.Minute * 10)
 	for {
 		select {
-		case <-ticker.C:
-			ctxWithTimeout, cancelFn := context.WithTimeout(ctx, time.Minute*9)
-			defer cancelFn()
-
-			srv.cleanUpTmpFiles()
-			srv.deleteExpiredSnapshots()
-			srv.deleteExpiredDashboardVersions()
-			srv.cleanUpOldAnnotations(ctxWithTimeout)
-
-			err := srv.ServerLockService.LockAndExecute(ctx, "delete old login attempts",
-				time.Minute*10, func() {
-					srv.deleteOldLoginAttempts()
-				})
-			if err != nil {
-				srv.log.Error("failed to lock and execute cleanup of old login attempts", "error", err)
+		case <-timer.C:
+			now := time.Now()
+			// tasks registered later are picked up on the next wake up at the latest
+			wakeUp := now.Add(srv.Cfg.CleanupInterval)
+
+			if srv.Cfg.TempDataMinFreeSpace > 0 && diskSpaceSupported {
+				pressure := srv.checkDiskPressure(diskPressure)
+				if pressure && !diskPressure {
+					nextRun[taskTmpFiles] = now
+				}
+				if !pressure {
+					diskPressureRuns = 0
+				}
+				diskPressure = pressure
+
+				if check := now.Add(diskPressureCheckInterval); check.Before(wakeUp) {
+					wakeUp = check
+				}
+			}
+
+			var due []CleanupTask
+			for _, task := range srv.registeredTasks() {
+				interval := task.Interval()
+				if diskPressure && task.Name() == taskTmpFiles {
+					interval = diskPressureInterval(interval, diskPressureRuns)
+				}
+
+				at, ok := nextRun[task.Name()]
+				if !ok {
+					switch {
+					case taskLockName(task) == "":
+						at = now
+					case srv.Cfg.CleanupRunOnStartup && starting:
+						at = firstRun
+					default:
+						at = now.Add(jitter(rnd, task.Interval()))
+					}
+				}
+
+				if !at.After(now) {
+					due = append(due, task)
+					at = now.Add(jitter(rnd, interval))
+					if diskPressure && task.Name() == taskTmpFiles {
+						diskPressureRuns++
+					}
+				}
+
+				nextRun[task.Name()] = at
+				if at.Before(wakeUp) {
+					wakeUp = at
+				}
+			}
+			starting = false
+
+			if len(due) > 0 && srv.Paused() {
+				srv.log.Debug("Cleanup paused, skipping due tasks", "tasks", len(due))
+				due = nil
+			}
+			if len(due) > 0 {
+				maintenance = srv.checkMaintenance(maintenance)
+				if maintenance {
+					srv.log.Debug("Maintenance mode is on, skipping due tasks", "tasks", len(due))
+					due = nil
+				}
 			}
+			if len(due) > 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					srv.runScheduled(ctx, due)
+				}()
+			}
+
+			timer.Reset(time.Until(wakeUp))
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
 
-func (srv *CleanUpService) cleanUpOldAnnotations(ctx context.Context) {
+// runScheduled runs the tasks that became due at the same time, each with a
+// timeout of its interval, and logs the summary of the cycle.
+func (srv *CleanUpService) runScheduled(ctx context.Context, tasks []CleanupTask) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "cleanup cycle")
+	defer span.Finish()
+	ctx, cancelFn := srv.withCycleTimeout(ctx)
+	defer cancelFn()
+
+	summary := srv.runTasks(ctx, tasks, func(ctx context.Context, task CleanupTask) TaskSummary {
+		ctxWithTimeout, cancelFn := context.WithTimeout(ctx, task.Interval()*9/10)
+		defer cancelFn()
+
+		return srv.lockAndExecute(ctxWithTimeout, task, scheduledLockInterval(task.Interval()))
+	})
+
+	srv.logCycleSummary(summary)
+	srv.logCycleTimeout(ctx, summary)
+	srv.vacuumIfNeeded(ctx, summary)
+}
+
+// withCycleTimeout bounds a cleanup cycle to Cfg.CleanupCycleTimeout. Batched
+// tasks stop at the deadline and continue where they left off next cycle.
+func (srv *CleanUpService) withCycleTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if srv.Cfg.CleanupCycleTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, srv.Cfg.CleanupCycleTimeout)
+}
+
+// logCycleTimeout logs the tasks that were stopped or not started because the
+// cycle ran into Cfg.CleanupCycleTimeout.
+func (srv *CleanUpService) logCycleTimeout(ctx context.Context, summary map[string]TaskSummary) {
+	if ctx.Err() != context.DeadlineExceeded || srv.Cfg.CleanupCycleTimeout <= 0 {
+		return
+	}
+
+	var unfinished []string
+	for name, task := range summary {
+		if task.Error == context.DeadlineExceeded.Error() {
+			unfinished = append(unfinished, name)
+		}
+	}
+	sort.Strings(unfinished)
+
+	srv.log.Warn("Cleanup cycle cut short by its timeout, unfinished tasks continue next cycle", "timeout", srv.Cfg.CleanupCycleTimeout, "tasks", strings.Join(unfinished, ","))
+}
+
+// runTasks runs the tasks on the worker pool shared by all cycles, so at most
+// Cfg.CleanupConcurrency tasks run at once on this instance. Server locks keep
+// other instances from running the same task at the same time.
+func (srv *CleanUpService) runTasks(ctx context.Context, tasks []CleanupTask, run func(context.Context, CleanupTask) TaskSummary) map[string]TaskSummary {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	summary := make(map[string]TaskSummary, len(tasks))
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task CleanupTask) {
+			defer wg.Done()
+
+			var taskSummary TaskSummary
+			select {
+			case srv.workers <- struct{}{}:
+				taskSummary = run(ctx, task)
+				<-srv.workers
+			case <-ctx.Done():
+				taskSummary = newTaskSummary(0, ctx.Err())
+			}
+
+			mtx.Lock()
+			summary[task.Name()] = taskSummary
+			mtx.Unlock()
+		}(task)
+	}
+	wg.Wait()
+
+	return summary
+}
+
+// RunOnce executes all cleanup tasks a single time and reports the outcome of each task.
+// It is safe to call while Run is active, DB tasks are guarded by the same server locks.
+func (srv *CleanUpService) RunOnce(ctx context.Context) CleanupReport {
+	summary := srv.runCycle(ctx, srv.registeredTasks(), func(CleanupTask) time.Duration {
+		return runOnceLockInterval
+	})
+	return newCleanupReport(summary)
+}
+
+// RunTask executes the registered task with the given name a single time, like RunOnce.
+func (srv *CleanUpService) RunTask(ctx context.Context, name string) (TaskResult, error) {
+	for _, task := range srv.registeredTasks() {
+		if task.Name() != name {
+			continue
+		}
+
+		summary := srv.runCycle(ctx, []CleanupTask{task}, func(CleanupTask) time.Duration {
+			return runOnceLockInterval
+		})
+		return newTaskResult(name, summary[name]), nil
+	}
+
+	return TaskResult{}, fmt.Errorf("unknown or disabled cleanup task %q", name)
+}
+
+// ForceRunTask executes the registered task with the given name right away,
+// regardless of when any instance ran it last. It still acquires the server
+// lock of the task, so forced runs racing on several instances don't overlap,
+// the scheduled runs of other instances skip the task for their interval and
+// a run in progress elsewhere stops once it fails to renew the lock.
+func (srv *CleanUpService) ForceRunTask(ctx context.Context, name string) (TaskResult, error) {
+	tasks := srv.registeredTasks()
+	names := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		if task.Name() == name {
+			summary := srv.runCycle(ctx, []CleanupTask{task}, func(CleanupTask) time.Duration {
+				return 0
+			})
+			return newTaskResult(name, summary[name]), nil
+		}
+		names = append(names, task.Name())
+	}
+	sort.Strings(names)
+
+	return TaskResult{}, fmt.Errorf("unknown or disabled cleanup task %q, valid tasks are: %s", name, strings.Join(names, ", "))
+}
+
+// runCycle runs the given cleanup tasks once. Tasks guarded by a server lock
+// are skipped if any instance already ran them within lockInterval.
+func (srv *CleanUpService) runCycle(ctx context.Context, tasks []CleanupTask, lockInterval func(CleanupTask) time.Duration) map[string]TaskSummary {
+	srv.runMtx.Lock()
+	defer srv.runMtx.Unlock()
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "cleanup cycle")
+	defer span.Finish()
+	ctx, cancelFn := srv.withCycleTimeout(ctx)
+	defer cancelFn()
+
+	summary := srv.runTasks(ctx, tasks, func(ctx context.Context, task CleanupTask) TaskSummary {
+		return srv.lockAndExecute(ctx, task, lockInterval(task))
+	})
+	srv.logCycleSummary(summary)
+	srv.logCycleTimeout(ctx, summary)
+	srv.vacuumIfNeeded(ctx, summary)
+
+	return summary
+}
+
+// logCycleSummary logs a single structured entry with the outcome of every
+// task of a cleanup cycle.
+func (srv *CleanUpService) logCycleSummary(summary map[string]TaskSummary) {
+	names := make([]string, 0, len(summary))
+	for name := range summary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := []interface{}{"cycle", util.GenerateShortUID()}
+	for _, name := range names {
+		task := summary[name]
+		if task.Skipped {
+			fields = append(fields, name+".skipped", true)
+			continue
+		}
+		fields = append(fields, name+".deleted", task.Deleted, name+".duration", task.Duration)
+		if task.Error != "" {
+			fields = append(fields, name+".error", task.Error)
+		}
+	}
+
+	srv.log.Info("Cleanup cycle finished", fields...)
+}
+
+// logResult logs the outcome of a task run at the [cleanup] log_level, so it
+// can be seen without debug logging.
+func (srv *CleanUpService) logResult(msg string, ctx ...interface{}) {
+	if srv.resultLogLevel == log.LvlInfo {
+		srv.log.Info(msg, ctx...)
+		return
+	}
+	srv.log.Debug(msg, ctx...)
+}
+
+// lockAndExecute runs a cleanup task behind its server lock, so that only one
+// Grafana instance in a HA setup runs the task per lock interval.
+func (srv *CleanUpService) lockAndExecute(ctx context.Context, task CleanupTask, lockInterval time.Duration) TaskSummary {
+	// the remaining tasks are not started once the cycle got cancelled
+	if err := ctx.Err(); err != nil {
+		return newTaskSummary(0, err)
+	}
+
+	// overlapping schedules and RunOnce must not run a task twice at the same time
+	if !srv.markRunning(task.Name()) {
+		srv.log.Debug("Cleanup task is still running, skipping", "task", task.Name())
+		return TaskSummary{Skipped: true}
+	}
+	defer srv.markDone(task.Name())
+
+	lockName := taskLockName(task)
+	if lockName == "" {
+		return srv.executeTask(ctx, task)
+	}
+	if srv.ServerLockService == nil {
+		return newTaskSummary(0, fmt.Errorf("cleanup task %q runs behind a server lock, but there is no server lock service", task.Name()))
+	}
+
+	// batched deletes can outlast the lock interval, the lock is renewed while
+	// the task runs so no other instance starts the same work
+	summary := TaskSummary{Skipped: true}
+	err := srv.ServerLockService.LockExecuteAndRenew(ctx, lockName, lockInterval, func(ctx context.Context) {
+		summary = srv.executeTask(ctx, task)
+	})
+	if err != nil {
+		srv.log.Error("Failed to acquire server lock for cleanup task", "task", task.Name(), "action", lockName, "error", err)
+		metrics.MCleanupErrorsTotal.WithLabelValues(task.Name()).Inc()
+		return newTaskSummary(0, err)
+	}
+	if summary.Skipped {
+		// another instance holding or having just run the task is expected in HA setups
+		srv.log.Debug("Cleanup task skipped, the server lock is held or was released within the lock interval", "task", task.Name(), "action", lockName)
+		metrics.MCleanupLockSkippedTotal.WithLabelValues(task.Name()).Inc()
+	}
+
+	return summary
+}
+
+// markRunning flags the task as running and reports whether it wasn't already.
+func (srv *CleanUpService) markRunning(task string) bool {
+	srv.runningMtx.Lock()
+	defer srv.runningMtx.Unlock()
+
+	if srv.running[task] {
+		return false
+	}
+	if srv.running == nil {
+		srv.running = map[string]bool{}
+	}
+	srv.running[task] = true
+
+	return true
+}
+
+func (srv *CleanUpService) markDone(task string) {
+	srv.runningMtx.Lock()
+	defer srv.runningMtx.Unlock()
+
+	delete(srv.running, task)
+}
+
+// executeTask runs the task and records its outcome in the cleanup metrics.
+// A panicking task is reported as failed instead of stopping all cleanup.
+func (srv *CleanUpService) executeTask(ctx context.Context, task CleanupTask) (summary TaskSummary) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "cleanup task")
+	span.SetTag("task", task.Name())
+	defer span.Finish()
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			srv.log.Error("Cleanup task panic", "task", task.Name(), "error", r, "stack", log.Stack(1))
+			err := fmt.Errorf("cleanup task %q panicked: %v", task.Name(), r)
+			observeTask(task.Name(), start, 0, err)
+			summary = newTaskSummary(0, err)
+			summary.Duration = time.Since(start)
+		}
+		srv.statuses.update(task.Name(), start, summary)
+		srv.notifySubscribers(CleanupEvent{Task: task.Name(), Deleted: summary.Deleted, StartedAt: start, Err: summary.err})
+
+		span.SetTag("deleted", summary.Deleted)
+		if summary.Error != "" {
+			ext.Error.Set(span, true)
+			span.LogFields(tlog.String("error", summary.Error))
+		}
+	}()
+
+	// the batched deletes of the task stop at the cap, the rest is left to the next run
+	ctx, budget := sqlstore.WithDeleteBudget(ctx, srv.Cfg.CleanupMaxRowsPerCycle)
+	now := srv.clock()
+	before, verify := srv.countTaskRows(ctx, task.Name())
+	deleted, err := task.Run(ctx)
+	observeTask(task.Name(), start, deleted, err)
+	if verify && err == nil {
+		srv.verifyDeletedRows(ctx, task.Name(), before, deleted)
+	}
+	srv.checkDeletionThreshold(task.Name(), deleted)
+	srv.auditDeletion(task, now, deleted)
+	if budget.Exhausted() {
+		srv.log.Info("Cleanup task hit the max rows per cycle, deleting the remaining rows next cycle", "task", task.Name(), "max", srv.Cfg.CleanupMaxRowsPerCycle)
+	}
+
+	summary = newTaskSummary(deleted, err)
+	summary.Duration = time.Since(start)
+	return summary
+}
+
+// checkDeletionThreshold warns about and publishes an event for a task run
+// that deleted more than Cfg.CleanupDeletionThreshold rows or files, which
+// usually means a retention setting is off.
+func (srv *CleanUpService) checkDeletionThreshold(task string, deleted int64) {
+	threshold := srv.Cfg.CleanupDeletionThreshold
+	if threshold <= 0 || deleted <= threshold {
+		return
+	}
+
+	srv.log.Warn("Cleanup task deleted more than the deletion threshold", "task", task, "deleted", deleted, "threshold", threshold)
+	if err := bus.Publish(&events.CleanupDeletionThresholdExceeded{
+		Timestamp: srv.clock(),
+		Task:      task,
+		Deleted:   deleted,
+		Threshold: threshold,
+	}); err != nil {
+		srv.log.Error("Failed to publish cleanup deletion threshold event", "task", task, "error", err)
+	}
+}
+
+// dispatchWithRetries dispatches msg up to Cfg.CleanupRetryAttempts times,
+// doubling the wait between attempts starting at Cfg.CleanupRetryBackoff, so
+// a transient database error doesn't postpone a task by a whole interval.
+func (srv *CleanUpService) dispatchWithRetries(ctx context.Context, msg bus.Msg) error {
+	var err error
+	backoff := srv.Cfg.CleanupRetryBackoff
+	for attempt := 1; ; attempt++ {
+		if err = bus.DispatchCtx(ctx, msg); err == nil || attempt >= srv.Cfg.CleanupRetryAttempts {
+			return err
+		}
+
+		srv.log.Debug("Cleanup dispatch failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func newTaskSummary(deleted int64, err error) TaskSummary {
+	summary := TaskSummary{Deleted: deleted, err: err}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	return summary
+}
+
+func initTaskMetrics(task string) {
+	metrics.MCleanupDeletedTotal.WithLabelValues(task).Add(0)
+	metrics.MCleanupErrorsTotal.WithLabelValues(task).Add(0)
+	metrics.MCleanupLockSkippedTotal.WithLabelValues(task).Add(0)
+}
+
+// observeTask records the duration and outcome of a single cleanup task run.
+func observeTask(task string, start time.Time, deleted int64, err error) {
+	metrics.MCleanupDuration.WithLabelValues(task).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.MCleanupErrorsTotal.WithLabelValues(task).Inc()
+		return
+	}
+	metrics.MCleanupDeletedTotal.WithLabelValues(task).Add(float64(deleted))
+}
+
+func (srv *CleanUpService) cleanUpOldAnnotations(ctx context.Context) (int64, error) {
 	cleaner := annotations.GetAnnotationCleaner()
 	err := cleaner.CleanAnnotations(ctx, srv.Cfg)
 	if err != nil {
 		srv.log.Error("failed to clean up old annotations", "error", err)
+		return 0, err
 	}
+
+	// runs behind the same lock, right after the annotations got deleted
+	return srv.deleteOrphanedAnnotationTags(ctx)
 }
 
-func (srv *CleanUpService) cleanUpTmpFiles() {
-	if _, err := os.Stat(srv.Cfg.ImagesDir); os.IsNotExist(err) {
-		return
+// deleteOrphanedAnnotationTags deletes the tags of the annotations removed by
+// this or an earlier run, as well as the unused tags with Cfg.CleanupUnusedTags.
+func (srv *CleanUpService) deleteOrphanedAnnotationTags(ctx context.Context) (int64, error) {
+	cmd := models.DeleteOrphanedAnnotationTagsCommand{
+		BatchSize:       srv.Cfg.AnnotationCleanupJobBatchSize,
+		PruneUnusedTags: srv.Cfg.CleanupUnusedTags,
+		DryRun:          srv.Cfg.CleanupDryRun,
+	}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Failed to delete orphaned annotation tags", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete orphaned annotation tags", "annotation tags", cmd.DeletedAnnotationTags, "tags", cmd.DeletedTags)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted orphaned annotation tags", "annotation tags", cmd.DeletedAnnotationTags, "tags", cmd.DeletedTags)
+
+	return cmd.DeletedAnnotationTags + cmd.DeletedTags, nil
+}
+
+func (srv *CleanUpService) deleteOrphanedAnnotations(ctx context.Context) (int64, error) {
+	cmd := models.CleanupAnnotationsCommand{
+		BatchSize: srv.Cfg.AnnotationCleanupJobBatchSize,
+		DryRun:    srv.Cfg.CleanupDryRun,
+	}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Failed to delete orphaned annotations", "error", err.Error())
+		return 0, err
 	}
 
-	files, err := ioutil.ReadDir(srv.Cfg.ImagesDir)
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete orphaned annotations", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted orphaned annotations", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) cleanUpTmpFiles(ctx context.Context) (int64, error) {
+	var now = srv.clock()
+
+	// on an almost full volume the trash is emptied right away and moving
+	// files into it, which frees nothing, is skipped
+	freeBytes := srv.spaceToFree()
+	if local, ok := srv.tempStorage.(*localTempStorage); ok && freeBytes > 0 {
+		local.trash = false
+		defer func() { local.trash = true }()
+	}
+
+	// emptied first, files trashed by this run must not count as old already
+	trashDeleted, err := srv.emptyTrash(ctx, now, freeBytes > 0)
 	if err != nil {
-		srv.log.Error("Problem reading image dir", "error", err)
-		return
+		return trashDeleted, err
+	}
+
+	deleted, err := srv.pruneFiles(ctx, filePruning{
+		task:      taskTmpFiles,
+		storage:   srv.tempStorage,
+		expired:   srv.shouldCleanupTempFile,
+		retention: srv.retentionPolicy(taskTmpFiles),
+		excluded:  srv.isExcludedTempFile,
+		foreign:   srv.foreignTempFileCheck(),
+		maxSize:   srv.Cfg.TempDataMaxSize,
+		maxFiles:  srv.Cfg.TempDataMaxFiles,
+		freeBytes: freeBytes,
+
+		observeAges: srv.Cfg.TempDataAgeHistogram,
+	}, now)
+	if err != nil {
+		return deleted + trashDeleted, err
+	}
+
+	if local, ok := srv.tempStorage.(*localTempStorage); ok && srv.Cfg.TempDataRemoveEmptyDirs {
+		srv.removeEmptyDirs(local.dirs, local.emptiedDirs)
 	}
 
-	var toDelete []os.FileInfo
-	var now = time.Now()
+	return deleted + trashDeleted, nil
+}
 
-	for _, file := range files {
-		if srv.shouldCleanupTempFile(file.ModTime(), now) {
-			toDelete = append(toDelete, file)
+// removeEmptyDirs removes the directories that no longer contain any files
+// because the cleanup deleted them. Directories that were empty already, e.g.
+// ones the renderer just created, are left alone. dirs is expected in the
+// lexical order filepath.Walk visits them in, so iterating backwards handles
+// nested directories before their parents.
+func (srv *CleanUpService) removeEmptyDirs(dirs []string, emptied map[string]bool) {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if !emptied[dirs[i]] {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dirs[i])
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+		if err := os.Remove(dirs[i]); err != nil && !os.IsNotExist(err) {
+			srv.log.Error("Failed to delete empty temp directory", "dir", dirs[i], "error", err)
+			continue
 		}
+		// the parent may have been left empty by the cleanup as well
+		emptied[filepath.Dir(dirs[i])] = true
 	}
+}
 
-	for _, file := range toDelete {
-		fullPath := path.Join(srv.Cfg.ImagesDir, file.Name())
-		err := os.Remove(fullPath)
-		if err != nil {
-			srv.log.Error("Failed to delete temp file", "file", file.Name(), "error", err)
+func (srv *CleanUpService) isExcludedTempFile(name string) bool {
+	for _, pattern := range srv.tempDataExcludePatterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
 		}
 	}
 
-	srv.log.Debug("Found old rendered image to delete", "deleted", len(toDelete), "kept", len(files))
+	return false
 }
 
-func (srv *CleanUpService) shouldCleanupTempFile(filemtime time.Time, now time.Time) bool {
-	if srv.Cfg.TempDataLifetime == 0 {
+// tempFileTime returns the time of a temp file selected by
+// Cfg.TempDataTimeSource, its modification time by default. With
+// Cfg.TempDataUseChangeTime the inode change time is used when it's later, so
+// files restored from a backup with their old modification times count as new.
+// Times the platform doesn't provide fall back to the modification time.
+func (srv *CleanUpService) tempFileTime(info os.FileInfo) time.Time {
+	modTime := info.ModTime()
+	switch srv.Cfg.TempDataTimeSource {
+	case "ctime":
+		if ctime, ok := changeTime(info); ok {
+			return ctime
+		}
+		return modTime
+	case "atime":
+		if atime, ok := accessTime(info); ok {
+			return atime
+		}
+		return modTime
+	}
+
+	if !srv.Cfg.TempDataUseChangeTime {
+		return modTime
+	}
+
+	if ctime, ok := changeTime(info); ok && ctime.After(modTime) {
+		return ctime
+	}
+	return modTime
+}
+
+func (srv *CleanUpService) shouldCleanupTempFile(file TempFile, filemtime time.Time, now time.Time) bool {
+	lifetime := srv.Cfg.TempDataLifetime
+	if extLifetime, ok := srv.tempDataExtensionLifetimes[strings.ToLower(filepath.Ext(file.Name()))]; ok {
+		lifetime = extLifetime
+	}
+	if file.AlertImage && srv.Cfg.AlertingImageRetention > 0 {
+		lifetime = srv.Cfg.AlertingImageRetention
+	}
+	if lifetime == 0 || srv.tempFileInUse(file, now) {
+		return false
+	}
+
+	return filemtime.Add(lifetime).Before(now)
+}
+
+// tempFileInUse reports whether a local temp file was modified or read within
+// Cfg.TempDataInUseGrace, e.g. an image a browser is still loading. The
+// access time is only used where the platform reports it, files of other
+// storages are never in use.
+func (srv *CleanUpService) tempFileInUse(file TempFile, now time.Time) bool {
+	if srv.Cfg.TempDataInUseGrace <= 0 || file.info == nil {
 		return false
 	}
 
-	return filemtime.Add(srv.Cfg.TempDataLifetime).Before(now)
+	lastUsed := file.info.ModTime()
+	if atime, ok := accessTime(file.info); ok && atime.After(lastUsed) {
+		lastUsed = atime
+	}
+
+	return lastUsed.Add(srv.Cfg.TempDataInUseGrace).After(now)
+}
+
+// effectiveUID returns the id of the user Grafana runs as, or -1 on Windows.
+var effectiveUID = os.Geteuid
+
+// foreignTempFileCheck returns the check for local temp files owned by
+// another user than the one Grafana runs as, or nil without
+// Cfg.TempDataSkipForeignFiles. Root can delete any file and skips none.
+func (srv *CleanUpService) foreignTempFileCheck() func(file TempFile) bool {
+	if !srv.Cfg.TempDataSkipForeignFiles {
+		return nil
+	}
+
+	uid := effectiveUID()
+	return func(file TempFile) bool {
+		if file.info == nil || uid <= 0 {
+			return false
+		}
+
+		owner, ok := fileOwner(file.info)
+		return ok && owner != uid
+	}
+}
+
+// parseExtensionLifetime parses a temp data lifetime by extension entry like
+// .csv=1h into the lower case extension and the lifetime.
+func parseExtensionLifetime(entry string) (string, time.Duration, error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 {
+		return "", 0, fmt.Errorf("expected <extension>=<duration>")
+	}
+
+	ext := strings.ToLower(strings.TrimSpace(parts[0]))
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if ext == "." || strings.ContainsAny(ext, `/\`) {
+		return "", 0, fmt.Errorf("invalid extension %q", parts[0])
+	}
+
+	lifetime, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return "", 0, err
+	}
+	if lifetime < 0 {
+		return "", 0, fmt.Errorf("negative lifetime %s", lifetime)
+	}
+
+	return ext, lifetime, nil
 }
 
-func (srv *CleanUpService) deleteExpiredSnapshots() {
-	cmd := models.DeleteExpiredSnapshotsCommand{}
-	if err := bus.Dispatch(&cmd); err != nil {
+func (srv *CleanUpService) deleteExpiredSnapshots(ctx context.Context) (int64, error) {
+	cmd := models.DeleteExpiredSnapshotsCommand{
+		Now:       srv.clock(),
+		OrgMaxAge: srv.Cfg.SnapshotMaxAgePerOrg,
+		DryRun:    srv.Cfg.CleanupDryRun,
+	}
+	if !cmd.DryRun && setting.SnapShotRemoveExpired {
+		keepIds, err := srv.deleteExternalSnapshots(ctx, cmd.Now)
+		if err != nil {
+			srv.log.Error("Failed to delete expired external snapshots", "error", err.Error())
+			return 0, err
+		}
+		cmd.KeepIds = keepIds
+	}
+
+	if err := srv.dispatchWithRetries(ctx, &cmd); err != nil {
 		srv.log.Error("Failed to delete expired snapshots", "error", err.Error())
-	} else {
-		srv.log.Debug("Deleted expired snapshots", "rows affected", cmd.DeletedRows)
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete expired snapshots", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted expired snapshots", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+// trimSnapshotsOverLimit deletes the oldest snapshots of the orgs with more
+// than snapshot_max_per_org snapshots.
+func (srv *CleanUpService) trimSnapshotsOverLimit(ctx context.Context) (int64, error) {
+	cmd := models.TrimSnapshotsOverLimitCommand{
+		Limit:  srv.Cfg.SnapshotMaxPerOrg,
+		DryRun: srv.Cfg.CleanupDryRun,
 	}
+	if err := srv.dispatchWithRetries(ctx, &cmd); err != nil {
+		srv.log.Error("Failed to delete snapshots over limit", "error", err.Error())
+		return 0, err
+	}
+
+	for orgID, deleted := range cmd.OrgDeletedRows {
+		if cmd.DryRun {
+			srv.log.Info("[Dry run] Would delete the oldest snapshots of org over the limit", "orgId", orgID, "rows", deleted)
+		} else {
+			srv.log.Info("Deleted the oldest snapshots of org over the limit", "orgId", orgID, "rows affected", deleted)
+		}
+	}
+
+	if cmd.DryRun {
+		return 0, nil
+	}
+
+	return cmd.DeletedRows, nil
 }
 
-func (srv *CleanUpService) deleteExpiredDashboardVersions() {
-	cmd := models.DeleteExpiredVersionsCommand{}
-	if err := bus.Dispatch(&cmd); err != nil {
+func (srv *CleanUpService) deleteExpiredDashboardVersions(ctx context.Context) (int64, error) {
+	cmd := models.DeleteExpiredVersionsCommand{
+		DryRun:            srv.Cfg.CleanupDryRun,
+		MinVersionsToKeep: srv.Cfg.MinDashboardVersionsToKeep,
+		OrgVersionsToKeep: srv.Cfg.DashboardVersionsToKeepPerOrg,
+		BatchSize:         srv.Cfg.DashboardVersionsDeleteBatchSize,
+		BatchDelay:        srv.Cfg.DashboardVersionsDeleteBatchDelay,
+	}
+	if err := srv.dispatchWithRetries(ctx, &cmd); err != nil {
 		srv.log.Error("Failed to delete expired dashboard versions", "error", err.Error())
-	} else {
-		srv.log.Debug("Deleted old/expired dashboard versions", "rows affected", cmd.DeletedRows)
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete old/expired dashboard versions", "rows", cmd.DeletedRows)
+		return 0, nil
 	}
+
+	srv.logResult("Deleted old/expired dashboard versions", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
 }
 
-func (srv *CleanUpService) deleteOldLoginAttempts() {
+func (srv *CleanUpService) deleteOldLoginAttempts(ctx context.Context) (int64, error) {
 	if srv.Cfg.DisableBruteForceLoginProtection {
-		return
+		return 0, nil
 	}
 
 	cmd := models.DeleteOldLoginAttemptsCommand{
-		OlderThan: time.Now().Add(time.Minute * -10),
+		OlderThan: srv.clock().Add(-srv.Cfg.LoginAttemptsRetention),
+		DryRun:    srv.Cfg.CleanupDryRun,
 	}
-	if err := bus.Dispatch(&cmd); err != nil {
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
 		srv.log.Error("Problem deleting expired login attempts", "error", err.Error())
-	} else {
-		srv.log.Debug("Deleted expired login attempts", "rows affected", cmd.DeletedRows)
+		return 0, err
 	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete expired login attempts", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted expired login attempts", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteExpiredAPIKeys(ctx context.Context) (int64, error) {
+	cmd := models.DeleteExpiredAPIKeysCommand{
+		ExpiredBefore: srv.clock().Add(-srv.Cfg.ExpiredTokenRetention),
+		DryRun:        srv.Cfg.CleanupDryRun,
+	}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Failed to delete expired api keys", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete expired api keys", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted expired api keys", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+// inviteStatuses are the temp user statuses of invites, which expire
+// independently of the sign ups waiting for their email to be verified.
+var inviteStatuses = []models.TempUserStatus{models.TmpUserInvitePending, models.TmpUserCompleted, models.TmpUserRevoked}
+
+func (srv *CleanUpService) deleteExpiredUserInvites(ctx context.Context) (int64, error) {
+	// a lifetime of 0 would delete every pending invite, it's rejected on startup
+	// and means that invites never expire
+	maxInviteLifetime := time.Duration(srv.Cfg.UserInviteMaxLifetimeDays) * 24 * time.Hour
+	maxSignUpLifetime := srv.Cfg.VerificationEmailMaxLifetime
+	if maxSignUpLifetime <= 0 {
+		maxSignUpLifetime = maxInviteLifetime
+	}
+
+	var invites, signUps int64
+	if maxInviteLifetime > 0 {
+		deleted, err := srv.deleteExpiredTempUsers(ctx, "user invites", maxInviteLifetime, inviteStatuses)
+		if err != nil {
+			return 0, err
+		}
+		invites = deleted
+	}
+	if maxSignUpLifetime > 0 {
+		deleted, err := srv.deleteExpiredTempUsers(ctx, "sign ups", maxSignUpLifetime, []models.TempUserStatus{models.TmpUserSignUpStarted})
+		if err != nil {
+			return invites, err
+		}
+		signUps = deleted
+	}
+
+	return invites + signUps, nil
+}
+
+// deleteExpiredTempUsers deletes the invites or sign ups with one of the
+// statuses that outlived maxLifetime. With a recovery window the ones that can
+// still be used are marked as expired first, and only deleted once they were
+// marked for longer than the window.
+func (srv *CleanUpService) deleteExpiredTempUsers(ctx context.Context, kind string, maxLifetime time.Duration, statuses []models.TempUserStatus) (int64, error) {
+	now := srv.clock()
+	olderThan := now.Add(-maxLifetime)
+
+	var usable, unusable, expired []models.TempUserStatus
+	for _, status := range statuses {
+		if expiredStatus, ok := models.ExpiredTempUserStatuses[status]; ok {
+			usable = append(usable, status)
+			expired = append(expired, expiredStatus)
+		} else {
+			unusable = append(unusable, status)
+		}
+	}
+
+	window := srv.Cfg.UserInviteRecoveryWindow
+	if window <= 0 {
+		// also deletes the ones marked while the recovery window was on
+		return srv.dispatchDeleteExpiredTempUsers(ctx, kind, models.DeleteExpiredUserInvitesCommand{
+			OlderThan: olderThan,
+			Statuses:  append(append([]models.TempUserStatus{}, statuses...), expired...),
+		})
+	}
+
+	for i, status := range usable {
+		if err := srv.markExpiredTempUsers(ctx, kind, now, olderThan, status, expired[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	var deleted int64
+	if len(unusable) > 0 {
+		var err error
+		deleted, err = srv.dispatchDeleteExpiredTempUsers(ctx, kind, models.DeleteExpiredUserInvitesCommand{
+			OlderThan: olderThan,
+			Statuses:  unusable,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(expired) > 0 {
+		swept, err := srv.dispatchDeleteExpiredTempUsers(ctx, "marked "+kind, models.DeleteExpiredUserInvitesCommand{
+			OlderThan:     olderThan,
+			Statuses:      expired,
+			UpdatedBefore: now.Add(-window),
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += swept
+	}
+
+	return deleted, nil
+}
+
+// markExpiredTempUsers marks the invites or sign ups with status that were
+// created before olderThan as expiredStatus.
+func (srv *CleanUpService) markExpiredTempUsers(ctx context.Context, kind string, now, olderThan time.Time, status, expiredStatus models.TempUserStatus) error {
+	cmd := models.MarkExpiredUserInvitesCommand{
+		OlderThan:     olderThan,
+		Status:        status,
+		ExpiredStatus: expiredStatus,
+		MarkedAt:      now,
+		DryRun:        srv.Cfg.CleanupDryRun,
+	}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem marking expired "+kind, "error", err.Error())
+		return err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would mark expired "+kind, "status", status, "rows", cmd.MarkedRows)
+		return nil
+	}
+	if cmd.MarkedRows > 0 {
+		srv.log.Info("Marked expired "+kind+", they are deleted after the recovery window", "status", expiredStatus,
+			"rows", cmd.MarkedRows, "window", srv.Cfg.UserInviteRecoveryWindow)
+	}
+
+	return nil
+}
+
+func (srv *CleanUpService) dispatchDeleteExpiredTempUsers(ctx context.Context, kind string, cmd models.DeleteExpiredUserInvitesCommand) (int64, error) {
+	cmd.DryRun = srv.Cfg.CleanupDryRun
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting expired "+kind, "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete expired "+kind, "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted expired "+kind, "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteExpiredAuthTokens(ctx context.Context) (int64, error) {
+	maxInactiveLifetime := time.Duration(srv.Cfg.LoginMaxInactiveLifetimeDays) * 24 * time.Hour
+	maxLifetime := time.Duration(srv.Cfg.LoginMaxLifetimeDays) * 24 * time.Hour
+
+	now := srv.clock()
+	cmd := models.DeleteExpiredAuthTokensCommand{
+		CreatedBefore: now.Add(-maxLifetime),
+		RotatedBefore: now.Add(-maxInactiveLifetime),
+		DryRun:        srv.Cfg.CleanupDryRun,
+	}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting expired auth tokens", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete expired auth tokens", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted expired auth tokens", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteOrphanedDashboardAcl(ctx context.Context) (int64, error) {
+	cmd := models.DeleteOrphanedDashboardAclCommand{DryRun: srv.Cfg.CleanupDryRun}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting orphaned dashboard permissions", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete orphaned dashboard permissions", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted orphaned dashboard permissions", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteStaleServerLocks(ctx context.Context) (int64, error) {
+	olderThan := srv.clock().Add(-srv.Cfg.ServerLockRetention)
+	deleted, err := srv.ServerLockService.DeleteStaleLocks(ctx, olderThan, srv.Cfg.CleanupDryRun)
+	if err != nil {
+		srv.log.Error("Problem deleting stale server locks", "error", err.Error())
+		return 0, err
+	}
+
+	if srv.Cfg.CleanupDryRun {
+		srv.log.Info("[Dry run] Would delete stale server locks", "rows", deleted)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted stale server locks", "rows affected", deleted)
+
+	return deleted, nil
+}
+
+func (srv *CleanUpService) deleteOrphanedPreferences(ctx context.Context) (int64, error) {
+	cmd := models.DeleteOrphanedPreferencesCommand{DryRun: srv.Cfg.CleanupDryRun}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting orphaned preferences", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete orphaned preferences", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted orphaned preferences", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteOrphanedStars(ctx context.Context) (int64, error) {
+	cmd := models.DeleteOrphanedStarsCommand{DryRun: srv.Cfg.CleanupDryRun}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting orphaned stars", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete orphaned stars", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted orphaned stars", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteOrphanedPlaylistItems(ctx context.Context) (int64, error) {
+	cmd := models.DeleteOrphanedPlaylistItemsCommand{UnusedTags: srv.Cfg.CleanupUnusedPlaylistTags, DryRun: srv.Cfg.CleanupDryRun}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting orphaned playlist items", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete orphaned playlist items", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted orphaned playlist items", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteExpiredCacheData(ctx context.Context) (int64, error) {
+	cmd := models.DeleteExpiredCacheDataCommand{Now: srv.clock(), DryRun: srv.Cfg.CleanupDryRun}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting expired cache data", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete expired cache data", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted expired cache data", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteDuplicateUserInvites(ctx context.Context) (int64, error) {
+	cmd := models.DeleteDuplicateUserInvitesCommand{DryRun: srv.Cfg.CleanupDryRun}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting duplicate user invites", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete duplicate user invites", "rows", cmd.DeletedRows)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted duplicate user invites", "rows affected", cmd.DeletedRows)
+
+	return cmd.DeletedRows, nil
+}
+
+func (srv *CleanUpService) deleteStaleDashboardProvisioning(ctx context.Context) (int64, error) {
+	cmd := models.DeleteStaleDashboardProvisioningCommand{DryRun: srv.Cfg.CleanupDryRun}
+	if srv.Cfg.CleanupUnknownProvisioners {
+		unknown, err := srv.unknownDashboardProvisioners(ctx)
+		if err != nil {
+			srv.log.Error("Problem finding unknown dashboard provisioners", "error", err.Error())
+			return 0, err
+		}
+		cmd.UnknownProvisioners = unknown
+	}
+
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Problem deleting stale dashboard provisioning", "error", err.Error())
+		return 0, err
+	}
+
+	if cmd.DryRun {
+		srv.log.Info("[Dry run] Would delete stale dashboard provisioning", "rows", cmd.DeletedRows, "unknown provisioners", cmd.UnknownProvisioners)
+		return 0, nil
+	}
+
+	srv.logResult("Deleted stale dashboard provisioning", "rows affected", cmd.DeletedRows, "unknown provisioners", cmd.UnknownProvisioners)
+
+	return cmd.DeletedRows, nil
+}
+
+// unknownDashboardProvisioners returns the names of the provisioners that
+// have provisioned dashboards but aren't configured on this instance.
+func (srv *CleanUpService) unknownDashboardProvisioners(ctx context.Context) ([]string, error) {
+	if srv.ProvisioningService == nil {
+		return nil, nil
+	}
+
+	query := models.GetDashboardProvisionerNamesQuery{}
+	if err := bus.DispatchCtx(ctx, &query); err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for _, name := range query.Result {
+		if srv.ProvisioningService.GetDashboardProvisionerResolvedPath(name) == "" {
+			unknown = append(unknown, name)
+		}
+	}
+
+	return unknown, nil
 }
@@ -1,11 +1,41 @@
 package cleanup
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/infra/serverlock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/provisioning"
+	"github.com/grafana/grafana/pkg/services/rendering"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
 	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/require"
+	ini "gopkg.in/ini.v1"
 )
 
 func TestCleanUpTmpFiles(t *testing.T) {
@@ -21,21 +51,2691 @@ func TestCleanUpTmpFiles(t *testing.T) {
 		weekAgo := now.Add(-time.Second * 3600 * 24 * 7)
 
 		Convey("Should not cleanup recent files", func() {
-			So(service.shouldCleanupTempFile(secondAgo, now), ShouldBeFalse)
+			So(service.shouldCleanupTempFile(TempFile{Path: "image.png"}, secondAgo, now), ShouldBeFalse)
 		})
 
 		Convey("Should cleanup older files", func() {
-			So(service.shouldCleanupTempFile(twoDaysAgo, now), ShouldBeTrue)
+			So(service.shouldCleanupTempFile(TempFile{Path: "image.png"}, twoDaysAgo, now), ShouldBeTrue)
 		})
 
 		Convey("After increasing temporary files lifetime, older files should be kept", func() {
 			cfg.TempDataLifetime, _ = time.ParseDuration("1000h")
-			So(service.shouldCleanupTempFile(weekAgo, now), ShouldBeFalse)
+			So(service.shouldCleanupTempFile(TempFile{Path: "image.png"}, weekAgo, now), ShouldBeFalse)
 		})
 
 		Convey("If lifetime is 0, files should never be cleaned up", func() {
 			cfg.TempDataLifetime = 0
-			So(service.shouldCleanupTempFile(weekAgo, now), ShouldBeFalse)
+			So(service.shouldCleanupTempFile(TempFile{Path: "image.png"}, weekAgo, now), ShouldBeFalse)
 		})
+
+		Convey("Alert images should be kept for the alerting image retention", func() {
+			cfg.AlertingImageRetention, _ = time.ParseDuration("168h")
+			So(service.shouldCleanupTempFile(TempFile{Path: rendering.AlertImagePrefix + "image.png", AlertImage: true}, twoDaysAgo, now), ShouldBeFalse)
+			So(service.shouldCleanupTempFile(TempFile{Path: rendering.AlertImagePrefix + "image.png", AlertImage: true}, weekAgo.Add(-time.Second), now), ShouldBeTrue)
+			So(service.shouldCleanupTempFile(TempFile{Path: "image.png"}, twoDaysAgo, now), ShouldBeTrue)
+		})
+
+		Convey("If alerting image retention is 0, alert images should use the temp data lifetime", func() {
+			cfg.AlertingImageRetention = 0
+			So(service.shouldCleanupTempFile(TempFile{Path: rendering.AlertImagePrefix + "image.png", AlertImage: true}, twoDaysAgo, now), ShouldBeTrue)
+		})
+
+		Convey("Files with a lifetime for their extension should use it", func() {
+			service.tempDataExtensionLifetimes = map[string]time.Duration{".csv": time.Hour, ".png": time.Hour * 24 * 30}
+			hourAgo := now.Add(-time.Hour - time.Second)
+			So(service.shouldCleanupTempFile(TempFile{Path: "export.csv"}, hourAgo, now), ShouldBeTrue)
+			So(service.shouldCleanupTempFile(TempFile{Path: "EXPORT.CSV"}, hourAgo, now), ShouldBeTrue)
+			So(service.shouldCleanupTempFile(TempFile{Path: "image.png"}, weekAgo, now), ShouldBeFalse)
+		})
+
+		Convey("Files without a lifetime for their extension should use the temp data lifetime", func() {
+			service.tempDataExtensionLifetimes = map[string]time.Duration{".csv": time.Hour}
+			So(service.shouldCleanupTempFile(TempFile{Path: "image.png"}, secondAgo, now), ShouldBeFalse)
+			So(service.shouldCleanupTempFile(TempFile{Path: "image.png"}, twoDaysAgo, now), ShouldBeTrue)
+			So(service.shouldCleanupTempFile(TempFile{Path: "export"}, twoDaysAgo, now), ShouldBeTrue)
+		})
+	})
+}
+
+func TestRunOnce(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	oldFile := filepath.Join(imagesDir, "old.png")
+	newFile := filepath.Join(imagesDir, "new.png")
+	for _, file := range []string{oldFile, newFile} {
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+	}
+	twoDaysAgo := time.Now().Add(-time.Hour * 48)
+	require.NoError(t, os.Chtimes(oldFile, twoDaysAgo, twoDaysAgo))
+
+	lockService := &serverlock.ServerLockService{SQLStore: sqlstore.InitTestDB(t)}
+	require.NoError(t, lockService.Init())
+
+	service := &CleanUpService{
+		Cfg: enableAllTasks(&setting.Cfg{
+			ImagesDir:        imagesDir,
+			TempDataLifetime: time.Hour * 24,
+			CleanupInterval:  time.Minute * 10,
+		}),
+		ServerLockService: lockService,
+	}
+	require.NoError(t, service.Init())
+
+	report := service.RunOnce(context.Background())
+	require.Len(t, report.Tasks, len(service.registeredTasks()))
+	require.Equal(t, TaskResult{Name: taskTmpFiles, Deleted: 1}, taskResult(t, report, taskTmpFiles))
+	for _, task := range report.Tasks {
+		require.False(t, task.Skipped, task.Name)
+		require.NoError(t, task.Err, task.Name)
+	}
+	require.Empty(t, report.Failed())
+
+	_, err = os.Stat(oldFile)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(newFile)
+	require.NoError(t, err)
+
+	report = service.RunOnce(context.Background())
+	require.False(t, taskResult(t, report, taskTmpFiles).Skipped)
+	require.True(t, taskResult(t, report, taskExpiredSnapshots).Skipped, "DB tasks should only run once per lock interval")
+}
+
+func TestCleanUpTmpFilesInSubdirectories(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	orgDir := filepath.Join(imagesDir, "1")
+	nestedDir := filepath.Join(orgDir, "nested")
+	keptDir := filepath.Join(imagesDir, "2")
+	// e.g. created by the renderer, which hasn't written the image yet
+	newDir := filepath.Join(imagesDir, "3")
+	for _, dir := range []string{nestedDir, keptDir, newDir} {
+		require.NoError(t, os.MkdirAll(dir, 0700))
+	}
+
+	oldFiles := []string{
+		filepath.Join(imagesDir, "old.png"),
+		filepath.Join(orgDir, "old.png"),
+		filepath.Join(nestedDir, "old.png"),
+	}
+	newFile := filepath.Join(keptDir, "new.png")
+	twoDaysAgo := time.Now().Add(-time.Hour * 48)
+	for _, file := range append(oldFiles, newFile) {
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+	}
+	for _, file := range oldFiles {
+		require.NoError(t, os.Chtimes(file, twoDaysAgo, twoDaysAgo))
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:               imagesDir,
+			TempDataLifetime:        time.Hour * 24,
+			TempDataRemoveEmptyDirs: true,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(len(oldFiles)), deleted)
+
+	for _, path := range append(oldFiles, orgDir) {
+		_, err = os.Stat(path)
+		require.True(t, os.IsNotExist(err), path)
+	}
+	for _, path := range []string{newFile, newDir} {
+		_, err = os.Stat(path)
+		require.NoError(t, err, path)
+	}
+}
+
+func TestCleanUpTmpFilesMixedDirectory(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	outsideDir, err := ioutil.TempDir("", "cleanup-test-outside")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+		_ = os.RemoveAll(outsideDir)
+	})
+
+	subDir := filepath.Join(imagesDir, "sub")
+	require.NoError(t, os.MkdirAll(subDir, 0700))
+
+	twoDaysAgo := time.Now().Add(-time.Hour * 48)
+	oldFiles := []string{
+		filepath.Join(imagesDir, "old.png"),
+		filepath.Join(subDir, "old.png"),
+	}
+	target := filepath.Join(outsideDir, "target.png")
+	for _, file := range append(oldFiles, target) {
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, twoDaysAgo, twoDaysAgo))
+	}
+	fileLink := filepath.Join(imagesDir, "link.png")
+	dirLink := filepath.Join(imagesDir, "outside")
+	require.NoError(t, os.Symlink(target, fileLink))
+	require.NoError(t, os.Symlink(outsideDir, dirLink))
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:        imagesDir,
+			TempDataLifetime: time.Hour * 24,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(len(oldFiles)), deleted)
+
+	for _, path := range oldFiles {
+		_, err = os.Stat(path)
+		require.True(t, os.IsNotExist(err), path)
+	}
+	// symlinks are neither removed nor followed
+	for _, path := range []string{fileLink, dirLink, subDir} {
+		_, err = os.Lstat(path)
+		require.NoError(t, err, path)
+	}
+	_, err = os.Stat(target)
+	require.NoError(t, err)
+}
+
+func TestCleanUpTmpFilesReadOnlyDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("directory permissions aren't enforced on Windows")
+	}
+
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(imagesDir, 0500))
+	t.Cleanup(func() {
+		_ = os.Chmod(imagesDir, 0700)
+		_ = os.RemoveAll(imagesDir)
+	})
+	if probeWritable(imagesDir) == nil {
+		t.Skip("read-only directories are writable for this user, e.g. root")
+	}
+
+	taskNames := func(cfg *setting.Cfg) []string {
+		service := &CleanUpService{Cfg: cfg}
+		require.NoError(t, service.Init())
+
+		var names []string
+		for _, task := range service.registeredTasks() {
+			names = append(names, task.Name())
+		}
+		return names
+	}
+
+	cfg := &setting.Cfg{
+		ImagesDir:        imagesDir,
+		TempDataLifetime: time.Hour * 24,
+		CleanupTempFiles: setting.CleanupTaskSettings{Enabled: true, Interval: time.Minute * 10},
+	}
+	require.NotContains(t, taskNames(cfg), taskTmpFiles)
+
+	cfg.TempDataForceCleanup = true
+	require.Contains(t, taskNames(cfg), taskTmpFiles)
+}
+
+func TestCleanUpTmpFilesWritableDir(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		ImagesDir:        imagesDir,
+		CleanupTempFiles: setting.CleanupTaskSettings{Enabled: true, Interval: time.Minute * 10},
+	}}
+	require.NoError(t, service.Init())
+	require.Len(t, service.registeredTasks(), 1)
+
+	// the probe doesn't leave anything behind
+	files, err := ioutil.ReadDir(imagesDir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestCleanUpTmpFilesFutureModTime(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	files := map[string]time.Time{
+		filepath.Join(imagesDir, "future.png"): time.Now().Add(time.Hour * 24 * 365),
+		filepath.Join(imagesDir, "past.png"):   time.Now().Add(-time.Hour * 48),
+	}
+	for file, mtime := range files {
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:        imagesDir,
+			TempDataLifetime: time.Hour * 24,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	_, err = os.Stat(filepath.Join(imagesDir, "future.png"))
+	require.NoError(t, err)
+}
+
+func TestCleanUpTmpFilesAlertImages(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	files := map[string]time.Time{
+		rendering.AlertImagePrefix + "recent.png": time.Now().Add(-time.Hour * 48),
+		rendering.AlertImagePrefix + "old.png":    time.Now().Add(-time.Hour * 24 * 8),
+		"recent.png":                              time.Now().Add(-time.Hour),
+		"old.png":                                 time.Now().Add(-time.Hour * 48),
+	}
+	for name, mtime := range files {
+		file := filepath.Join(imagesDir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:              imagesDir,
+			TempDataLifetime:       time.Hour * 24,
+			AlertingImageRetention: time.Hour * 24 * 7,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+
+	left, err := ioutil.ReadDir(imagesDir)
+	require.NoError(t, err)
+	var names []string
+	for _, file := range left {
+		names = append(names, file.Name())
+	}
+	require.ElementsMatch(t, []string{rendering.AlertImagePrefix + "recent.png", "recent.png"}, names)
+}
+
+type fakeTempStorage struct {
+	files   map[string]TempFile
+	deleted []string
+	// denied files fail to be deleted for lack of permission
+	denied map[string]bool
+}
+
+func (s *fakeTempStorage) List(ctx context.Context) ([]TempFile, error) {
+	files := make([]TempFile, 0, len(s.files))
+	for _, file := range s.files {
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (s *fakeTempStorage) ModTime(file TempFile) time.Time {
+	return file.modTime
+}
+
+func (s *fakeTempStorage) Delete(ctx context.Context, file TempFile) error {
+	if _, ok := s.files[file.Path]; !ok {
+		return os.ErrNotExist
+	}
+	if s.denied[file.Path] {
+		return &os.PathError{Op: "remove", Path: file.Path, Err: os.ErrPermission}
+	}
+	delete(s.files, file.Path)
+	s.deleted = append(s.deleted, file.Path)
+	return nil
+}
+
+func TestCleanUpTmpFilesTempStorage(t *testing.T) {
+	now := time.Now()
+	storage := &fakeTempStorage{files: map[string]TempFile{}}
+	for _, file := range []TempFile{
+		{Path: "images/old.png", Size: 10, modTime: now.Add(-time.Hour * 48)},
+		{Path: "images/old.keep", Size: 10, modTime: now.Add(-time.Hour * 48)},
+		{Path: "images/alert.png", Size: 10, AlertImage: true, modTime: now.Add(-time.Hour * 48)},
+		{Path: "images/recent.png", Size: 10, modTime: now.Add(-time.Hour)},
+	} {
+		storage.files[file.Path] = file
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			TempDataLifetime:        time.Hour * 24,
+			TempDataExcludePatterns: []string{"*.keep"},
+			AlertingImageRetention:  time.Hour * 24 * 7,
+		},
+	}
+	require.NoError(t, service.Init())
+	service.tempStorage = storage
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	require.Equal(t, []string{"images/old.png"}, storage.deleted)
+}
+
+func TestCleanUpTmpFilesPermissionDenied(t *testing.T) {
+	now := time.Now()
+	storage := &fakeTempStorage{files: map[string]TempFile{}, denied: map[string]bool{"images/foreign.png": true}}
+	for _, file := range []TempFile{
+		{Path: "images/old.png", Size: 10, modTime: now.Add(-time.Hour * 48)},
+		{Path: "images/foreign.png", Size: 10, modTime: now.Add(-time.Hour * 48)},
+	} {
+		storage.files[file.Path] = file
+	}
+
+	service := &CleanUpService{Cfg: &setting.Cfg{TempDataLifetime: time.Hour * 24, TempDataSkipForeignFiles: true}}
+	require.NoError(t, service.Init())
+	service.tempStorage = storage
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	require.Equal(t, []string{"images/old.png"}, storage.deleted)
+	require.Equal(t, map[string]bool{"images/foreign.png": true}, service.foreignFiles)
+
+	// the next runs remember the file instead of logging it again
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	require.Equal(t, map[string]bool{"images/foreign.png": true}, service.foreignFiles)
+
+	// files that are gone are forgotten
+	delete(storage.files, "images/foreign.png")
+	_, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, service.foreignFiles)
+}
+
+func TestCleanUpTmpFilesAgeHistogram(t *testing.T) {
+	now := time.Now()
+	storage := &fakeTempStorage{files: map[string]TempFile{}}
+	for _, file := range []TempFile{
+		{Path: "images/recent.png", Size: 10, modTime: now.Add(-time.Hour)},
+		{Path: "images/old.png", Size: 10, modTime: now.Add(-time.Hour * 48)},
+		{Path: "images/old.keep", Size: 10, modTime: now.Add(-time.Hour * 48)},
+	} {
+		storage.files[file.Path] = file
+	}
+
+	observed := func() (uint64, float64) {
+		var m dto.Metric
+		require.NoError(t, metrics.MTempFilesAge.Write(&m))
+		return m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum()
+	}
+
+	for _, enabled := range []bool{true, false} {
+		service := &CleanUpService{
+			Cfg: &setting.Cfg{
+				TempDataLifetime:        time.Hour * 24,
+				TempDataExcludePatterns: []string{"*.keep"},
+				TempDataAgeHistogram:    enabled,
+			},
+			clock: func() time.Time { return now },
+		}
+		require.NoError(t, service.Init())
+		service.tempStorage = storage
+
+		countBefore, sumBefore := observed()
+		_, err := service.cleanUpTmpFiles(context.Background())
+		require.NoError(t, err)
+
+		count, sum := observed()
+		if !enabled {
+			require.Equal(t, countBefore, count)
+			continue
+		}
+		// excluded and deleted files count as well
+		require.Equal(t, countBefore+3, count)
+		require.InDelta(t, (time.Hour * 97).Seconds(), sum-sumBefore, 1)
+	}
+}
+
+func TestAlertImageBucket(t *testing.T) {
+	origRaw := setting.Raw
+	t.Cleanup(func() { setting.Raw = origRaw })
+	useBucket := func(path string) {
+		raw, err := ini.Load([]byte("[external_image_storage.s3]\nbucket = alerts\nregion = us-east-1\npath = " + path))
+		require.NoError(t, err)
+		setting.Raw = raw
+	}
+
+	cfg := &setting.Cfg{
+		CleanupInterval:         time.Minute * 10,
+		CleanupTempFiles:        setting.CleanupTaskSettings{Enabled: true, Interval: time.Minute * 10},
+		CleanupAlertImageBucket: setting.CleanupTaskSettings{Enabled: true, Interval: time.Minute * 10},
+	}
+
+	// every object of the bucket would count as an alert image
+	useBucket("")
+	service := &CleanUpService{Cfg: cfg, ServerLockService: &serverlock.ServerLockService{}}
+	require.Error(t, service.Init())
+
+	useBucket("grafana/alerts")
+	service = &CleanUpService{Cfg: cfg, ServerLockService: &serverlock.ServerLockService{}}
+	require.NoError(t, service.Init())
+	require.IsType(t, &bucketTempStorage{}, service.alertImageBucket)
+	require.Equal(t, "grafana/alerts/", service.alertImageBucket.(*bucketTempStorage).bucket.Path())
+
+	// the local temp files are still cleaned up alongside the bucket
+	require.IsType(t, &localTempStorage{}, service.tempStorage)
+	locks := map[string]string{}
+	for _, task := range service.registeredTasks() {
+		locks[task.Name()] = taskLockName(task)
+	}
+	require.Contains(t, locks, taskTmpFiles)
+	require.Equal(t, "delete alert image bucket", locks[taskAlertImageBucket])
+}
+
+func TestCleanUpAlertImageBucket(t *testing.T) {
+	now := time.Now()
+	storage := &fakeTempStorage{files: map[string]TempFile{
+		"grafana/alerts/old.png": {Path: "grafana/alerts/old.png", Size: 1, AlertImage: true, modTime: now.Add(-time.Hour * 48)},
+		"grafana/alerts/new.png": {Path: "grafana/alerts/new.png", Size: 1, AlertImage: true, modTime: now.Add(-time.Hour)},
+	}}
+	service := &CleanUpService{Cfg: &setting.Cfg{AlertingImageRetention: time.Hour * 24}}
+	require.NoError(t, service.Init())
+	service.alertImageBucket = storage
+
+	deleted, err := service.cleanUpAlertImageBucket(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	require.Equal(t, []string{"grafana/alerts/old.png"}, storage.deleted)
+}
+
+func TestCleanUpTmpFilesTrashDir(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	orgDir := filepath.Join(imagesDir, "1")
+	trashDir := filepath.Join(imagesDir, "trash")
+	for _, dir := range []string{orgDir, trashDir} {
+		require.NoError(t, os.MkdirAll(dir, 0700))
+	}
+
+	twoDaysAgo := time.Now().Add(-time.Hour * 48)
+	tenDaysAgo := time.Now().Add(-time.Hour * 24 * 10)
+	files := map[string]time.Time{
+		filepath.Join(imagesDir, "old.png"):  twoDaysAgo,
+		filepath.Join(orgDir, "old.png"):     twoDaysAgo,
+		filepath.Join(imagesDir, "new.png"):  time.Now(),
+		filepath.Join(trashDir, "kept.png"):  twoDaysAgo,
+		filepath.Join(trashDir, "stale.png"): tenDaysAgo,
+	}
+	for file, mtime := range files {
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:             imagesDir,
+			TempDataLifetime:      time.Hour * 24,
+			TempDataTrashDir:      trashDir,
+			TempDataTrashLifetime: time.Hour * 24 * 7,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(3), deleted)
+
+	trashed, err := ioutil.ReadDir(trashDir)
+	require.NoError(t, err)
+	var names []string
+	for _, file := range trashed {
+		names = append(names, file.Name())
+	}
+	require.ElementsMatch(t, []string{"kept.png", "old.png", "old-1.png"}, names)
+
+	remaining, err := ioutil.ReadDir(imagesDir)
+	require.NoError(t, err)
+	require.Len(t, remaining, 3) // 1, new.png and trash
+}
+
+func TestParseTempDataLifetimeByExtension(t *testing.T) {
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			TempDataLifetimeByExtension: []string{".csv=1h", "PNG=10m", ".keep=0", ".svg", "=1h", ".log=soon", ".tmp=-1h"},
+		},
+	}
+	require.NoError(t, service.Init())
+	require.Equal(t, map[string]time.Duration{
+		".csv":  time.Hour,
+		".png":  time.Minute * 10,
+		".keep": 0,
+	}, service.tempDataExtensionLifetimes)
+}
+
+func TestCleanUpTmpFilesExcludePatterns(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	twoDaysAgo := time.Now().Add(-time.Hour * 48)
+	for _, name := range []string{"render.png", "logo.png", "icon.svg"} {
+		file := filepath.Join(imagesDir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, twoDaysAgo, twoDaysAgo))
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:               imagesDir,
+			TempDataLifetime:        time.Hour * 24,
+			TempDataExcludePatterns: []string{"logo.png", "*.svg", "[invalid"},
+		},
+	}
+	require.NoError(t, service.Init())
+	require.Equal(t, []string{"logo.png", "*.svg"}, service.tempDataExcludePatterns)
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	_, err = os.Stat(filepath.Join(imagesDir, "render.png"))
+	require.True(t, os.IsNotExist(err))
+	for _, name := range []string{"logo.png", "icon.svg"} {
+		_, err = os.Stat(filepath.Join(imagesDir, name))
+		require.NoError(t, err, name)
+	}
+}
+
+func TestCleanUpTmpFilesMaxSize(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	// Files are 10 bytes each, file-0.png being the oldest.
+	var files []string
+	for i := 0; i < 4; i++ {
+		file := filepath.Join(imagesDir, fmt.Sprintf("file-%d.png", i))
+		require.NoError(t, ioutil.WriteFile(file, make([]byte, 10), 0600))
+		modTime := time.Now().Add(-time.Duration(4-i) * time.Minute)
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+		files = append(files, file)
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:        imagesDir,
+			TempDataLifetime: time.Hour * 24,
+			TempDataMaxSize:  25,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+
+	for i, file := range files {
+		_, err = os.Stat(file)
+		if i < 2 {
+			require.True(t, os.IsNotExist(err), file)
+		} else {
+			require.NoError(t, err, file)
+		}
+	}
+}
+
+// statlessFileInfo is a file without the platform specific stat, like the
+// files of some virtual filesystems.
+type statlessFileInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (i statlessFileInfo) ModTime() time.Time { return i.modTime }
+func (i statlessFileInfo) Sys() interface{}   { return nil }
+
+func TestTempFileTimeFallback(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	for _, source := range []string{"", "mtime", "ctime", "atime"} {
+		service := &CleanUpService{Cfg: &setting.Cfg{TempDataTimeSource: source, TempDataUseChangeTime: true}}
+		require.Equal(t, modTime, service.tempFileTime(statlessFileInfo{modTime: modTime}), "source: %q", source)
+	}
+}
+
+func TestCleanUpTmpFilesMaxFiles(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	// Files are 10 bytes each, file-0.png being the oldest. file-0.png and
+	// file-1.png are expired.
+	const count = 500
+	var files []string
+	for i := 0; i < count; i++ {
+		file := filepath.Join(imagesDir, fmt.Sprintf("file-%d.png", i))
+		require.NoError(t, ioutil.WriteFile(file, make([]byte, 10), 0600))
+		modTime := time.Now().Add(-time.Duration(count-i) * time.Minute)
+		if i < 2 {
+			modTime = modTime.Add(-time.Hour * 24)
+		}
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+		files = append(files, file)
+	}
+
+	exists := func(file string) bool {
+		_, err := os.Stat(file)
+		return err == nil
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:        imagesDir,
+			TempDataLifetime: time.Hour * 24,
+			TempDataMaxFiles: 300,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(200), deleted)
+	for i, file := range files {
+		require.Equal(t, i >= 200, exists(file), file)
+	}
+
+	// the limit that removes more files wins
+	service.Cfg.TempDataMaxFiles = 250
+	service.Cfg.TempDataMaxSize = 2000
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(100), deleted)
+	for i, file := range files {
+		require.Equal(t, i >= 300, exists(file), file)
+	}
+
+	service.Cfg.TempDataMaxSize = 0
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), deleted)
+}
+
+func TestCleanUpTmpFilesCancelled(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	twoDaysAgo := time.Now().Add(-time.Hour * 48)
+	var files []string
+	for i := 0; i < 100; i++ {
+		file := filepath.Join(imagesDir, fmt.Sprintf("file-%d.png", i))
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, twoDaysAgo, twoDaysAgo))
+		files = append(files, file)
+	}
+
+	service := &CleanUpService{
+		Cfg: enableAllTasks(&setting.Cfg{
+			ImagesDir:        imagesDir,
+			TempDataLifetime: time.Hour * 24,
+		}),
+		ServerLockService: &serverlock.ServerLockService{},
+	}
+	require.NoError(t, service.Init())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	deleted, err := service.cleanUpTmpFiles(ctx)
+	require.Equal(t, context.Canceled, err)
+	require.Less(t, deleted, int64(len(files)))
+
+	remaining, err := ioutil.ReadDir(imagesDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, remaining)
+
+	report := service.RunOnce(ctx)
+	require.True(t, errors.Is(taskResult(t, report, taskExpiredSnapshots).Err, context.Canceled))
+}
+
+type fakeCleanupTask struct {
+	name    string
+	deleted int64
+	runs    int
+}
+
+func (t *fakeCleanupTask) Name() string {
+	return t.name
+}
+
+func (t *fakeCleanupTask) Run(ctx context.Context) (int64, error) {
+	t.runs++
+	return t.deleted, nil
+}
+
+func (t *fakeCleanupTask) Interval() time.Duration {
+	return time.Hour
+}
+
+func TestRegisterTask(t *testing.T) {
+	lockService := &serverlock.ServerLockService{SQLStore: sqlstore.InitTestDB(t)}
+	require.NoError(t, lockService.Init())
+
+	service := &CleanUpService{
+		Cfg:               enableAllTasks(&setting.Cfg{CleanupInterval: time.Minute * 10}),
+		ServerLockService: lockService,
+	}
+
+	early := &fakeCleanupTask{name: "registered_before_init", deleted: 2}
+	require.NoError(t, service.RegisterTask(early))
+	require.NoError(t, service.Init())
+
+	late := &fakeCleanupTask{name: "registered_after_init", deleted: 3}
+	require.NoError(t, service.RegisterTask(late))
+	require.Error(t, service.RegisterTask(&fakeCleanupTask{name: taskExpiredSnapshots}))
+
+	report := service.RunOnce(context.Background())
+	require.Equal(t, TaskResult{Name: early.name, Deleted: 2}, taskResult(t, report, early.name))
+	require.Equal(t, TaskResult{Name: late.name, Deleted: 3}, taskResult(t, report, late.name))
+	_, ok := report.Task(taskExpiredSnapshots)
+	require.True(t, ok)
+
+	// registered tasks are guarded by a server lock named after the task
+	report = service.RunOnce(context.Background())
+	require.True(t, taskResult(t, report, late.name).Skipped)
+	require.Equal(t, 1, late.runs)
+}
+
+func enableAllTasks(cfg *setting.Cfg) *setting.Cfg {
+	cfg.CleanupTempFiles = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupExpiredSnapshots = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupSnapshotsOverLimit = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupExpiredVersions = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupOldAnnotations = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupOrphanedAnnotations = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupOldLoginAttempts = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupExpiredAPIKeys = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupExpiredUserInvites = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupExpiredAuthTokens = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupOrphanedDashboardAcl = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupStaleServerLocks = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupOrphanedPreferences = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupOrphanedStars = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupOrphanedPlaylistItems = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupDuplicateUserInvites = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupExpiredCacheData = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.RemoteCacheOptions = &setting.RemoteCacheOptions{Name: "database"}
+	cfg.CleanupExportFiles = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupUploadedFiles = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupStaleDashboardProvisioning = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	cfg.CleanupDeepScrub = setting.CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval}
+	return cfg
+}
+
+func TestDisabledTasks(t *testing.T) {
+	cfg := enableAllTasks(&setting.Cfg{CleanupInterval: time.Minute * 10})
+	cfg.CleanupExpiredVersions.Enabled = false
+	service := &CleanUpService{Cfg: cfg, ServerLockService: &serverlock.ServerLockService{}}
+	require.NoError(t, service.Init())
+
+	var names []string
+	for _, task := range service.registeredTasks() {
+		names = append(names, task.Name())
+	}
+	require.NotContains(t, names, taskExpiredDashboardVersions)
+	require.Contains(t, names, taskExpiredSnapshots)
+	require.Contains(t, names, taskOldLoginAttempts)
+}
+
+func TestRunSchedulesTasksWithoutOverlap(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+
+	var runs, active, maxActive int32
+	slow := &cleanupTask{
+		name:     "slow",
+		interval: time.Millisecond * 10,
+		run: func(ctx context.Context) (int64, error) {
+			atomic.AddInt32(&runs, 1)
+			n := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			if n > atomic.LoadInt32(&maxActive) {
+				atomic.StoreInt32(&maxActive, n)
+			}
+			// outlive several intervals of the scheduler
+			time.Sleep(time.Millisecond * 50)
+			return 0, nil
+		},
+	}
+	require.NoError(t, service.RegisterTask(slow))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+
+	require.GreaterOrEqual(t, atomic.LoadInt32(&runs), int32(2))
+	require.Equal(t, int32(1), atomic.LoadInt32(&maxActive))
+}
+
+func TestRunSchedulesTasksOnTheirOwnInterval(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+
+	// e.g. the temp files swept every minute while the database is pruned every 10 minutes
+	var fastRuns, slowRuns int32
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name:     "fast",
+		interval: time.Millisecond * 10,
+		run: func(ctx context.Context) (int64, error) {
+			atomic.AddInt32(&fastRuns, 1)
+			return 0, nil
+		},
+	}))
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name:     "slow",
+		interval: time.Millisecond * 100,
+		run: func(ctx context.Context) (int64, error) {
+			atomic.AddInt32(&slowRuns, 1)
+			return 0, nil
+		},
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*250)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+
+	require.GreaterOrEqual(t, atomic.LoadInt32(&slowRuns), int32(1))
+	require.Greater(t, atomic.LoadInt32(&fastRuns), 3*atomic.LoadInt32(&slowRuns))
+}
+
+func TestJitter(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		jittered := jitter(r, time.Minute*10)
+		require.GreaterOrEqual(t, int64(jittered), int64(time.Minute*9))
+		require.LessOrEqual(t, int64(jittered), int64(time.Minute*11))
+	}
+
+	require.Equal(t, time.Nanosecond*5, jitter(r, time.Nanosecond*5))
+}
+
+func TestRunOnStartup(t *testing.T) {
+	origStartupDelay := startupDelay
+	startupDelay = func() time.Duration { return 0 }
+	defer func() { startupDelay = origStartupDelay }()
+
+	lockService := &serverlock.ServerLockService{SQLStore: sqlstore.InitTestDB(t)}
+	for _, runOnStartup := range []bool{true, false} {
+		service := &CleanUpService{
+			Cfg:               &setting.Cfg{CleanupInterval: time.Minute * 10, CleanupRunOnStartup: runOnStartup},
+			ServerLockService: lockService,
+		}
+		require.NoError(t, service.Init())
+
+		var runs int32
+		require.NoError(t, service.RegisterTask(&cleanupTask{
+			name:     "locked",
+			lockName: fmt.Sprintf("run on startup %t", runOnStartup),
+			interval: time.Hour,
+			run: func(ctx context.Context) (int64, error) {
+				atomic.AddInt32(&runs, 1)
+				return 0, nil
+			},
+		}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+		cancel()
+
+		expected := int32(0)
+		if runOnStartup {
+			expected = 1
+		}
+		require.Equal(t, expected, atomic.LoadInt32(&runs), "run on startup: %t", runOnStartup)
+	}
+}
+
+func TestCleanupConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 2} {
+		service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10, CleanupConcurrency: concurrency}}
+		require.NoError(t, service.Init())
+
+		var active, maxActive int32
+		for i := 0; i < 4; i++ {
+			require.NoError(t, service.RegisterTask(&cleanupTask{
+				name: fmt.Sprintf("slow%d", i),
+				run: func(ctx context.Context) (int64, error) {
+					n := atomic.AddInt32(&active, 1)
+					defer atomic.AddInt32(&active, -1)
+					for {
+						current := atomic.LoadInt32(&maxActive)
+						if n <= current || atomic.CompareAndSwapInt32(&maxActive, current, n) {
+							break
+						}
+					}
+					time.Sleep(time.Millisecond * 20)
+					return 1, nil
+				},
+			}))
+		}
+
+		report := service.RunOnce(context.Background())
+		require.Len(t, report.Tasks, 4)
+		for _, task := range report.Tasks {
+			require.Equal(t, int64(1), task.Deleted, task.Name)
+		}
+		require.Equal(t, int32(concurrency), atomic.LoadInt32(&maxActive), "concurrency: %d", concurrency)
+	}
+}
+
+func TestPanickingTaskDoesNotStopCleanup(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "panicking",
+		run: func(ctx context.Context) (int64, error) {
+			var cfg *setting.Cfg
+			return int64(cfg.CleanupInterval), nil
+		},
+	}))
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "after_panic",
+		run: func(ctx context.Context) (int64, error) {
+			return 1, nil
+		},
+	}))
+
+	for i := 0; i < 2; i++ {
+		report := service.RunOnce(context.Background())
+		require.Contains(t, taskResult(t, report, "panicking").Err.Error(), "panicked")
+		require.Equal(t, TaskResult{Name: "after_panic", Deleted: 1}, taskResult(t, report, "after_panic"))
+	}
+}
+
+func TestDeleteStaleServerLocks(t *testing.T) {
+	lockService := &serverlock.ServerLockService{SQLStore: sqlstore.InitTestDB(t)}
+	require.NoError(t, lockService.Init())
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ServerLockRetention:     time.Hour * 24,
+			CleanupStaleServerLocks: setting.CleanupTaskSettings{Enabled: true, Interval: time.Minute * 10},
+		},
+		ServerLockService: lockService,
+	}
+	require.NoError(t, service.Init())
+
+	ctx := context.Background()
+	require.NoError(t, lockService.LockAndExecute(ctx, "removed task", time.Hour, func() {}))
+	err := lockService.SQLStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("UPDATE server_lock SET last_execution = ?", time.Now().Add(-time.Hour*48).Unix())
+		return err
+	})
+	require.NoError(t, err)
+
+	// the task runs under its own lock, which must survive the cleanup
+	report := service.RunOnce(ctx)
+	require.Equal(t, TaskResult{Name: taskStaleServerLocks, Deleted: 1}, taskResult(t, report, taskStaleServerLocks))
+
+	locks, err := lockService.ActiveLocks(ctx, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	require.Equal(t, "delete stale server locks", locks[0].OperationUID)
+}
+
+func TestDeleteExpiredUserInvites(t *testing.T) {
+	sqlstore.InitTestDB(t)
+
+	createInvite := func() {
+		cmd := models.CreateTempUserCommand{OrgId: 1, Code: util.GenerateShortUID(), Status: models.TmpUserInvitePending}
+		require.NoError(t, bus.Dispatch(&cmd))
+	}
+	countInvites := func() int {
+		query := models.GetTempUsersQuery{OrgId: 1, Status: models.TmpUserInvitePending}
+		require.NoError(t, bus.Dispatch(&query))
+		return len(query.Result)
+	}
+
+	for _, days := range []int{0, -1, 7} {
+		createInvite()
+		service := &CleanUpService{Cfg: &setting.Cfg{UserInviteMaxLifetimeDays: days}}
+		require.NoError(t, service.Init())
+
+		// let the invite be older than a cutoff of now
+		time.Sleep(time.Millisecond * 10)
+		deleted, err := service.deleteExpiredUserInvites(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(0), deleted, "days: %d", days)
+	}
+	require.Equal(t, 3, countInvites())
+}
+
+func TestDeleteExpiredSignUps(t *testing.T) {
+	sqlstore.InitTestDB(t)
+
+	createTempUser := func(status models.TempUserStatus) {
+		cmd := models.CreateTempUserCommand{OrgId: 1, Code: util.GenerateShortUID(), Status: status}
+		require.NoError(t, bus.Dispatch(&cmd))
+	}
+	countTempUsers := func(status models.TempUserStatus) int {
+		query := models.GetTempUsersQuery{OrgId: 1, Status: status}
+		require.NoError(t, bus.Dispatch(&query))
+		return len(query.Result)
+	}
+
+	createTempUser(models.TmpUserInvitePending)
+	createTempUser(models.TmpUserSignUpStarted)
+	createTempUser(models.TmpUserSignUpStarted)
+
+	service := &CleanUpService{Cfg: &setting.Cfg{UserInviteMaxLifetimeDays: 7, VerificationEmailMaxLifetime: time.Millisecond}}
+	require.NoError(t, service.Init())
+
+	// let the sign ups outlive their lifetime
+	time.Sleep(time.Millisecond * 10)
+	deleted, err := service.deleteExpiredUserInvites(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+	require.Equal(t, 1, countTempUsers(models.TmpUserInvitePending))
+	require.Equal(t, 0, countTempUsers(models.TmpUserSignUpStarted))
+}
+
+func TestDeleteExpiredUserInvitesRecoveryWindow(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+
+	createTempUser := func(status models.TempUserStatus, age time.Duration) {
+		cmd := models.CreateTempUserCommand{OrgId: 1, Code: util.GenerateShortUID(), Status: status}
+		require.NoError(t, bus.Dispatch(&cmd))
+		_, err := sqlStore.NewSession().Exec("UPDATE temp_user SET created = ? WHERE id = ?", time.Now().Add(-age), cmd.Result.Id)
+		require.NoError(t, err)
+	}
+	countTempUsers := func(status models.TempUserStatus) int {
+		query := models.GetTempUsersQuery{OrgId: 1, Status: status}
+		require.NoError(t, bus.Dispatch(&query))
+		return len(query.Result)
+	}
+
+	const day = time.Hour * 24
+	createTempUser(models.TmpUserInvitePending, 8*day)
+	createTempUser(models.TmpUserInvitePending, 8*day)
+	createTempUser(models.TmpUserInvitePending, day)
+	createTempUser(models.TmpUserRevoked, 8*day)
+	createTempUser(models.TmpUserSignUpStarted, 8*day)
+
+	service := &CleanUpService{Cfg: &setting.Cfg{UserInviteMaxLifetimeDays: 7, UserInviteRecoveryWindow: 3 * day}}
+	require.NoError(t, service.Init())
+
+	// the first phase only marks the usable invites and sign ups
+	deleted, err := service.deleteExpiredUserInvites(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted, "revoked invites are deleted right away")
+	require.Equal(t, 1, countTempUsers(models.TmpUserInvitePending))
+	require.Equal(t, 2, countTempUsers(models.TmpUserInviteExpired))
+	require.Equal(t, 1, countTempUsers(models.TmpUserSignUpExpired))
+	require.Equal(t, 0, countTempUsers(models.TmpUserRevoked))
+
+	// restoring an invite within the window keeps it
+	query := models.GetTempUsersQuery{OrgId: 1, Status: models.TmpUserInviteExpired}
+	require.NoError(t, bus.Dispatch(&query))
+	require.NoError(t, bus.Dispatch(&models.UpdateTempUserStatusCommand{Code: query.Result[0].Code, Status: models.TmpUserInvitePending}))
+	service.Cfg.UserInviteMaxLifetimeDays = 10
+
+	deleted, err = service.deleteExpiredUserInvites(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), deleted, "marked invites are kept during the window")
+
+	// the second phase deletes the ones marked for longer than the window
+	service.clock = func() time.Time { return time.Now().Add(4 * day) }
+	service.Cfg.UserInviteMaxLifetimeDays = 7
+	deleted, err = service.deleteExpiredUserInvites(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+	require.Equal(t, 0, countTempUsers(models.TmpUserSignUpExpired))
+	// the restored invite expired again and starts a new window
+	require.Equal(t, 1, countTempUsers(models.TmpUserInviteExpired))
+	require.Equal(t, 1, countTempUsers(models.TmpUserInvitePending))
+}
+
+// taskResult returns the result of the named task without its duration.
+func taskResult(t *testing.T, report CleanupReport, name string) TaskResult {
+	t.Helper()
+
+	result, ok := report.Task(name)
+	require.True(t, ok, "no result for task %q", name)
+	result.Duration = 0
+	return result
+}
+
+func TestStatus(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+
+	var fail bool
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "flaky",
+		run: func(ctx context.Context) (int64, error) {
+			if fail {
+				return 0, fmt.Errorf("database is locked")
+			}
+			return 2, nil
+		},
+	}))
+	require.True(t, service.Status()["flaky"].LastRun.IsZero())
+
+	service.RunOnce(context.Background())
+	status := service.Status()["flaky"]
+	require.False(t, status.LastRun.IsZero())
+	require.Equal(t, status.LastRun, status.LastSuccess)
+	require.Equal(t, int64(2), status.LastDeleted)
+	require.Empty(t, status.LastError)
+
+	fail = true
+	service.RunOnce(context.Background())
+	failed := service.Status()["flaky"]
+	require.True(t, failed.LastRun.After(status.LastRun))
+	require.Equal(t, status.LastSuccess, failed.LastSuccess)
+	require.Equal(t, int64(0), failed.LastDeleted)
+	require.Equal(t, "database is locked", failed.LastError)
+}
+
+func TestRunTask(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+
+	var ran []string
+	for _, name := range []string{"first", "second"} {
+		name := name
+		require.NoError(t, service.RegisterTask(&cleanupTask{
+			name: name,
+			run: func(ctx context.Context) (int64, error) {
+				ran = append(ran, name)
+				return 1, nil
+			},
+		}))
+	}
+
+	result, err := service.RunTask(context.Background(), "second")
+	require.NoError(t, err)
+	result.Duration = 0
+	require.Equal(t, TaskResult{Name: "second", Deleted: 1}, result)
+	require.Equal(t, []string{"second"}, ran)
+
+	_, err = service.RunTask(context.Background(), "third")
+	require.Error(t, err)
+}
+
+func TestWithoutServerLockService(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		CleanupInterval:         time.Minute * 10,
+		ImagesDir:               imagesDir,
+		TempDataLifetime:        time.Hour,
+		CleanupTempFiles:        setting.CleanupTaskSettings{Enabled: true, Interval: time.Minute * 10},
+		CleanupOldLoginAttempts: setting.CleanupTaskSettings{Enabled: true, Interval: time.Minute * 10},
+	}}
+	require.NoError(t, service.Init())
+	require.NoError(t, service.RegisterTask(&fakeCleanupTask{name: "locked"}))
+
+	report := service.RunOnce(context.Background())
+	require.NoError(t, taskResult(t, report, taskTmpFiles).Err)
+	_, ok := report.Task(taskOldLoginAttempts)
+	require.False(t, ok, "tasks behind a server lock should be disabled")
+	require.EqualError(t, taskResult(t, report, "locked").Err, `cleanup task "locked" runs behind a server lock, but there is no server lock service`)
+}
+
+func TestForceRunTask(t *testing.T) {
+	lockService := &serverlock.ServerLockService{SQLStore: sqlstore.InitTestDB(t)}
+	require.NoError(t, lockService.Init())
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}, ServerLockService: lockService}
+	require.NoError(t, service.Init())
+
+	var runs int
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name:     "forced",
+		lockName: "forced",
+		interval: time.Hour,
+		run: func(ctx context.Context) (int64, error) {
+			runs++
+			return 1, nil
+		},
+	}))
+
+	result, err := service.RunTask(context.Background(), "forced")
+	require.NoError(t, err)
+	require.False(t, result.Skipped)
+
+	// the lock was taken less than runOnceLockInterval ago
+	result, err = service.RunTask(context.Background(), "forced")
+	require.NoError(t, err)
+	require.True(t, result.Skipped)
+	require.Equal(t, 1, runs)
+
+	for i := 0; i < 2; i++ {
+		result, err = service.ForceRunTask(context.Background(), "forced")
+		require.NoError(t, err)
+		result.Duration = 0
+		require.Equal(t, TaskResult{Name: "forced", Deleted: 1}, result)
+	}
+	require.Equal(t, 3, runs)
+
+	_, err = service.ForceRunTask(context.Background(), "unknown")
+	require.EqualError(t, err, `unknown or disabled cleanup task "unknown", valid tasks are: forced`)
+}
+
+func TestJitteredRunsTakeTheLock(t *testing.T) {
+	store := sqlstore.InitTestDB(t)
+	lockService := &serverlock.ServerLockService{SQLStore: store}
+	require.NoError(t, lockService.Init())
+
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}, ServerLockService: lockService}
+	require.NoError(t, service.Init())
+
+	var runs int
+	task := &cleanupTask{
+		name:     "jittered",
+		lockName: "jittered",
+		interval: time.Hour,
+		run: func(ctx context.Context) (int64, error) {
+			runs++
+			return 0, nil
+		},
+	}
+
+	// the second cycle comes as early as the jitter allows, the lock only
+	// records the time of the first one in seconds
+	service.runScheduled(context.Background(), []CleanupTask{task})
+	early := task.interval - time.Duration(float64(task.interval)*scheduleJitter)
+	require.NoError(t, store.WithDbSession(context.Background(), func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("UPDATE server_lock SET last_execution = last_execution - ? WHERE operation_uid = ?", int64(early/time.Second), "jittered")
+		return err
+	}))
+	service.runScheduled(context.Background(), []CleanupTask{task})
+	require.Equal(t, 2, runs)
+
+	// other instances still skip the task right after it ran
+	other := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}, ServerLockService: lockService}
+	require.NoError(t, other.Init())
+	other.runScheduled(context.Background(), []CleanupTask{task})
+	require.Equal(t, 2, runs)
+}
+
+func TestLockContention(t *testing.T) {
+	store := sqlstore.InitTestDB(t)
+	lockService := &serverlock.ServerLockService{SQLStore: store}
+	require.NoError(t, lockService.Init())
+
+	counter := func(counter *prometheus.CounterVec) float64 {
+		var m dto.Metric
+		require.NoError(t, counter.WithLabelValues("contended").Write(&m))
+		return m.Counter.GetValue()
+	}
+	skipped, failed := counter(metrics.MCleanupLockSkippedTotal), counter(metrics.MCleanupErrorsTotal)
+
+	// two instances sharing the database
+	var runs int
+	var services []*CleanUpService
+	for i := 0; i < 2; i++ {
+		service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}, ServerLockService: lockService}
+		require.NoError(t, service.Init())
+		require.NoError(t, service.RegisterTask(&cleanupTask{
+			name:     "contended",
+			lockName: "contended",
+			interval: time.Hour,
+			run: func(ctx context.Context) (int64, error) {
+				runs++
+				return 0, nil
+			},
+		}))
+		services = append(services, service)
+	}
+
+	for _, service := range services {
+		_, err := service.RunTask(context.Background(), "contended")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, runs)
+	require.Equal(t, skipped+1, counter(metrics.MCleanupLockSkippedTotal))
+	require.Equal(t, failed, counter(metrics.MCleanupErrorsTotal))
+
+	// failing to take the lock is an error rather than a skip
+	require.NoError(t, store.WithDbSession(context.Background(), func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("ALTER TABLE server_lock RENAME TO server_lock_moved")
+		return err
+	}))
+	defer func() {
+		require.NoError(t, store.WithDbSession(context.Background(), func(sess *sqlstore.DBSession) error {
+			_, err := sess.Exec("ALTER TABLE server_lock_moved RENAME TO server_lock")
+			return err
+		}))
+	}()
+
+	result, err := services[1].RunTask(context.Background(), "contended")
+	require.NoError(t, err)
+	require.False(t, result.Skipped)
+	require.Error(t, result.Err)
+	require.Equal(t, skipped+1, counter(metrics.MCleanupLockSkippedTotal))
+	require.Equal(t, failed+1, counter(metrics.MCleanupErrorsTotal))
+}
+
+func TestCycleTimeout(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		CleanupInterval:     time.Minute * 10,
+		CleanupConcurrency:  1,
+		CleanupCycleTimeout: time.Millisecond * 100,
+	}}
+	require.NoError(t, service.Init())
+
+	var batches int64
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "batched",
+		run: func(ctx context.Context) (int64, error) {
+			for {
+				select {
+				case <-ctx.Done():
+					return batches, ctx.Err()
+				case <-time.After(time.Millisecond * 10):
+					batches++
+				}
+			}
+		},
+	}))
+
+	start := time.Now()
+	report := service.RunOnce(context.Background())
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	batched := taskResult(t, report, "batched")
+	require.True(t, errors.Is(batched.Err, context.DeadlineExceeded))
+	require.Greater(t, batched.Deleted, int64(0))
+}
+
+func TestDegradedTasks(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10, CleanupFailureThreshold: 2}}
+	require.NoError(t, service.Init())
+
+	fail := true
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "flaky",
+		run: func(ctx context.Context) (int64, error) {
+			if fail {
+				return 0, fmt.Errorf("permission denied")
+			}
+			return 1, nil
+		},
+	}))
+
+	service.RunOnce(context.Background())
+	require.Equal(t, 1, service.Status()["flaky"].ConsecutiveFailures)
+	require.Empty(t, service.DegradedTasks())
+
+	service.RunOnce(context.Background())
+	require.Equal(t, []string{"flaky"}, service.DegradedTasks())
+
+	fail = false
+	service.RunOnce(context.Background())
+	require.Equal(t, 0, service.Status()["flaky"].ConsecutiveFailures)
+	require.Empty(t, service.DegradedTasks())
+
+	service.Cfg.CleanupFailureThreshold = 0
+	fail = true
+	for i := 0; i < 3; i++ {
+		service.RunOnce(context.Background())
+	}
+	require.Empty(t, service.DegradedTasks())
+}
+
+func TestTracing(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() {
+		opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	})
+
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "traced",
+		run: func(ctx context.Context) (int64, error) {
+			return 3, nil
+		},
+	}))
+	service.RunOnce(context.Background())
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 2)
+	taskSpan, cycleSpan := spans[0], spans[1]
+	require.Equal(t, "cleanup task", taskSpan.OperationName)
+	require.Equal(t, "cleanup cycle", cycleSpan.OperationName)
+	require.Equal(t, cycleSpan.SpanContext.SpanID, taskSpan.ParentID)
+	require.Equal(t, "traced", taskSpan.Tag("task"))
+	require.Equal(t, int64(3), taskSpan.Tag("deleted"))
+}
+
+func TestDeleteExpiredExternalSnapshots(t *testing.T) {
+	var requests int32
+	snapshotServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/api/snapshots-delete/unreachable" {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(snapshotServer.Close)
+
+	removeExpired := setting.SnapShotRemoveExpired
+	setting.SnapShotRemoveExpired = true
+	t.Cleanup(func() {
+		setting.SnapShotRemoveExpired = removeExpired
+	})
+
+	for _, required := range []bool{false, true} {
+		sqlStore := sqlstore.InitTestDB(t)
+		for _, key := range []string{"deleted", "unreachable"} {
+			cmd := models.CreateDashboardSnapshotCommand{
+				Key:               key,
+				DeleteKey:         key,
+				Dashboard:         simplejson.New(),
+				External:          true,
+				ExternalDeleteUrl: snapshotServer.URL + "/api/snapshots-delete/" + key,
+			}
+			require.NoError(t, bus.Dispatch(&cmd))
+		}
+		_, err := sqlStore.NewSession().Exec("UPDATE dashboard_snapshot SET expires = ?", time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+
+		service := &CleanUpService{Cfg: &setting.Cfg{SnapshotExternalDeleteRequired: required}}
+		require.NoError(t, service.Init())
+
+		atomic.StoreInt32(&requests, 0)
+		deleted, err := service.deleteExpiredSnapshots(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int32(1+externalSnapshotDeleteAttempts), atomic.LoadInt32(&requests))
+
+		if required {
+			require.Equal(t, int64(1), deleted, "the unreachable snapshot should be kept")
+		} else {
+			require.Equal(t, int64(2), deleted)
+		}
+	}
+}
+
+func TestDeleteExpiredSnapshotsFrozenClock(t *testing.T) {
+	removeExpired := setting.SnapShotRemoveExpired
+	setting.SnapShotRemoveExpired = true
+	t.Cleanup(func() {
+		setting.SnapShotRemoveExpired = removeExpired
+	})
+
+	sqlStore := sqlstore.InitTestDB(t)
+	for key, expires := range map[string]int64{"hour": 3600, "day": 3600 * 24} {
+		cmd := models.CreateDashboardSnapshotCommand{Key: key, DeleteKey: key, OrgId: 1, Dashboard: simplejson.New(), Expires: expires}
+		require.NoError(t, bus.Dispatch(&cmd))
+	}
+
+	now := time.Now()
+	service := &CleanUpService{
+		Cfg:   &setting.Cfg{},
+		clock: func() time.Time { return now },
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.deleteExpiredSnapshots(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), deleted)
+
+	// two hours later only the snapshot expiring after an hour is gone
+	now = now.Add(time.Hour * 2)
+	deleted, err = service.deleteExpiredSnapshots(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	var left []*models.DashboardSnapshot
+	require.NoError(t, sqlStore.NewSession().Find(&left))
+	require.Len(t, left, 1)
+	require.Equal(t, "day", left[0].Key)
+}
+
+func TestTrimSnapshotsOverLimit(t *testing.T) {
+	removeExpired := setting.SnapShotRemoveExpired
+	setting.SnapShotRemoveExpired = true
+	t.Cleanup(func() {
+		setting.SnapShotRemoveExpired = removeExpired
+	})
+
+	for _, tc := range []struct {
+		desc    string
+		limit   int64
+		dryRun  bool
+		deleted int64
+		left    int64
+	}{
+		{desc: "over the limit", limit: 1, deleted: 2, left: 1},
+		{desc: "within the limit", limit: 3, deleted: 0, left: 3},
+		{desc: "dry run", limit: 1, dryRun: true, deleted: 0, left: 3},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			sqlStore := sqlstore.InitTestDB(t)
+			for _, key := range []string{"old", "recent", "newest"} {
+				cmd := models.CreateDashboardSnapshotCommand{Key: key, DeleteKey: key, OrgId: 1, Dashboard: simplejson.New()}
+				require.NoError(t, bus.Dispatch(&cmd))
+			}
+
+			service := &CleanUpService{Cfg: &setting.Cfg{CleanupDryRun: tc.dryRun, SnapshotMaxPerOrg: tc.limit}}
+			require.NoError(t, service.Init())
+
+			// the expired snapshots task leaves snapshots that haven't expired alone
+			deleted, err := service.deleteExpiredSnapshots(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, int64(0), deleted)
+
+			deleted, err = service.trimSnapshotsOverLimit(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, tc.deleted, deleted)
+
+			left, err := sqlStore.NewSession().Count(&models.DashboardSnapshot{})
+			require.NoError(t, err)
+			require.Equal(t, tc.left, left)
+		})
+	}
+}
+
+type flakyCommand struct {
+	failures int
+	calls    int
+}
+
+func TestDispatchWithRetries(t *testing.T) {
+	bus.AddHandlerCtx("test", func(ctx context.Context, cmd *flakyCommand) error {
+		cmd.calls++
+		if cmd.calls <= cmd.failures {
+			return fmt.Errorf("database is locked")
+		}
+		return nil
+	})
+
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupRetryAttempts: 3, CleanupRetryBackoff: time.Millisecond}}
+	require.NoError(t, service.Init())
+
+	cmd := flakyCommand{failures: 2}
+	require.NoError(t, service.dispatchWithRetries(context.Background(), &cmd))
+	require.Equal(t, 3, cmd.calls)
+
+	cmd = flakyCommand{failures: 3}
+	require.EqualError(t, service.dispatchWithRetries(context.Background(), &cmd), "database is locked")
+	require.Equal(t, 3, cmd.calls)
+
+	// shutting down doesn't wait for the remaining attempts
+	service.Cfg.CleanupRetryBackoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cmd = flakyCommand{failures: 3}
+	require.Error(t, service.dispatchWithRetries(ctx, &cmd))
+	require.Equal(t, 1, cmd.calls)
+}
+
+func TestVacuumAfterCleanup(t *testing.T) {
+	sqlstore.InitTestDB(t)
+
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		CleanupInterval:              time.Minute * 10,
+		CleanupSqliteVacuum:          true,
+		CleanupSqliteVacuumThreshold: 5,
+	}}
+	require.NoError(t, service.Init())
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "rows",
+		run: func(ctx context.Context) (int64, error) {
+			return 3, nil
+		},
+	}))
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: taskTmpFiles,
+		run: func(ctx context.Context) (int64, error) {
+			return 100, nil
+		},
+	}))
+
+	service.RunOnce(context.Background())
+	require.Equal(t, int64(3), service.deletedSinceVacuum)
+
+	// the rows add up across cycles until the threshold is reached
+	service.RunOnce(context.Background())
+	require.Equal(t, int64(0), service.deletedSinceVacuum)
+}
+
+func TestCleanupReportJSON(t *testing.T) {
+	report := newCleanupReport(map[string]TaskSummary{
+		taskTmpFiles:         {Deleted: 3, Duration: time.Millisecond * 1500},
+		taskOldLoginAttempts: {Skipped: true},
+		taskExpiredSnapshots: newTaskSummary(0, errors.New("database is locked")),
+	})
+	require.Equal(t, []TaskResult{report.Tasks[0]}, report.Failed())
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"tasks": [
+		{"name": "expired_snapshots", "deleted": 0, "durationMs": 0, "error": "database is locked"},
+		{"name": "old_login_attempts", "deleted": 0, "durationMs": 0, "skipped": true},
+		{"name": "tmp_files", "deleted": 3, "durationMs": 1500}
+	]}`, string(data))
+}
+
+func TestDiskPressureInterval(t *testing.T) {
+	require.Equal(t, time.Minute, diskPressureInterval(time.Minute*10, 0))
+	require.Equal(t, time.Minute*2, diskPressureInterval(time.Minute*10, 1))
+	require.Equal(t, time.Minute*8, diskPressureInterval(time.Minute*10, 3))
+	require.Equal(t, time.Minute*10, diskPressureInterval(time.Minute*10, 4))
+	require.Equal(t, time.Minute*10, diskPressureInterval(time.Minute*10, 100))
+	require.Equal(t, time.Second*30, diskPressureInterval(time.Second*30, 0))
+}
+
+func TestCleanUpTmpFilesUnderDiskPressure(t *testing.T) {
+	if !diskSpaceSupported {
+		t.Skip("checking free disk space isn't supported on this platform")
+	}
+
+	origCheckInterval, origMinInterval, origFreePercent := diskPressureCheckInterval, diskPressureMinInterval, diskFreePercent
+	var free int64 = 50
+	diskPressureCheckInterval = time.Millisecond * 10
+	diskPressureMinInterval = time.Millisecond * 10
+	diskFreePercent = func(string) (float64, error) {
+		return float64(atomic.LoadInt64(&free)), nil
+	}
+	defer func() {
+		diskPressureCheckInterval, diskPressureMinInterval, diskFreePercent = origCheckInterval, origMinInterval, origFreePercent
+	}()
+
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		CleanupInterval:      time.Minute * 10,
+		ImagesDir:            imagesDir,
+		TempDataLifetime:     time.Hour,
+		TempDataMinFreeSpace: 10,
+		CleanupTempFiles:     setting.CleanupTaskSettings{Enabled: true, Interval: time.Minute * 10},
+	}}
+	require.NoError(t, service.Init())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- service.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the task runs right away on startup, the next regular run is 10m later
+	require.Eventually(t, func() bool {
+		return !service.Status()[taskTmpFiles].LastRun.IsZero()
+	}, time.Second, time.Millisecond*10)
+
+	oldFile := filepath.Join(imagesDir, "old.png")
+	require.NoError(t, ioutil.WriteFile(oldFile, []byte("old"), 0600))
+	old := time.Now().Add(-time.Hour * 2)
+	require.NoError(t, os.Chtimes(oldFile, old, old))
+
+	time.Sleep(time.Millisecond * 50)
+	_, err = os.Stat(oldFile)
+	require.NoError(t, err, "temp files shouldn't be cleaned up early with enough free space")
+
+	atomic.StoreInt64(&free, 5)
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(oldFile)
+		return os.IsNotExist(err)
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestCleanUpTmpFilesOnAlmostFullDisk(t *testing.T) {
+	if !diskSpaceSupported {
+		t.Skip("checking free disk space isn't supported on this platform")
+	}
+
+	origDiskSpace := diskSpace
+	var available uint64 = 10
+	var spaceErr error
+	diskSpace = func(string) (uint64, uint64, error) {
+		return available, 1000, spaceErr
+	}
+	defer func() {
+		diskSpace = origDiskSpace
+	}()
+
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+	trashDir := filepath.Join(imagesDir, "trash")
+	require.NoError(t, os.Mkdir(trashDir, 0750))
+	trashed := filepath.Join(trashDir, "trashed.png")
+	require.NoError(t, ioutil.WriteFile(trashed, make([]byte, 100), 0600))
+
+	// Files are 100 bytes each and none is expired, file-0.png being the oldest
+	// and file-2.png being in use.
+	var files []string
+	for i := 0; i < 3; i++ {
+		file := filepath.Join(imagesDir, fmt.Sprintf("file-%d.png", i))
+		require.NoError(t, ioutil.WriteFile(file, make([]byte, 100), 0600))
+		modTime := time.Now().Add(-time.Duration(3-i) * time.Hour)
+		if i == 2 {
+			modTime = time.Now()
+		}
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+		files = append(files, file)
+	}
+
+	exists := func(file string) bool {
+		_, err := os.Stat(file)
+		return err == nil
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:                 imagesDir,
+			TempDataLifetime:          time.Hour * 24,
+			TempDataInUseGrace:        time.Hour,
+			TempDataTrashDir:          trashDir,
+			TempDataTrashLifetime:     time.Hour * 24,
+			TempDataCriticalFreeSpace: 10,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	// nothing is deleted when the free space can't be checked
+	spaceErr = errors.New("no space left on device")
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	require.True(t, exists(trashed))
+
+	// 90 bytes are missing to the critical free space, the trash is emptied
+	// and the oldest file deleted rather than trashed
+	spaceErr = nil
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+	require.False(t, exists(trashed))
+	require.False(t, exists(files[0]))
+	require.True(t, exists(files[1]))
+	require.True(t, exists(files[2]))
+	entries, err := ioutil.ReadDir(trashDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// even files in use are deleted until enough space is free
+	service.Cfg.TempDataCriticalFreeSpace = 50
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+	require.False(t, exists(files[1]))
+	require.False(t, exists(files[2]))
+}
+
+func TestDeletionThreshold(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10, CleanupDeletionThreshold: 10}}
+	require.NoError(t, service.Init())
+
+	var published []events.CleanupDeletionThresholdExceeded
+	bus.AddEventListener(func(event *events.CleanupDeletionThresholdExceeded) error {
+		if strings.HasPrefix(event.Task, "threshold_") {
+			published = append(published, *event)
+		}
+		return nil
+	})
+
+	for name, deleted := range map[string]int64{"threshold_below": 9, "threshold_at": 10, "threshold_above": 11} {
+		deleted := deleted
+		require.NoError(t, service.RegisterTask(&cleanupTask{
+			name: name,
+			run: func(ctx context.Context) (int64, error) {
+				return deleted, nil
+			},
+		}))
+	}
+
+	service.RunOnce(context.Background())
+	require.Len(t, published, 1)
+	require.Equal(t, "threshold_above", published[0].Task)
+	require.Equal(t, int64(11), published[0].Deleted)
+	require.Equal(t, int64(10), published[0].Threshold)
+}
+
+type levelLogger struct {
+	log.Logger
+	debug, info, warn []string
+}
+
+func (l *levelLogger) Debug(msg string, ctx ...interface{}) { l.debug = append(l.debug, msg) }
+func (l *levelLogger) Info(msg string, ctx ...interface{})  { l.info = append(l.info, msg) }
+func (l *levelLogger) Warn(msg string, ctx ...interface{})  { l.warn = append(l.warn, msg) }
+
+func TestLogLevel(t *testing.T) {
+	sqlstore.InitTestDB(t)
+
+	for level, expected := range map[string][]string{"": nil, "debug": nil, "info": {"Deleted expired login attempts"}} {
+		service := &CleanUpService{Cfg: &setting.Cfg{CleanupLogLevel: level, LoginAttemptsRetention: time.Hour}}
+		require.NoError(t, service.Init())
+		logger := &levelLogger{}
+		service.log = logger
+
+		_, err := service.deleteOldLoginAttempts(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, expected, logger.info, "level: %q", level)
+		if expected == nil {
+			require.Equal(t, []string{"Deleted expired login attempts"}, logger.debug, "level: %q", level)
+		}
+	}
+}
+
+func TestVerifyCounts(t *testing.T) {
+	sqlstore.InitTestDB(t)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, bus.Dispatch(&models.CreateLoginAttemptCommand{Username: fmt.Sprintf("user%d", i), IpAddress: "127.0.0.1"}))
+	}
+
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupVerifyCounts: true}}
+	require.NoError(t, service.Init())
+	logger := &levelLogger{}
+	service.log = logger
+
+	// reports the rows it deleted plus reported
+	var reported int64
+	task := &cleanupTask{
+		name: taskOldLoginAttempts,
+		run: func(ctx context.Context) (int64, error) {
+			cmd := models.DeleteOldLoginAttemptsCommand{OlderThan: time.Now().Add(time.Hour)}
+			if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+				return 0, err
+			}
+			return cmd.DeletedRows + reported, nil
+		},
+	}
+
+	summary := service.executeTask(context.Background(), task)
+	require.Equal(t, int64(3), summary.Deleted)
+	require.Contains(t, logger.debug, "Verified deleted rows")
+	require.Empty(t, logger.warn)
+
+	reported = 1
+	summary = service.executeTask(context.Background(), task)
+	require.Equal(t, int64(1), summary.Deleted)
+	require.Equal(t, []string{"Row count difference doesn't match the rows the cleanup task deleted"}, logger.warn)
+
+	// off by default
+	logger.debug, logger.warn = nil, nil
+	service.Cfg.CleanupVerifyCounts = false
+	service.executeTask(context.Background(), task)
+	require.NotContains(t, logger.debug, "Verified deleted rows")
+	require.Empty(t, logger.warn)
+}
+
+func TestAuditDeletion(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10, LoginAttemptsRetention: time.Hour}}
+	require.NoError(t, service.Init())
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	service.clock = func() time.Time { return now }
+
+	var published []events.CleanupTaskDeleted
+	bus.AddEventListener(func(event *events.CleanupTaskDeleted) error {
+		if strings.HasPrefix(event.Task, "audit_") {
+			published = append(published, *event)
+		}
+		return nil
+	})
+
+	for name, deleted := range map[string]int64{"audit_nothing": 0, "audit_deleted": 3} {
+		deleted := deleted
+		require.NoError(t, service.RegisterTask(&cleanupTask{
+			name: name,
+			run: func(ctx context.Context) (int64, error) {
+				return deleted, nil
+			},
+		}))
+	}
+
+	service.RunOnce(context.Background())
+	require.Len(t, published, 1)
+	require.Equal(t, "audit_deleted", published[0].Task)
+	require.Equal(t, int64(3), published[0].Deleted)
+	require.Equal(t, setting.InstanceName, published[0].Instance)
+	// tasks without a server lock run on every node
+	require.Empty(t, published[0].Lock)
+	require.Equal(t, now, published[0].Timestamp)
+
+	require.Equal(t, "created before 2020-06-01T11:00:00Z", service.taskPredicate(taskOldLoginAttempts, now))
+
+	published = nil
+	service.Cfg.CleanupDryRun = true
+	service.RunOnce(context.Background())
+	require.Empty(t, published)
+}
+
+func TestMaxRowsPerCycle(t *testing.T) {
+	sqlstore.InitTestDB(t)
+
+	// every invite but the latest one is a duplicate
+	for i := 0; i < 4; i++ {
+		cmd := models.CreateTempUserCommand{OrgId: 1, Email: "invited@example.com", Code: util.GenerateShortUID(), Status: models.TmpUserInvitePending}
+		require.NoError(t, bus.Dispatch(&cmd))
+	}
+
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupMaxRowsPerCycle: 2}}
+	require.NoError(t, service.Init())
+	require.NoError(t, service.RegisterTask(&cleanupTask{name: "capped_invites", run: service.deleteDuplicateUserInvites}))
+
+	result, err := service.RunTask(context.Background(), "capped_invites")
+	require.NoError(t, err)
+	require.NoError(t, result.Err)
+	require.Equal(t, int64(2), result.Deleted)
+
+	// the next cycle deletes the rest
+	result, err = service.RunTask(context.Background(), "capped_invites")
+	require.NoError(t, err)
+	require.NoError(t, result.Err)
+	require.Equal(t, int64(1), result.Deleted)
+
+	query := models.GetTempUsersQuery{OrgId: 1, Status: models.TmpUserInvitePending}
+	require.NoError(t, bus.Dispatch(&query))
+	require.Len(t, query.Result, 1)
+}
+
+func TestPauseSkipsScheduledTasks(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+
+	var runs int32
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name:     "pausable",
+		interval: time.Millisecond * 20,
+		run: func(ctx context.Context) (int64, error) {
+			atomic.AddInt32(&runs, 1)
+			return 1, nil
+		},
+	}))
+
+	require.True(t, service.Pause())
+	require.False(t, service.Pause())
+	require.True(t, service.Paused())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+	cancel()
+	require.Equal(t, int32(0), atomic.LoadInt32(&runs))
+
+	// on demand runs aren't paused
+	service.RunOnce(context.Background())
+	require.Equal(t, int32(1), atomic.LoadInt32(&runs))
+
+	require.True(t, service.Resume())
+	require.False(t, service.Resume())
+	require.False(t, service.Paused())
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+	cancel()
+	require.Greater(t, atomic.LoadInt32(&runs), int32(1))
+}
+
+func TestIsTaskActive(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name:     "active",
+		interval: time.Hour,
+		run: func(ctx context.Context) (int64, error) {
+			return 0, nil
+		},
+	}))
+
+	active := func(task string) bool {
+		query := models.IsCleanupTaskActiveQuery{Task: task}
+		require.NoError(t, bus.Dispatch(&query))
+		return query.Result
+	}
+
+	// not scheduled yet
+	require.False(t, active("active"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- service.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.Eventually(t, func() bool { return active("active") }, time.Second, time.Millisecond*10)
+	require.False(t, active("unknown"))
+
+	service.Pause()
+	require.False(t, active("active"))
+	service.Resume()
+	require.True(t, active("active"))
+
+	service.SetMaintenanceCheck(func() bool { return true })
+	require.False(t, active("active"))
+	service.SetMaintenanceCheck(nil)
+
+	service.Cfg.CleanupDryRun = true
+	require.False(t, active("active"))
+	service.Cfg.CleanupDryRun = false
+
+	service.statuses.update("active", time.Now(), newTaskSummary(0, errors.New("failed")))
+	require.False(t, active("active"))
+	service.statuses.update("active", time.Now(), newTaskSummary(0, nil))
+	require.True(t, active("active"))
+}
+
+func TestStartupDelay(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10, CleanupStartupDelay: time.Millisecond * 50}}
+	require.NoError(t, service.Init())
+
+	var runs int32
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name:     "delayed",
+		interval: time.Hour,
+		run: func(ctx context.Context) (int64, error) {
+			atomic.AddInt32(&runs, 1)
+			return 0, nil
+		},
+	}))
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		delay := service.firstCycleDelay(rnd)
+		require.GreaterOrEqual(t, int64(delay), int64(time.Millisecond*50))
+		require.Less(t, int64(delay), int64(time.Millisecond*100))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*40)
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+	cancel()
+	require.Equal(t, int32(0), atomic.LoadInt32(&runs))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*200)
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+	cancel()
+	require.Equal(t, int32(1), atomic.LoadInt32(&runs))
+
+	service.Cfg.CleanupStartupDelay = 0
+	require.Zero(t, service.firstCycleDelay(rnd))
+}
+
+func TestMaintenanceSkipsScheduledTasks(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+
+	var runs int32
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name:     "maintainable",
+		interval: time.Millisecond * 20,
+		run: func(ctx context.Context) (int64, error) {
+			atomic.AddInt32(&runs, 1)
+			return 1, nil
+		},
+	}))
+
+	var maintenance int32 = 1
+	service.SetMaintenanceCheck(func() bool {
+		return atomic.LoadInt32(&maintenance) == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+	cancel()
+	require.Equal(t, int32(0), atomic.LoadInt32(&runs))
+
+	// on demand runs don't check for maintenance
+	service.RunOnce(context.Background())
+	require.Equal(t, int32(1), atomic.LoadInt32(&runs))
+
+	atomic.StoreInt32(&maintenance, 0)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+	cancel()
+	require.Greater(t, atomic.LoadInt32(&runs), int32(1))
+
+	t.Run("A panicking check counts as no maintenance", func(t *testing.T) {
+		service.SetMaintenanceCheck(func() bool { panic("check failed") })
+		require.False(t, service.checkMaintenance(true))
+
+		service.SetMaintenanceCheck(nil)
+		require.False(t, service.checkMaintenance(false))
+	})
+}
+
+func TestMaintenanceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	maintenanceFile := filepath.Join(dir, "maintenance")
+
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10, CleanupMaintenanceFile: maintenanceFile}}
+	require.NoError(t, service.Init())
+
+	var runs int32
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name:     "maintainable",
+		interval: time.Millisecond * 20,
+		run: func(ctx context.Context) (int64, error) {
+			atomic.AddInt32(&runs, 1)
+			return 1, nil
+		},
+	}))
+
+	require.False(t, service.checkMaintenance(false))
+
+	require.NoError(t, ioutil.WriteFile(maintenanceFile, nil, 0600))
+	require.True(t, service.checkMaintenance(false))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+	cancel()
+	require.Equal(t, int32(0), atomic.LoadInt32(&runs))
+
+	require.NoError(t, os.Remove(maintenanceFile))
+	require.False(t, service.checkMaintenance(true))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	require.Equal(t, context.DeadlineExceeded, service.Run(ctx))
+	cancel()
+	require.Greater(t, atomic.LoadInt32(&runs), int32(0))
+}
+
+func TestCleanUpUploadedFiles(t *testing.T) {
+	sqlstore.InitTestDB(t)
+	uploadsDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(uploadsDir)
+	})
+
+	require.NoError(t, os.Mkdir(filepath.Join(uploadsDir, "csv"), 0700))
+	files := map[string]time.Time{
+		"csv/recent.csv":    time.Now().Add(-time.Hour),
+		"csv/sales.csv":     time.Now().Add(-time.Hour * 48),
+		"csv/orphaned.csv":  time.Now().Add(-time.Hour * 48),
+		"logo.png":          time.Now().Add(-time.Hour * 48),
+		"orphaned-logo.png": time.Now().Add(-time.Hour * 48),
+	}
+	for name, mtime := range files {
+		file := filepath.Join(uploadsDir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte("upload"), 0600))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+
+	require.NoError(t, bus.Dispatch(&models.SaveDashboardCommand{
+		OrgId: 1,
+		Dashboard: simplejson.NewFromAny(map[string]interface{}{
+			"title":  "Uploads",
+			"panels": []interface{}{map[string]interface{}{"type": "text", "content": "![logo](/public/uploads/logo.png)"}},
+		}),
+	}))
+	require.NoError(t, bus.Dispatch(&models.AddDataSourceCommand{
+		OrgId:    1,
+		Name:     "sales",
+		Type:     "csv",
+		Access:   models.DS_ACCESS_PROXY,
+		JsonData: simplejson.NewFromAny(map[string]interface{}{"path": "csv/sales.csv"}),
+	}))
+
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		UploadsDir:         uploadsDir,
+		UploadDataLifetime: time.Hour * 24,
+	}}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpUploadedFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+	for name, exists := range map[string]bool{
+		"csv/recent.csv":    true,
+		"csv/sales.csv":     true,
+		"csv/orphaned.csv":  false,
+		"logo.png":          true,
+		"orphaned-logo.png": false,
+	} {
+		_, err := os.Stat(filepath.Join(uploadsDir, name))
+		require.Equal(t, exists, err == nil, name)
+	}
+}
+
+func TestCleanUpExportFiles(t *testing.T) {
+	exportsDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	trashDir, err := ioutil.TempDir("", "cleanup-test-trash")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(exportsDir)
+		_ = os.RemoveAll(trashDir)
+	})
+
+	files := map[string]time.Time{
+		"recent.csv": time.Now().Add(-time.Hour),
+		"old.csv":    time.Now().Add(-time.Hour * 2),
+		"old.xlsx":   time.Now().Add(-time.Hour * 48),
+	}
+	for name, mtime := range files {
+		file := filepath.Join(exportsDir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte("export"), 0600))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		ExportsDir:         exportsDir,
+		ExportDataLifetime: time.Hour * 24,
+		TempDataTrashDir:   trashDir,
+	}}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpExportFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	for name, exists := range map[string]bool{"recent.csv": true, "old.csv": true, "old.xlsx": false} {
+		_, err := os.Stat(filepath.Join(exportsDir, name))
+		require.Equal(t, exists, err == nil, name)
+	}
+
+	// exports aren't moved to the trash
+	trashed, err := ioutil.ReadDir(trashDir)
+	require.NoError(t, err)
+	require.Empty(t, trashed)
+
+	service.Cfg.ExportDataLifetime = 0
+	service.Cfg.ExportDataMaxSize = int64(len("export"))
+	deleted, err = service.cleanUpExportFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	_, err = os.Stat(filepath.Join(exportsDir, "old.csv"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(exportsDir, "recent.csv"))
+	require.NoError(t, err)
+}
+
+func TestCleanUpExportFilesMissingDir(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		ExportsDir:         filepath.Join(os.TempDir(), "cleanup-test-missing-exports"),
+		ExportDataLifetime: time.Hour,
+	}}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpExportFiles(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+}
+
+func TestDeleteStaleDashboardProvisioning(t *testing.T) {
+	sqlstore.InitTestDB(t)
+
+	for _, name := range []string{"configured", "removed"} {
+		require.NoError(t, bus.Dispatch(&models.SaveProvisionedDashboardCommand{
+			DashboardCmd: &models.SaveDashboardCommand{
+				OrgId:     1,
+				Dashboard: simplejson.NewFromAny(map[string]interface{}{"title": name}),
+			},
+			DashboardProvisioning: &models.DashboardProvisioning{Name: name, ExternalId: name + ".json"},
+		}))
+	}
+
+	provisioningService := provisioning.NewProvisioningServiceMock()
+	provisioningService.GetDashboardProvisionerResolvedPathFunc = func(name string) string {
+		if name == "configured" {
+			return "/etc/grafana/dashboards"
+		}
+		return ""
+	}
+	service := &CleanUpService{
+		Cfg:                 &setting.Cfg{},
+		ProvisioningService: provisioningService,
+	}
+	require.NoError(t, service.Init())
+
+	// a removed provisioner may only be disabled for now, it's kept by default
+	deleted, err := service.deleteStaleDashboardProvisioning(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	require.Empty(t, provisioningService.Calls.GetDashboardProvisionerResolvedPath)
+
+	service.Cfg.CleanupUnknownProvisioners = true
+	deleted, err = service.deleteStaleDashboardProvisioning(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	query := models.GetDashboardProvisionerNamesQuery{}
+	require.NoError(t, bus.DispatchCtx(context.Background(), &query))
+	require.Equal(t, []string{"configured"}, query.Result)
+}
+
+func TestSubscribe(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+
+	taskErr := errors.New("database is locked")
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "succeeding",
+		run: func(ctx context.Context) (int64, error) {
+			return 3, nil
+		},
+	}))
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "failing",
+		run: func(ctx context.Context) (int64, error) {
+			return 0, taskErr
+		},
+	}))
+
+	var events []CleanupEvent
+	service.Subscribe(func(event CleanupEvent) {
+		panic("broken subscriber")
+	})
+	service.Subscribe(func(event CleanupEvent) {
+		events = append(events, event)
+	})
+
+	before := time.Now()
+	result, err := service.RunTask(context.Background(), "succeeding")
+	require.NoError(t, err)
+	require.NoError(t, result.Err)
+	_, err = service.RunTask(context.Background(), "failing")
+	require.NoError(t, err)
+
+	// the panicking subscriber doesn't keep the others from being called
+	require.Len(t, events, 2)
+	require.Equal(t, "succeeding", events[0].Task)
+	require.Equal(t, int64(3), events[0].Deleted)
+	require.NoError(t, events[0].Err)
+	require.False(t, events[0].StartedAt.Before(before))
+	require.Equal(t, "failing", events[1].Task)
+	require.True(t, errors.Is(events[1].Err, taskErr))
+}
+
+func TestCleanUpTmpFilesFrozenClock(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]time.Time{
+		"expired.png":  now.Add(-time.Hour * 25),
+		"expiring.png": now.Add(-time.Hour * 23),
+		"recent.png":   now.Add(-time.Minute),
+	}
+	for name, mtime := range files {
+		file := filepath.Join(imagesDir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:        imagesDir,
+			TempDataLifetime: time.Hour * 24,
+		},
+		clock: func() time.Time { return now },
+	}
+	require.NoError(t, service.Init())
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(imagesDir, name))
+		return err == nil
+	}
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	require.False(t, exists("expired.png"))
+	require.True(t, exists("expiring.png"))
+
+	// nothing else expires while the clock stands still
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	now = now.Add(time.Hour * 2)
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	require.False(t, exists("expiring.png"))
+	require.True(t, exists("recent.png"))
+
+	// the size limit removes the oldest of the remaining files regardless of the clock
+	require.NoError(t, ioutil.WriteFile(filepath.Join(imagesDir, "new.png"), []byte("png"), 0600))
+	require.NoError(t, os.Chtimes(filepath.Join(imagesDir, "new.png"), now, now))
+	service.Cfg.TempDataMaxSize = int64(len("png"))
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	require.False(t, exists("recent.png"))
+	require.True(t, exists("new.png"))
+}
+
+func TestCleanUpTmpFilesInUseGrace(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	now := time.Now()
+	files := map[string]time.Time{
+		"just-rendered.png": now.Add(-time.Second * 10),
+		"old.png":           now.Add(-time.Minute * 5),
+	}
+	for name, mtime := range files {
+		file := filepath.Join(imagesDir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:          imagesDir,
+			TempDataLifetime:   time.Second,
+			TempDataInUseGrace: time.Minute,
+		},
+		clock: func() time.Time { return now },
+	}
+	require.NoError(t, service.Init())
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	_, err = os.Stat(filepath.Join(imagesDir, "just-rendered.png"))
+	require.NoError(t, err)
+
+	// other storages only have the modification time the age is counted from
+	require.True(t, service.shouldCleanupTempFile(TempFile{Path: "remote.png"}, now.Add(-time.Second*10), now))
+}
+
+func TestDeepScrub(t *testing.T) {
+	sqlstore.InitTestDB(t)
+
+	// the user and dashboard have been deleted
+	require.NoError(t, bus.Dispatch(&models.StarDashboardCommand{UserId: 1000, DashboardId: 1000}))
+	require.NoError(t, bus.Dispatch(&models.SavePreferencesCommand{UserId: 1000, OrgId: 1, Theme: "dark"}))
+	require.NoError(t, bus.Dispatch(&models.SavePreferencesCommand{OrgId: 1, Theme: "light"}))
+
+	service := &CleanUpService{Cfg: &setting.Cfg{
+		CleanupDeepScrub:       setting.CleanupTaskSettings{Enabled: true, Interval: time.Hour * 24},
+		CleanupDeepScrubDryRun: true,
+	}, ServerLockService: &serverlock.ServerLockService{}}
+	require.NoError(t, service.Init())
+	var names []string
+	for _, task := range service.registeredTasks() {
+		names = append(names, task.Name())
+	}
+	require.Equal(t, []string{taskDeepScrub}, names)
+
+	deleted, err := service.deepScrub(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	stars := models.GetUserStarsQuery{UserId: 1000}
+	require.NoError(t, bus.Dispatch(&stars))
+	require.Len(t, stars.Result, 1)
+
+	service.Cfg.CleanupDeepScrubDryRun = false
+	deleted, err = service.deepScrub(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+
+	require.NoError(t, bus.Dispatch(&stars))
+	require.Empty(t, stars.Result)
+
+	// the org preferences are kept
+	prefs := models.GetPreferencesQuery{OrgId: 1}
+	require.NoError(t, bus.Dispatch(&prefs))
+	require.Equal(t, "light", prefs.Result.Theme)
+}
+
+func TestRunOnSignal(t *testing.T) {
+	service := &CleanUpService{Cfg: &setting.Cfg{CleanupInterval: time.Minute * 10}}
+	require.NoError(t, service.Init())
+	var runs int
+	require.NoError(t, service.RegisterTask(&cleanupTask{
+		name: "signalled",
+		run: func(ctx context.Context) (int64, error) {
+			runs++
+			return 1, nil
+		},
+	}))
+
+	service.RunOnSignal(context.Background(), os.Interrupt)
+	require.Zero(t, runs)
+
+	service.Cfg.CleanupRunOnSignal = true
+	service.RunOnSignal(context.Background(), os.Interrupt)
+	require.Equal(t, 1, runs)
+
+	// a signal received while a signal triggered cleanup runs is ignored
+	service.signalRunning = 1
+	service.RunOnSignal(context.Background(), os.Interrupt)
+	require.Equal(t, 1, runs)
+}
+
+func TestAgeRetentionPolicy(t *testing.T) {
+	now := time.Now()
+	old := RetentionCandidate{ID: "old", Time: now.Add(-time.Hour * 2)}
+	recent := RetentionCandidate{ID: "recent", Time: now.Add(-time.Minute)}
+
+	require.Equal(t, []RetentionCandidate{old}, AgeRetentionPolicy(time.Hour)([]RetentionCandidate{old, recent}, now))
+	require.Empty(t, AgeRetentionPolicy(0)([]RetentionCandidate{old, recent}, now))
+}
+
+func TestSetRetentionPolicy(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
 	})
+
+	// two files per day for the last four days, none older than the lifetime
+	now := time.Now()
+	var files []string
+	for i := 0; i < 8; i++ {
+		file := filepath.Join(imagesDir, fmt.Sprintf("file-%d.png", i))
+		require.NoError(t, ioutil.WriteFile(file, []byte("file"), 0600))
+		modTime := now.Add(-time.Duration(i/2) * time.Hour * 24).Add(-time.Duration(i%2) * time.Hour)
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+		files = append(files, file)
+	}
+
+	exists := func(file string) bool {
+		_, err := os.Stat(file)
+		return err == nil
+	}
+
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{
+			ImagesDir:        imagesDir,
+			TempDataLifetime: time.Hour * 24 * 30,
+		},
+	}
+	require.NoError(t, service.Init())
+
+	require.Error(t, service.SetRetentionPolicy(taskExpiredSnapshots, AgeRetentionPolicy(time.Hour)))
+
+	// keeps everything from today and the newest file of every older day
+	daily := func(candidates []RetentionCandidate, now time.Time) []RetentionCandidate {
+		newest := map[int]RetentionCandidate{}
+		for _, candidate := range candidates {
+			day := int(now.Sub(candidate.Time) / (time.Hour * 24))
+			if n, ok := newest[day]; !ok || candidate.Time.After(n.Time) {
+				newest[day] = candidate
+			}
+		}
+
+		var toDelete []RetentionCandidate
+		for _, candidate := range candidates {
+			day := int(now.Sub(candidate.Time) / (time.Hour * 24))
+			if day > 0 && newest[day].ID != candidate.ID {
+				toDelete = append(toDelete, candidate)
+			}
+		}
+		return toDelete
+	}
+	require.NoError(t, service.SetRetentionPolicy(taskTmpFiles, daily))
+
+	deleted, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(3), deleted)
+	for i, file := range files {
+		require.Equal(t, i < 2 || i%2 == 0, exists(file), file)
+	}
+
+	// without a policy the lifetime applies again
+	require.NoError(t, service.SetRetentionPolicy(taskTmpFiles, nil))
+	service.Cfg.TempDataLifetime = time.Hour * 24 * 2
+	deleted, err = service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+	require.False(t, exists(files[4]))
+	require.False(t, exists(files[6]))
 }
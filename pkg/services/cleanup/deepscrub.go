@@ -0,0 +1,68 @@
+package cleanup
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// deepScrubCheck is one of the orphan checks run by the deep_scrub task.
+type deepScrubCheck struct {
+	table   string
+	cmd     bus.Msg
+	deleted *int64
+}
+
+// deepScrub runs the orphan checks of all related tables one after the other,
+// behind a single server lock, and logs how many rows each of them removed.
+// With Cfg.CleanupDeepScrubDryRun it only counts them.
+func (srv *CleanUpService) deepScrub(ctx context.Context) (int64, error) {
+	dryRun := srv.Cfg.CleanupDryRun || srv.Cfg.CleanupDeepScrubDryRun
+
+	annotations := models.CleanupAnnotationsCommand{BatchSize: srv.Cfg.AnnotationCleanupJobBatchSize, DryRun: dryRun}
+	acl := models.DeleteOrphanedDashboardAclCommand{DryRun: dryRun}
+	stars := models.DeleteOrphanedStarsCommand{DryRun: dryRun}
+	preferences := models.DeleteOrphanedPreferencesCommand{DryRun: dryRun}
+	provisioning := models.DeleteStaleDashboardProvisioningCommand{DryRun: dryRun}
+	if srv.Cfg.CleanupUnknownProvisioners {
+		unknown, err := srv.unknownDashboardProvisioners(ctx)
+		if err != nil {
+			srv.log.Error("Problem finding unknown dashboard provisioners", "error", err.Error())
+			return 0, err
+		}
+		provisioning.UnknownProvisioners = unknown
+	}
+
+	checks := []deepScrubCheck{
+		{table: "annotation", cmd: &annotations, deleted: &annotations.DeletedRows},
+		{table: "dashboard_acl", cmd: &acl, deleted: &acl.DeletedRows},
+		{table: "star", cmd: &stars, deleted: &stars.DeletedRows},
+		{table: "preferences", cmd: &preferences, deleted: &preferences.DeletedRows},
+		{table: "dashboard_provisioning", cmd: &provisioning, deleted: &provisioning.DeletedRows},
+	}
+
+	var total int64
+	breakdown := make([]interface{}, 0, len(checks)*2)
+	for _, check := range checks {
+		if err := bus.DispatchCtx(ctx, check.cmd); err != nil {
+			srv.log.Error("Problem scrubbing orphaned rows", "table", check.table, "error", err.Error())
+			if dryRun {
+				return 0, err
+			}
+			return total, err
+		}
+
+		total += *check.deleted
+		breakdown = append(breakdown, check.table, *check.deleted)
+	}
+
+	if dryRun {
+		srv.log.Info("[Dry run] Would delete orphaned rows", append([]interface{}{"rows", total}, breakdown...)...)
+		return 0, nil
+	}
+
+	srv.log.Info("Deep scrub deleted orphaned rows", append([]interface{}{"rows affected", total}, breakdown...)...)
+
+	return total, nil
+}
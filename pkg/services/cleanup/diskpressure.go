@@ -0,0 +1,99 @@
+package cleanup
+
+import (
+	"time"
+)
+
+var (
+	// diskPressureCheckInterval is how often the free space of Cfg.ImagesDir is
+	// checked when Cfg.TempDataMinFreeSpace is set.
+	diskPressureCheckInterval = time.Minute
+	// diskPressureMinInterval is the interval of the tmp_files task right after
+	// the free space dropped below Cfg.TempDataMinFreeSpace.
+	diskPressureMinInterval = time.Minute
+
+	diskFreePercent = freeSpacePercent
+	diskSpace       = volumeSpace
+)
+
+// freeSpacePercent returns the share of the volume holding dir that is
+// available to unprivileged users, in percent.
+func freeSpacePercent(dir string) (float64, error) {
+	available, total, err := diskSpace(dir)
+	if err != nil {
+		return 0, err
+	}
+	if total == 0 {
+		return 100, nil
+	}
+
+	return float64(available) / float64(total) * 100, nil
+}
+
+// spaceToFree returns how many bytes the tmp_files task has to free to bring
+// the free space of Cfg.ImagesDir back up to Cfg.TempDataCriticalFreeSpace, or
+// 0 if there is enough free space. A free space that can't be determined, e.g.
+// because the volume is so full that even that fails, counts as enough, so
+// the task still cleans up as usual.
+func (srv *CleanUpService) spaceToFree() int64 {
+	if srv.Cfg.TempDataCriticalFreeSpace <= 0 || !diskSpaceSupported {
+		return 0
+	}
+	if _, ok := srv.tempStorage.(*localTempStorage); !ok {
+		return 0
+	}
+
+	available, total, err := diskSpace(srv.Cfg.ImagesDir)
+	if err != nil {
+		srv.log.Debug("Failed to check free space of temp data directory", "dir", srv.Cfg.ImagesDir, "error", err)
+		return 0
+	}
+
+	target := total / 100 * uint64(srv.Cfg.TempDataCriticalFreeSpace)
+	if available >= target {
+		return 0
+	}
+
+	needed := int64(target - available)
+	srv.log.Warn("Temp data directory is almost full, deleting the oldest temp files regardless of their lifetime",
+		"dir", srv.Cfg.ImagesDir, "free bytes", available, "critical free percent", srv.Cfg.TempDataCriticalFreeSpace, "bytes to free", needed)
+	return needed
+}
+
+// checkDiskPressure reports whether the free space of Cfg.ImagesDir is below
+// Cfg.TempDataMinFreeSpace, logging when that changes since the last check.
+// A free space that can't be determined counts as no pressure.
+func (srv *CleanUpService) checkDiskPressure(underPressure bool) bool {
+	free, err := diskFreePercent(srv.Cfg.ImagesDir)
+	if err != nil {
+		srv.log.Debug("Failed to check free space of temp data directory", "dir", srv.Cfg.ImagesDir, "error", err)
+		return false
+	}
+
+	pressure := free < float64(srv.Cfg.TempDataMinFreeSpace)
+	switch {
+	case pressure && !underPressure:
+		srv.log.Warn("Temp data directory is running out of space, cleaning up temp files right away and more often",
+			"dir", srv.Cfg.ImagesDir, "free percent", int(free), "min free percent", srv.Cfg.TempDataMinFreeSpace)
+	case !pressure && underPressure:
+		srv.log.Info("Temp data directory has enough free space again, cleaning up temp files at the regular interval",
+			"dir", srv.Cfg.ImagesDir, "free percent", int(free))
+	}
+
+	return pressure
+}
+
+// diskPressureInterval returns the interval of the tmp_files task after runs
+// cleanups under disk pressure. It starts at diskPressureMinInterval and
+// doubles per run up to the regular interval, so a volume that is filled up by
+// something else isn't scanned once a minute for good.
+func diskPressureInterval(interval time.Duration, runs int) time.Duration {
+	for d := diskPressureMinInterval; d < interval; d *= 2 {
+		if runs == 0 {
+			return d
+		}
+		runs--
+	}
+
+	return interval
+}
@@ -0,0 +1,14 @@
+// +build !linux,!darwin
+
+package cleanup
+
+import (
+	"errors"
+)
+
+const diskSpaceSupported = false
+
+// volumeSpace isn't available on this platform.
+func volumeSpace(dir string) (uint64, uint64, error) {
+	return 0, 0, errors.New("checking free disk space isn't supported on this platform")
+}
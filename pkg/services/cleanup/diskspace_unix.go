@@ -0,0 +1,21 @@
+// +build linux darwin
+
+package cleanup
+
+import (
+	"syscall"
+)
+
+const diskSpaceSupported = true
+
+// volumeSpace returns the bytes of the volume holding dir that are available
+// to unprivileged users, and its total size in bytes.
+func volumeSpace(dir string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	blockSize := uint64(stat.Bsize)
+	return stat.Bavail * blockSize, stat.Blocks * blockSize, nil
+}
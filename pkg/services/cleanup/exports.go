@@ -0,0 +1,27 @@
+package cleanup
+
+import (
+	"context"
+	"time"
+)
+
+// cleanUpExportFiles deletes the files in Cfg.ExportsDir that are older than
+// Cfg.ExportDataLifetime, so large exports don't wait for the lifetime of the
+// rendered images.
+func (srv *CleanUpService) cleanUpExportFiles(ctx context.Context) (int64, error) {
+	return srv.pruneFiles(ctx, filePruning{
+		task:      taskExportFiles,
+		storage:   &localTempStorage{srv: srv, dir: srv.Cfg.ExportsDir},
+		expired:   srv.shouldCleanupExportFile,
+		retention: srv.retentionPolicy(taskExportFiles),
+		maxSize:   srv.Cfg.ExportDataMaxSize,
+	}, srv.clock())
+}
+
+func (srv *CleanUpService) shouldCleanupExportFile(file TempFile, age time.Time, now time.Time) bool {
+	if srv.Cfg.ExportDataLifetime == 0 {
+		return false
+	}
+
+	return age.Add(srv.Cfg.ExportDataLifetime).Before(now)
+}
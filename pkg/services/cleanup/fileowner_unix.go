@@ -0,0 +1,18 @@
+// +build linux darwin
+
+package cleanup
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the id of the user owning the file.
+func fileOwner(info os.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return int(stat.Uid), true
+}
@@ -0,0 +1,33 @@
+// +build darwin
+
+package cleanup
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+const fileTimesSupported = true
+
+// changeTime returns the time the file's inode last changed, which is also
+// updated when a file is restored with an old modification time.
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(stat.Ctimespec.Sec, stat.Ctimespec.Nsec), true
+}
+
+// accessTime returns the time the file was last read. It isn't updated on
+// noatime mounts.
+func accessTime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(stat.Atimespec.Sec, stat.Atimespec.Nsec), true
+}
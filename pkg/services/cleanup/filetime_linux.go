@@ -0,0 +1,34 @@
+// +build linux
+
+package cleanup
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+const fileTimesSupported = true
+
+// changeTime returns the time the file's inode last changed, which is also
+// updated when a file is restored with an old modification time.
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec)), true
+}
+
+// accessTime returns the time the file was last read. It isn't updated on
+// noatime mounts, and on relatime mounts only by the first read after a
+// modification and at most once a day after that.
+func accessTime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec)), true
+}
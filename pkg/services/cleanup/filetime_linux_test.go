@@ -0,0 +1,163 @@
+// +build linux
+
+package cleanup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanUpTmpFilesUseChangeTime(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	// a file restored from a backup keeps its old modification time, but its
+	// inode changes when it's written
+	restored := filepath.Join(imagesDir, "restored.png")
+	twoDaysAgo := time.Now().Add(-time.Hour * 48)
+	require.NoError(t, ioutil.WriteFile(restored, []byte("png"), 0600))
+	require.NoError(t, os.Chtimes(restored, twoDaysAgo, twoDaysAgo))
+
+	for _, useChangeTime := range []bool{true, false} {
+		service := &CleanUpService{
+			Cfg: &setting.Cfg{
+				ImagesDir:             imagesDir,
+				TempDataLifetime:      time.Hour * 24,
+				TempDataUseChangeTime: useChangeTime,
+			},
+		}
+		require.NoError(t, service.Init())
+
+		deleted, err := service.cleanUpTmpFiles(context.Background())
+		require.NoError(t, err)
+		if useChangeTime {
+			require.Equal(t, int64(0), deleted)
+		} else {
+			require.Equal(t, int64(1), deleted)
+		}
+	}
+}
+
+func TestCleanUpTmpFilesTimeSource(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	// modified two days ago, read an hour ago and its inode changed just now
+	now := time.Now()
+	file := filepath.Join(imagesDir, "file.png")
+	for source, deleted := range map[string]int64{"": 1, "mtime": 1, "atime": 0, "ctime": 0} {
+		require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+		require.NoError(t, os.Chtimes(file, now.Add(-time.Hour), now.Add(-time.Hour*48)))
+
+		service := &CleanUpService{
+			Cfg: &setting.Cfg{
+				ImagesDir:          imagesDir,
+				TempDataLifetime:   time.Hour * 24,
+				TempDataTimeSource: source,
+			},
+		}
+		require.NoError(t, service.Init())
+
+		n, err := service.cleanUpTmpFiles(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, deleted, n, "source: %q", source)
+	}
+
+	// the access time is older than the lifetime
+	require.NoError(t, ioutil.WriteFile(file, []byte("png"), 0600))
+	require.NoError(t, os.Chtimes(file, now.Add(-time.Hour*48), now))
+	service := &CleanUpService{
+		Cfg: &setting.Cfg{ImagesDir: imagesDir, TempDataLifetime: time.Hour * 24, TempDataTimeSource: "atime"},
+	}
+	require.NoError(t, service.Init())
+	n, err := service.cleanUpTmpFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+}
+
+func TestCleanUpTmpFilesInUseGraceAccessTime(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	// an image rendered a while ago that a browser just requested
+	now := time.Now()
+	served := filepath.Join(imagesDir, "served.png")
+	require.NoError(t, ioutil.WriteFile(served, []byte("png"), 0600))
+	require.NoError(t, os.Chtimes(served, now.Add(-time.Second*10), now.Add(-time.Hour*2)))
+
+	for _, grace := range []time.Duration{time.Minute, 0} {
+		service := &CleanUpService{
+			Cfg: &setting.Cfg{
+				ImagesDir:          imagesDir,
+				TempDataLifetime:   time.Hour,
+				TempDataInUseGrace: grace,
+			},
+			clock: func() time.Time { return now },
+		}
+		require.NoError(t, service.Init())
+
+		deleted, err := service.cleanUpTmpFiles(context.Background())
+		require.NoError(t, err)
+		if grace > 0 {
+			require.Equal(t, int64(0), deleted)
+		} else {
+			require.Equal(t, int64(1), deleted)
+		}
+	}
+}
+
+func TestCleanUpTmpFilesForeignOwner(t *testing.T) {
+	imagesDir, err := ioutil.TempDir("", "cleanup-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(imagesDir)
+	})
+
+	old := filepath.Join(imagesDir, "old.png")
+	twoDaysAgo := time.Now().Add(-time.Hour * 48)
+	require.NoError(t, ioutil.WriteFile(old, []byte("png"), 0600))
+	require.NoError(t, os.Chtimes(old, twoDaysAgo, twoDaysAgo))
+
+	// pretend Grafana runs as another user than the one owning the file
+	defaultUID := effectiveUID
+	t.Cleanup(func() {
+		effectiveUID = defaultUID
+	})
+	effectiveUID = func() int { return os.Geteuid() + 1 }
+
+	for _, skip := range []bool{true, false} {
+		service := &CleanUpService{
+			Cfg: &setting.Cfg{
+				ImagesDir:                imagesDir,
+				TempDataLifetime:         time.Hour * 24,
+				TempDataSkipForeignFiles: skip,
+			},
+		}
+		require.NoError(t, service.Init())
+
+		deleted, err := service.cleanUpTmpFiles(context.Background())
+		require.NoError(t, err)
+		if skip {
+			require.Equal(t, int64(0), deleted)
+			require.Equal(t, map[string]bool{old: true}, service.foreignFiles)
+		} else {
+			require.Equal(t, int64(1), deleted)
+		}
+	}
+}
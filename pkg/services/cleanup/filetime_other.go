@@ -0,0 +1,20 @@
+// +build !linux,!darwin
+
+package cleanup
+
+import (
+	"os"
+	"time"
+)
+
+const fileTimesSupported = false
+
+// changeTime isn't available on this platform, the modification time is used instead.
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// accessTime isn't available on this platform, the modification time is used instead.
+func accessTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
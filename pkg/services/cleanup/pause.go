@@ -0,0 +1,101 @@
+package cleanup
+
+import (
+	"os"
+	"sync/atomic"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+// Pause stops the scheduled cleanup cycles on this instance until Resume is
+// called, e.g. to preserve data during an incident. Task runs already in
+// progress finish, RunOnce and RunTask still run on demand. It returns false if
+// the cleanup was paused already.
+func (srv *CleanUpService) Pause() bool {
+	if !atomic.CompareAndSwapInt32(&srv.paused, 0, 1) {
+		return false
+	}
+
+	srv.log.Warn("Cleanup paused, scheduled cleanup tasks are skipped until it's resumed")
+	return true
+}
+
+// Resume lets the scheduled cleanup cycles run again after Pause. Skipped
+// tasks run at their next scheduled time. It returns false if the cleanup
+// wasn't paused.
+func (srv *CleanUpService) Resume() bool {
+	if !atomic.CompareAndSwapInt32(&srv.paused, 1, 0) {
+		return false
+	}
+
+	srv.log.Info("Cleanup resumed")
+	return true
+}
+
+// Paused reports whether the scheduled cleanup cycles are paused on this instance.
+func (srv *CleanUpService) Paused() bool {
+	return atomic.LoadInt32(&srv.paused) == 1
+}
+
+// SetMaintenanceCheck makes the scheduled cleanup cycles skip the due tasks
+// while inMaintenance returns true, e.g. while a migration runs. Other than
+// Pause, it follows the maintenance state kept by another service instead of
+// a manual toggle. The check is called on the goroutine scheduling the tasks
+// whenever some are due, so it should return quickly. RunOnce and RunTask
+// still run on demand. A nil check removes it. The maintenance_file setting
+// applies in addition to the check.
+func (srv *CleanUpService) SetMaintenanceCheck(inMaintenance func() bool) {
+	srv.maintenanceMtx.Lock()
+	defer srv.maintenanceMtx.Unlock()
+
+	srv.maintenanceCheck = inMaintenance
+}
+
+// checkMaintenance reports whether the maintenance check reports maintenance,
+// logging when that changes since the last check.
+func (srv *CleanUpService) checkMaintenance(inMaintenance bool) bool {
+	maintenance := srv.inMaintenance()
+	switch {
+	case maintenance && !inMaintenance:
+		srv.log.Warn("Maintenance mode is on, scheduled cleanup tasks are skipped until it's off")
+	case !maintenance && inMaintenance:
+		srv.log.Info("Maintenance mode is off, running the scheduled cleanup tasks again")
+	}
+	return maintenance
+}
+
+// inMaintenance reports whether the maintenance file exists or the
+// maintenance check reports maintenance. No check, or a panicking one, counts
+// as no maintenance.
+func (srv *CleanUpService) inMaintenance() (maintenance bool) {
+	if srv.maintenanceFileExists() {
+		return true
+	}
+
+	srv.maintenanceMtx.RLock()
+	check := srv.maintenanceCheck
+	srv.maintenanceMtx.RUnlock()
+	if check == nil {
+		return false
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			srv.log.Error("Cleanup maintenance check panic", "error", r, "stack", log.Stack(1))
+			maintenance = false
+		}
+	}()
+
+	return check()
+}
+
+// maintenanceFileExists reports whether the maintenance_file setting names a
+// file that exists.
+func (srv *CleanUpService) maintenanceFileExists() bool {
+	if srv.Cfg.CleanupMaintenanceFile == "" {
+		return false
+	}
+
+	_, err := os.Stat(srv.Cfg.CleanupMaintenanceFile)
+	return err == nil
+}
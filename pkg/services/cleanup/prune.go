@@ -0,0 +1,260 @@
+package cleanup
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/metrics"
+)
+
+type tempFile struct {
+	TempFile
+	// age is the time the age of the file is counted from, see TempStorage.ModTime.
+	age time.Time
+}
+
+// filePruning configures how pruneFiles cleans up the files of a storage.
+type filePruning struct {
+	// task names the cleanup task in logs and metrics.
+	task    string
+	storage TempStorage
+	// expired reports whether the file outlived its lifetime.
+	expired func(file TempFile, age time.Time, now time.Time) bool
+	// retention replaces expired when it's set, see SetRetentionPolicy.
+	retention RetentionPolicy
+	// excluded files are never deleted, they still count towards maxSize and maxFiles.
+	excluded func(name string) bool
+	// foreign reports whether the file belongs to another user. Those files, and
+	// files that can't be deleted for lack of permission, are skipped and only
+	// logged the first time, see Cfg.TempDataSkipForeignFiles.
+	foreign func(file TempFile) bool
+	// maxSize is the total size in bytes above which the oldest files are
+	// deleted regardless of their age, 0 means no limit.
+	maxSize int64
+	// maxFiles is the number of files above which the oldest files are deleted
+	// regardless of their age, 0 means no limit.
+	maxFiles int
+	// freeBytes is the number of bytes to free by deleting the oldest files
+	// regardless of their age, 0 means none. The expired files count towards it.
+	freeBytes int64
+	// referenced returns which of the files to delete are still in use, those
+	// are kept regardless of their age and the limits.
+	referenced func(ctx context.Context, files []tempFile) (map[string]bool, error)
+	// observeAges records the ages of all listed files, see Cfg.TempDataAgeHistogram.
+	observeAges bool
+}
+
+// pruneFiles deletes the expired files of the storage, and the oldest files
+// above the maximum total size or number of files, and returns how many got deleted.
+func (srv *CleanUpService) pruneFiles(ctx context.Context, p filePruning, now time.Time) (int64, error) {
+	stored, err := p.storage.List(ctx)
+	if err != nil {
+		srv.log.Error("Problem listing files to clean up", "task", p.task, "error", err)
+		return 0, err
+	}
+
+	var toDelete []tempFile
+	var toKeep []tempFile
+	var candidates []tempFile
+	var files, futureFiles int
+	var futureFile string
+	var totalSize, storedSize int64
+	totalFiles := len(stored)
+	var foreign []string
+	var ages []time.Duration
+	defer func() {
+		if p.foreign != nil {
+			srv.logForeignFiles(p.task, foreign)
+		}
+	}()
+
+	for _, stored := range stored {
+		totalSize += stored.Size
+		storedSize += stored.Size
+		if p.observeAges {
+			ages = append(ages, now.Sub(p.storage.ModTime(stored)))
+		}
+		if p.excluded != nil && p.excluded(stored.Name()) {
+			continue
+		}
+		if p.foreign != nil && p.foreign(stored) {
+			foreign = append(foreign, stored.Path)
+			continue
+		}
+
+		files++
+		file := tempFile{TempFile: stored, age: p.storage.ModTime(stored)}
+		if file.age.After(now.Add(futureModTimeTolerance)) {
+			futureFiles++
+			futureFile = file.Path
+		}
+		if p.retention != nil {
+			candidates = append(candidates, file)
+		} else if p.expired(file.TempFile, file.age, now) {
+			toDelete = append(toDelete, file)
+			totalSize -= file.Size
+			totalFiles--
+		} else {
+			toKeep = append(toKeep, file)
+		}
+	}
+
+	if p.retention != nil {
+		var expired []tempFile
+		expired, toKeep = applyRetentionPolicy(p.retention, candidates, now)
+		for _, file := range expired {
+			totalSize -= file.Size
+			totalFiles--
+		}
+		toDelete = append(toDelete, expired...)
+	}
+
+	if futureFiles > 0 {
+		// these files are only removed once the clock catches up with them
+		srv.log.Warn("Found files modified in the future, check the clocks of the servers writing them", "task", p.task, "count", futureFiles, "example", futureFile)
+	}
+
+	if p.observeAges {
+		srv.observeFileAges(p.task, ages)
+	}
+
+	maxSize := p.maxSize
+	if p.freeBytes > 0 {
+		// the expired files are already subtracted from totalSize
+		target := storedSize - p.freeBytes
+		if target < 1 {
+			// a limit of 0 means no limit, 1 deletes every file that takes up space
+			target = 1
+		}
+		if maxSize <= 0 || target < maxSize {
+			maxSize = target
+		}
+	}
+
+	toDelete = append(toDelete, filesOverLimits(toKeep, totalSize, maxSize, totalFiles, p.maxFiles)...)
+
+	if p.referenced != nil && len(toDelete) > 0 {
+		referenced, err := p.referenced(ctx, toDelete)
+		if err != nil {
+			srv.log.Error("Problem checking the references of files to clean up", "task", p.task, "error", err)
+			return 0, err
+		}
+
+		unreferenced := toDelete[:0]
+		for _, file := range toDelete {
+			if !referenced[file.Path] {
+				unreferenced = append(unreferenced, file)
+			}
+		}
+		if kept := len(toDelete) - len(unreferenced); kept > 0 {
+			srv.log.Debug("Keeping files still in use", "task", p.task, "count", kept)
+		}
+		toDelete = unreferenced
+	}
+
+	if srv.Cfg.CleanupDryRun {
+		for _, file := range toDelete {
+			srv.log.Info("[Dry run] Would delete file", "task", p.task, "file", file.Path)
+		}
+		srv.log.Info("[Dry run] Found old files to delete", "task", p.task, "count", len(toDelete), "kept", files-len(toDelete))
+		return 0, nil
+	}
+
+	var deleted, reclaimed int64
+	for _, file := range toDelete {
+		if err := ctx.Err(); err != nil {
+			srv.log.Debug("File cleanup cancelled", "task", p.task, "deleted", deleted, "reclaimed bytes", reclaimed)
+			return deleted, err
+		}
+
+		err := p.storage.Delete(ctx, file.TempFile)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if os.IsPermission(err) && p.foreign != nil {
+			foreign = append(foreign, file.Path)
+			continue
+		}
+		if err != nil {
+			srv.log.Error("Failed to delete file", "task", p.task, "file", file.Path, "error", err)
+			metrics.MCleanupErrorsTotal.WithLabelValues(p.task).Inc()
+			continue
+		}
+		deleted++
+		reclaimed += file.Size
+	}
+
+	srv.logResult("Deleted old files", "task", p.task, "deleted", deleted, "kept", files-len(toDelete), "reclaimed bytes", reclaimed)
+
+	return deleted, nil
+}
+
+// observeFileAges records the ages of the files in the temp files age
+// histogram and logs their distribution, to help choosing a lifetime.
+func (srv *CleanUpService) observeFileAges(task string, ages []time.Duration) {
+	if len(ages) == 0 {
+		return
+	}
+
+	sort.Slice(ages, func(i, j int) bool {
+		return ages[i] < ages[j]
+	})
+	for _, age := range ages {
+		// files modified in the future count as new
+		if age < 0 {
+			age = 0
+		}
+		metrics.MTempFilesAge.Observe(age.Seconds())
+	}
+
+	percentile := func(p int) time.Duration {
+		return ages[(len(ages)-1)*p/100].Truncate(time.Second)
+	}
+	srv.log.Info("File ages", "task", task, "files", len(ages), "median", percentile(50), "p90", percentile(90), "oldest", percentile(100))
+}
+
+// logForeignFiles logs the files skipped because they belong to another user
+// when they are skipped for the first time. Files no longer found are forgotten.
+func (srv *CleanUpService) logForeignFiles(task string, files []string) {
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if !srv.foreignFiles[file] {
+			srv.log.Warn("Skipping file owned by another user or without permission to delete it", "task", task, "file", file)
+		}
+		seen[file] = true
+	}
+	srv.foreignFiles = seen
+}
+
+// filesOverLimits returns the oldest of the given files that have to be
+// deleted to bring totalSize back under maxSize and totalFiles back under
+// maxFiles. A maxSize or maxFiles of 0 or less doesn't limit the files.
+func filesOverLimits(files []tempFile, totalSize int64, maxSize int64, totalFiles int, maxFiles int) []tempFile {
+	overSize := func() bool {
+		return maxSize > 0 && totalSize > maxSize
+	}
+	overCount := func() bool {
+		return maxFiles > 0 && totalFiles > maxFiles
+	}
+	if !overSize() && !overCount() {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].age.Before(files[j].age)
+	})
+
+	var toDelete []tempFile
+	for _, file := range files {
+		if !overSize() && !overCount() {
+			break
+		}
+		toDelete = append(toDelete, file)
+		totalSize -= file.Size
+		totalFiles--
+	}
+
+	return toDelete
+}
@@ -0,0 +1,89 @@
+package cleanup
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// CleanupReport is the outcome of an on demand cleanup run, with one result
+// per task sorted by task name.
+type CleanupReport struct {
+	Tasks []TaskResult `json:"tasks"`
+}
+
+// TaskResult is the outcome of a single task of a CleanupReport.
+type TaskResult struct {
+	Name     string
+	Deleted  int64
+	Duration time.Duration
+	// Err is the error the task failed with, if any.
+	Err     error
+	Skipped bool
+}
+
+// MarshalJSON reports the error as its message and the duration in milliseconds.
+func (r TaskResult) MarshalJSON() ([]byte, error) {
+	result := struct {
+		Name       string `json:"name"`
+		Deleted    int64  `json:"deleted"`
+		DurationMs int64  `json:"durationMs"`
+		Skipped    bool   `json:"skipped,omitempty"`
+		Error      string `json:"error,omitempty"`
+	}{
+		Name:       r.Name,
+		Deleted:    r.Deleted,
+		DurationMs: r.Duration.Milliseconds(),
+		Skipped:    r.Skipped,
+	}
+	if r.Err != nil {
+		result.Error = r.Err.Error()
+	}
+
+	return json.Marshal(result)
+}
+
+// Task returns the result of the task with the given name.
+func (r CleanupReport) Task(name string) (TaskResult, bool) {
+	for _, result := range r.Tasks {
+		if result.Name == name {
+			return result, true
+		}
+	}
+
+	return TaskResult{}, false
+}
+
+// Failed returns the results of the tasks that failed.
+func (r CleanupReport) Failed() []TaskResult {
+	var failed []TaskResult
+	for _, result := range r.Tasks {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
+}
+
+func newCleanupReport(summary map[string]TaskSummary) CleanupReport {
+	report := CleanupReport{Tasks: make([]TaskResult, 0, len(summary))}
+	for name, task := range summary {
+		report.Tasks = append(report.Tasks, newTaskResult(name, task))
+	}
+	sort.Slice(report.Tasks, func(i, j int) bool {
+		return report.Tasks[i].Name < report.Tasks[j].Name
+	})
+
+	return report
+}
+
+func newTaskResult(name string, summary TaskSummary) TaskResult {
+	return TaskResult{
+		Name:     name,
+		Deleted:  summary.Deleted,
+		Duration: summary.Duration,
+		Err:      summary.err,
+		Skipped:  summary.Skipped,
+	}
+}
@@ -0,0 +1,109 @@
+package cleanup
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetentionCandidate is an item a cleanup task may delete.
+type RetentionCandidate struct {
+	// ID identifies the candidate within its task, e.g. the path of a file.
+	ID string
+	// Time is the time the age of the candidate is counted from, e.g. the
+	// modification time of a file.
+	Time time.Time
+	// Size is the size of the candidate in bytes, if known.
+	Size int64
+}
+
+// RetentionPolicy decides which of the candidates of a task run are deleted,
+// it returns the candidates to delete and keeps all others. It's called with
+// every candidate of the run at once, so rules like "keep one file per day
+// for 30 days and one per week for a year" can look at all of them.
+//
+// Candidates that are never deleted, e.g. excluded temp files, aren't passed
+// to the policy. The size and count limits of the task still apply to the
+// candidates the policy keeps.
+type RetentionPolicy func(candidates []RetentionCandidate, now time.Time) []RetentionCandidate
+
+// AgeRetentionPolicy returns the policy deleting the candidates that are
+// older than maxAge, which is how the built-in tasks retain items by default.
+// A maxAge of 0 or less keeps everything.
+func AgeRetentionPolicy(maxAge time.Duration) RetentionPolicy {
+	return func(candidates []RetentionCandidate, now time.Time) []RetentionCandidate {
+		if maxAge <= 0 {
+			return nil
+		}
+
+		var expired []RetentionCandidate
+		for _, candidate := range candidates {
+			if candidate.Time.Add(maxAge).Before(now) {
+				expired = append(expired, candidate)
+			}
+		}
+		return expired
+	}
+}
+
+// retentionPolicyTasks are the tasks whose retention can be replaced with
+// SetRetentionPolicy. The other built-in tasks delete rows in the database
+// without loading them first.
+var retentionPolicyTasks = map[string]bool{
+	taskTmpFiles:    true,
+	taskExportFiles: true,
+}
+
+// SetRetentionPolicy replaces the lifetime settings of the task with policy,
+// e.g. to keep files in tiers. A nil policy restores the settings.
+// Only the tmp_files and export_files tasks support retention policies.
+func (srv *CleanUpService) SetRetentionPolicy(task string, policy RetentionPolicy) error {
+	if !retentionPolicyTasks[task] {
+		return fmt.Errorf("cleanup task %q doesn't support retention policies", task)
+	}
+
+	srv.tasksMtx.Lock()
+	defer srv.tasksMtx.Unlock()
+
+	if policy == nil {
+		delete(srv.retentionPolicies, task)
+		return nil
+	}
+	if srv.retentionPolicies == nil {
+		srv.retentionPolicies = map[string]RetentionPolicy{}
+	}
+	srv.retentionPolicies[task] = policy
+
+	return nil
+}
+
+// retentionPolicy returns the policy set for the task, or nil if it retains
+// items according to its settings.
+func (srv *CleanUpService) retentionPolicy(task string) RetentionPolicy {
+	srv.tasksMtx.RLock()
+	defer srv.tasksMtx.RUnlock()
+
+	return srv.retentionPolicies[task]
+}
+
+// applyRetentionPolicy splits the files into the ones the policy deletes and
+// the ones it keeps.
+func applyRetentionPolicy(policy RetentionPolicy, files []tempFile, now time.Time) (toDelete []tempFile, toKeep []tempFile) {
+	candidates := make([]RetentionCandidate, 0, len(files))
+	for _, file := range files {
+		candidates = append(candidates, RetentionCandidate{ID: file.Path, Time: file.age, Size: file.Size})
+	}
+
+	deleted := map[string]bool{}
+	for _, candidate := range policy(candidates, now) {
+		deleted[candidate.ID] = true
+	}
+
+	for _, file := range files {
+		if deleted[file.Path] {
+			toDelete = append(toDelete, file)
+		} else {
+			toKeep = append(toKeep, file)
+		}
+	}
+	return toDelete, toKeep
+}
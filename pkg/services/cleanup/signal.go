@@ -0,0 +1,35 @@
+package cleanup
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// RunOnSignal runs all cleanup tasks once, like RunOnce, when the process
+// received the cleanup signal, e.g. from `kill -USR1`. A signal received while
+// a signal triggered cleanup is still running is ignored, as are all signals
+// when Cfg.CleanupRunOnSignal is turned off.
+func (srv *CleanUpService) RunOnSignal(ctx context.Context, sig os.Signal) {
+	if !srv.Cfg.CleanupRunOnSignal {
+		srv.log.Info("Ignoring cleanup signal, run_on_signal is turned off", "signal", sig)
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&srv.signalRunning, 0, 1) {
+		srv.log.Info("Ignoring cleanup signal, a signal triggered cleanup is still running", "signal", sig)
+		return
+	}
+	defer atomic.StoreInt32(&srv.signalRunning, 0)
+
+	srv.log.Info("Signal triggered cleanup started", "signal", sig)
+	start := time.Now()
+	report := srv.RunOnce(ctx)
+
+	var deleted int64
+	for _, task := range report.Tasks {
+		deleted += task.Deleted
+	}
+	srv.log.Info("Signal triggered cleanup finished", "signal", sig, "deleted", deleted, "tasks", len(report.Tasks),
+		"failed", len(report.Failed()), "duration", time.Since(start))
+}
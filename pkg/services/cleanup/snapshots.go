@@ -0,0 +1,68 @@
+package cleanup
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/snapshots"
+)
+
+// Requests to external snapshot servers are retried a few times, so a snapshot
+// server that is briefly unavailable doesn't keep the snapshots around.
+const (
+	externalSnapshotDeleteAttempts = 3
+	externalSnapshotDeleteBackoff  = time.Millisecond * 500
+)
+
+// deleteExternalSnapshots deletes the external copies of all snapshots expired
+// at now and returns the ids of the snapshots that have to be kept because their
+// copy couldn't be deleted.
+func (srv *CleanUpService) deleteExternalSnapshots(ctx context.Context, now time.Time) ([]int64, error) {
+	query := models.GetExpiredExternalSnapshotsQuery{Now: now, OrgMaxAge: srv.Cfg.SnapshotMaxAgePerOrg}
+	if err := bus.Dispatch(&query); err != nil {
+		return nil, err
+	}
+
+	var keepIds []int64
+	for _, snapshot := range query.Result {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		err := deleteExternalSnapshotWithRetries(ctx, snapshot.ExternalDeleteUrl)
+		if err == nil {
+			continue
+		}
+
+		srv.log.Warn("Failed to delete external snapshot", "id", snapshot.Id, "error", err)
+		if srv.Cfg.SnapshotExternalDeleteRequired {
+			keepIds = append(keepIds, snapshot.Id)
+		}
+	}
+
+	return keepIds, nil
+}
+
+func deleteExternalSnapshotWithRetries(ctx context.Context, deleteURL string) error {
+	var err error
+	backoff := externalSnapshotDeleteBackoff
+	for attempt := 1; attempt <= externalSnapshotDeleteAttempts; attempt++ {
+		if err = snapshots.DeleteExternalSnapshot(ctx, deleteURL); err == nil {
+			return nil
+		}
+		if attempt == externalSnapshotDeleteAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,81 @@
+package cleanup
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TaskStatus is the outcome of the latest run of a cleanup task on this instance.
+type TaskStatus struct {
+	LastRun     time.Time `json:"lastRun"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastDeleted int64     `json:"lastDeleted"`
+	// ConsecutiveFailures counts the runs that failed since the last successful one.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+}
+
+type taskStatuses struct {
+	mtx      sync.RWMutex
+	statuses map[string]TaskStatus
+}
+
+func (s *taskStatuses) update(task string, start time.Time, summary TaskSummary) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.statuses == nil {
+		s.statuses = map[string]TaskStatus{}
+	}
+
+	status := s.statuses[task]
+	status.LastRun = start
+	status.LastError = summary.Error
+	status.LastDeleted = summary.Deleted
+	if summary.Error == "" {
+		status.LastSuccess = start
+		status.ConsecutiveFailures = 0
+	} else {
+		status.ConsecutiveFailures++
+	}
+	s.statuses[task] = status
+}
+
+func (s *taskStatuses) get(task string) TaskStatus {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.statuses[task]
+}
+
+// Status returns the latest run of every registered task on this instance.
+// Tasks that haven't run yet have a zero LastRun, tasks skipped because
+// another instance holds their server lock keep their previous status.
+func (srv *CleanUpService) Status() map[string]TaskStatus {
+	tasks := srv.registeredTasks()
+	status := make(map[string]TaskStatus, len(tasks))
+	for _, task := range tasks {
+		status[task.Name()] = srv.statuses.get(task.Name())
+	}
+
+	return status
+}
+
+// DegradedTasks returns the registered tasks, sorted by name, that failed at
+// least Cfg.CleanupFailureThreshold times in a row on this instance.
+func (srv *CleanUpService) DegradedTasks() []string {
+	if srv.Cfg.CleanupFailureThreshold <= 0 {
+		return nil
+	}
+
+	var degraded []string
+	for _, task := range srv.registeredTasks() {
+		if srv.statuses.get(task.Name()).ConsecutiveFailures >= srv.Cfg.CleanupFailureThreshold {
+			degraded = append(degraded, task.Name())
+		}
+	}
+	sort.Strings(degraded)
+
+	return degraded
+}
@@ -0,0 +1,54 @@
+package cleanup
+
+import (
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+// CleanupEvent describes a finished run of a cleanup task.
+type CleanupEvent struct {
+	Task string
+	// Deleted is the number of rows or files the run removed, it's 0 in dry
+	// run mode.
+	Deleted   int64
+	StartedAt time.Time
+	// Err is the error the run failed with, if any.
+	Err error
+}
+
+// Subscribe registers fn to be called after every run of every cleanup task,
+// scheduled or on demand, including failed runs. Skipped runs, e.g. because
+// another instance holds the task's server lock, aren't reported.
+//
+// Subscribers are called one after the other in the order they subscribed, on
+// the goroutine that ran the task and before the task counts as finished, so
+// they should return quickly. Tasks may run concurrently, so fn has to be safe
+// for concurrent use. Calls are best-effort: a panic in a subscriber is
+// recovered and logged and doesn't affect the task or the other subscribers.
+func (srv *CleanUpService) Subscribe(fn func(CleanupEvent)) {
+	srv.subscribersMtx.Lock()
+	defer srv.subscribersMtx.Unlock()
+
+	srv.subscribers = append(srv.subscribers, fn)
+}
+
+func (srv *CleanUpService) notifySubscribers(event CleanupEvent) {
+	srv.subscribersMtx.RLock()
+	subscribers := append([]func(CleanupEvent){}, srv.subscribers...)
+	srv.subscribersMtx.RUnlock()
+
+	for _, fn := range subscribers {
+		srv.notifySubscriber(fn, event)
+	}
+}
+
+func (srv *CleanUpService) notifySubscriber(fn func(CleanupEvent), event CleanupEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			srv.log.Error("Cleanup subscriber panic", "task", event.Task, "error", r, "stack", log.Stack(1))
+		}
+	}()
+
+	fn(event)
+}
@@ -0,0 +1,50 @@
+package cleanup
+
+import (
+	"context"
+	"time"
+)
+
+// CleanupTask is a unit of work run periodically by the CleanUpService.
+// Tasks registered with RegisterTask run behind a server lock named after
+// the task, so only one Grafana instance runs them per interval.
+type CleanupTask interface {
+	// Name identifies the task in logs, metrics and the cleanup summary.
+	Name() string
+	// Run performs the cleanup and returns the number of removed items.
+	Run(ctx context.Context) (deleted int64, err error)
+	// Interval is how often the task should run.
+	Interval() time.Duration
+}
+
+// cleanupTask is the CleanupTask implementation of the built-in tasks.
+type cleanupTask struct {
+	name string
+	// lockName is the server lock the task runs behind. Tasks without a lock
+	// clean up node local state and run on every instance.
+	lockName string
+	interval time.Duration
+	run      func(ctx context.Context) (int64, error)
+}
+
+func (t *cleanupTask) Name() string {
+	return t.name
+}
+
+func (t *cleanupTask) Run(ctx context.Context) (int64, error) {
+	return t.run(ctx)
+}
+
+func (t *cleanupTask) Interval() time.Duration {
+	return t.interval
+}
+
+// taskLockName returns the name of the server lock guarding the task, or an
+// empty string if the task runs on every instance.
+func taskLockName(task CleanupTask) string {
+	if t, ok := task.(*cleanupTask); ok {
+		return t.lockName
+	}
+
+	return task.Name()
+}
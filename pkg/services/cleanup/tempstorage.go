@@ -0,0 +1,176 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/components/imguploader"
+	"github.com/grafana/grafana/pkg/services/rendering"
+)
+
+// TempStorage is where the images pruned by the tmp_files cleanup task live.
+type TempStorage interface {
+	// List returns all files of the storage.
+	List(ctx context.Context) ([]TempFile, error)
+	// ModTime returns the time the age of the file is counted from.
+	ModTime(file TempFile) time.Time
+	// Delete removes the file, a file that no longer exists is reported with an
+	// error satisfying os.IsNotExist.
+	Delete(ctx context.Context, file TempFile) error
+}
+
+// TempFile is a file of a TempStorage.
+type TempFile struct {
+	// Path identifies the file in its storage, e.g. a file path or a bucket key.
+	Path string
+	Size int64
+	// AlertImage marks images rendered for alert notifications, which are kept
+	// for Cfg.AlertingImageRetention.
+	AlertImage bool
+
+	info    os.FileInfo
+	modTime time.Time
+}
+
+// Name returns the last element of the path, which exclude patterns are matched against.
+func (f TempFile) Name() string {
+	return filepath.Base(f.Path)
+}
+
+// newAlertImageBucket returns the storage of the alert notification images
+// uploaded to the S3 external image storage. Without a path the images would
+// share the bucket with everything else in it, so that's refused.
+func newAlertImageBucket() (*bucketTempStorage, error) {
+	bucket, err := imguploader.NewS3UploaderFromSettings()
+	if err != nil {
+		return nil, err
+	}
+	if bucket.Path() == "" {
+		return nil, errors.New("[external_image_storage.s3] has no path, cleaning up the alert images would delete every object in the bucket")
+	}
+
+	return &bucketTempStorage{bucket: bucket}, nil
+}
+
+// localTempStorage holds the files in a local directory, e.g. the images
+// rendered to Cfg.ImagesDir.
+type localTempStorage struct {
+	srv *CleanUpService
+	dir string
+	// trash moves deleted files to Cfg.TempDataTrashDir when it's set.
+	trash bool
+	// dirs are the subdirectories found by the latest List.
+	dirs []string
+	// emptiedDirs are the directories files were deleted from since the latest List.
+	emptiedDirs map[string]bool
+}
+
+func (s *localTempStorage) List(ctx context.Context) ([]TempFile, error) {
+	s.dirs = nil
+	s.emptiedDirs = map[string]bool{}
+
+	if _, err := os.Stat(s.dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var files []TempFile
+	err := filepath.Walk(s.dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() && s.srv.isTrashDir(filePath) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			if filePath != s.dir {
+				s.dirs = append(s.dirs, filePath)
+			}
+			return nil
+		}
+		// symlinks, which Walk doesn't follow, and other special files are left alone
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		files = append(files, TempFile{
+			Path:       filePath,
+			Size:       info.Size(),
+			AlertImage: strings.HasPrefix(info.Name(), rendering.AlertImagePrefix),
+			info:       info,
+		})
+		return nil
+	})
+
+	return files, err
+}
+
+func (s *localTempStorage) ModTime(file TempFile) time.Time {
+	return s.srv.tempFileTime(file.info)
+}
+
+func (s *localTempStorage) Delete(ctx context.Context, file TempFile) error {
+	var err error
+	if s.trash {
+		err = s.srv.removeTempFile(file.Path)
+	} else {
+		err = os.Remove(file.Path)
+	}
+	if err == nil && s.emptiedDirs != nil {
+		s.emptiedDirs[filepath.Dir(file.Path)] = true
+	}
+	return err
+}
+
+// probeWritable creates and removes a file in dir to check that the temp files
+// in it can be deleted. A missing dir has nothing to clean up yet and passes.
+func probeWritable(dir string) error {
+	probe, err := ioutil.TempFile(dir, ".cleanup-probe-")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := probe.Close(); err != nil {
+		return err
+	}
+	return os.Remove(probe.Name())
+}
+
+// bucketTempStorage holds the alert notification images uploaded to the S3
+// bucket of the external image storage.
+type bucketTempStorage struct {
+	bucket *imguploader.S3Uploader
+}
+
+func (s *bucketTempStorage) List(ctx context.Context) ([]TempFile, error) {
+	images, err := s.bucket.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]TempFile, 0, len(images))
+	for _, image := range images {
+		// only alert notifications upload images to the external image storage
+		files = append(files, TempFile{Path: image.Key, Size: image.Size, AlertImage: true, modTime: image.LastModified})
+	}
+
+	return files, nil
+}
+
+func (s *bucketTempStorage) ModTime(file TempFile) time.Time {
+	return file.modTime
+}
+
+func (s *bucketTempStorage) Delete(ctx context.Context, file TempFile) error {
+	return s.bucket.DeleteImage(ctx, file.Path)
+}
@@ -0,0 +1,125 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxTrashNameAttempts bounds the suffixes tried for a file name that is
+// already taken in the trash directory.
+const maxTrashNameAttempts = 100
+
+// removeTempFile moves the file into Cfg.TempDataTrashDir when it's set and
+// removes it otherwise. Files that can't be moved, e.g. because the trash
+// directory is on another device, are removed as well.
+func (srv *CleanUpService) removeTempFile(path string) error {
+	if srv.Cfg.TempDataTrashDir == "" {
+		return os.Remove(path)
+	}
+
+	err := srv.moveToTrash(path)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+
+	srv.log.Warn("Failed to move temp file to trash, deleting it", "file", path, "trash", srv.Cfg.TempDataTrashDir, "error", err)
+	return os.Remove(path)
+}
+
+func (srv *CleanUpService) moveToTrash(path string) error {
+	if err := os.MkdirAll(srv.Cfg.TempDataTrashDir, 0750); err != nil {
+		return err
+	}
+
+	target, err := trashPath(srv.Cfg.TempDataTrashDir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path, target); err != nil {
+		return err
+	}
+
+	// the trash retention counts from the time the file got moved
+	now := srv.clock()
+	if err := os.Chtimes(target, now, now); err != nil {
+		srv.log.Warn("Failed to update modification time of trashed temp file", "file", target, "error", err)
+	}
+
+	return nil
+}
+
+// trashPath returns a path for name in dir that isn't taken yet, adding a
+// numeric suffix like image-1.png when a file of the same name was trashed before.
+func trashPath(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 0; i < maxTrashNameAttempts; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+
+		path := filepath.Join(dir, candidate)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free file name for %q in trash directory", name)
+}
+
+// isTrashDir reports whether path is the trash directory, which is skipped
+// when it lives inside the temporary images directory.
+func (srv *CleanUpService) isTrashDir(path string) bool {
+	if srv.Cfg.TempDataTrashDir == "" {
+		return false
+	}
+
+	return filepath.Clean(path) == filepath.Clean(srv.Cfg.TempDataTrashDir)
+}
+
+// emptyTrash deletes the files that have been in the trash directory for
+// longer than Cfg.TempDataTrashLifetime, or all of them if all is set.
+func (srv *CleanUpService) emptyTrash(ctx context.Context, now time.Time, all bool) (int64, error) {
+	if srv.Cfg.TempDataTrashDir == "" || (srv.Cfg.TempDataTrashLifetime <= 0 && !all) {
+		return 0, nil
+	}
+
+	files, err := ioutil.ReadDir(srv.Cfg.TempDataTrashDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		srv.log.Error("Problem reading temp data trash dir", "error", err)
+		return 0, err
+	}
+
+	var deleted int64
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		if !file.Mode().IsRegular() || (!all && !file.ModTime().Add(srv.Cfg.TempDataTrashLifetime).Before(now)) {
+			continue
+		}
+
+		path := filepath.Join(srv.Cfg.TempDataTrashDir, file.Name())
+		if srv.Cfg.CleanupDryRun {
+			srv.log.Info("[Dry run] Would delete trashed temp file", "file", path)
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			srv.log.Error("Failed to delete trashed temp file", "file", path, "error", err)
+			continue
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
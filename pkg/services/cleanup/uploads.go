@@ -0,0 +1,55 @@
+package cleanup
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// cleanUpUploadedFiles deletes the files in Cfg.UploadsDir that are older than
+// Cfg.UploadDataLifetime and that no dashboard or data source refers to.
+func (srv *CleanUpService) cleanUpUploadedFiles(ctx context.Context) (int64, error) {
+	return srv.pruneFiles(ctx, filePruning{
+		task:       taskUploadedFiles,
+		storage:    &localTempStorage{srv: srv, dir: srv.Cfg.UploadsDir},
+		expired:    srv.shouldCleanupUploadedFile,
+		referenced: srv.referencedUploads,
+	}, srv.clock())
+}
+
+func (srv *CleanUpService) shouldCleanupUploadedFile(file TempFile, age time.Time, now time.Time) bool {
+	if srv.Cfg.UploadDataLifetime == 0 {
+		return false
+	}
+
+	return age.Add(srv.Cfg.UploadDataLifetime).Before(now)
+}
+
+// referencedUploads looks up which of the files the dashboards and data
+// sources refer to by their path relative to Cfg.UploadsDir.
+func (srv *CleanUpService) referencedUploads(ctx context.Context, files []tempFile) (map[string]bool, error) {
+	paths := make(map[string]string, len(files))
+	query := models.FindUploadReferencesQuery{}
+	for _, file := range files {
+		name, err := filepath.Rel(srv.Cfg.UploadsDir, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.ToSlash(name)
+		paths[name] = file.Path
+		query.Names = append(query.Names, name)
+	}
+
+	if err := bus.DispatchCtx(ctx, &query); err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool, len(query.Result))
+	for name := range query.Result {
+		referenced[paths[name]] = true
+	}
+	return referenced, nil
+}
@@ -0,0 +1,41 @@
+package cleanup
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// vacuumIfNeeded vacuums the database once the cleanup cycles deleted more
+// than Cfg.CleanupSqliteVacuumThreshold rows since the last vacuum. Rows are
+// added up across cycles, as tasks on their own schedules rarely run together.
+func (srv *CleanUpService) vacuumIfNeeded(ctx context.Context, summary map[string]TaskSummary) {
+	if !srv.Cfg.CleanupSqliteVacuum {
+		return
+	}
+
+	srv.vacuumMtx.Lock()
+	defer srv.vacuumMtx.Unlock()
+
+	for name, task := range summary {
+		// temp, export, uploaded files and alert images aren't stored in the database
+		if name != taskTmpFiles && name != taskExportFiles && name != taskUploadedFiles && name != taskAlertImageBucket {
+			srv.deletedSinceVacuum += task.Deleted
+		}
+	}
+	if srv.deletedSinceVacuum < srv.Cfg.CleanupSqliteVacuumThreshold || ctx.Err() != nil {
+		return
+	}
+
+	cmd := models.VacuumDatabaseCommand{}
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
+		srv.log.Error("Failed to vacuum database", "error", err)
+		return
+	}
+	srv.deletedSinceVacuum = 0
+
+	if cmd.Vacuumed {
+		srv.log.Info("Vacuumed database", "reclaimed bytes", cmd.ReclaimedBytes)
+	}
+}
@@ -0,0 +1,68 @@
+package cleanup
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// taskTables are the tables the built-in database tasks delete from, for the
+// tasks that delete from a single table only.
+var taskTables = map[string]string{
+	taskExpiredSnapshots:         "dashboard_snapshot",
+	taskSnapshotsOverLimit:       "dashboard_snapshot",
+	taskExpiredDashboardVersions: "dashboard_version",
+	taskOrphanedAnnotations:      "annotation",
+	taskOldLoginAttempts:         "login_attempt",
+	taskExpiredAPIKeys:           "api_key",
+	taskExpiredUserInvites:       "temp_user",
+	taskExpiredAuthTokens:        "user_auth_token",
+	taskOrphanedDashboardAcl:     "dashboard_acl",
+	taskStaleServerLocks:         "server_lock",
+	taskOrphanedPreferences:      "preferences",
+	taskOrphanedStars:            "star",
+	taskOrphanedPlaylistItems:    "playlist_item",
+	taskDuplicateUserInvites:     "temp_user",
+	taskExpiredCacheData:         "cache_data",
+	taskStaleDashboardProvision:  "dashboard_provisioning",
+}
+
+// countTaskRows counts the rows of the table of the task when
+// Cfg.CleanupVerifyCounts is set. It reports false when the task isn't
+// verified, e.g. because it has no single table or the count failed.
+func (srv *CleanUpService) countTaskRows(ctx context.Context, task string) (int64, bool) {
+	table, ok := taskTables[task]
+	if !ok || !srv.Cfg.CleanupVerifyCounts || srv.Cfg.CleanupDryRun {
+		return 0, false
+	}
+
+	query := models.GetTableRowCountQuery{Table: table}
+	if err := bus.DispatchCtx(ctx, &query); err != nil {
+		srv.log.Warn("Failed to count rows to verify cleanup task", "task", task, "table", table, "error", err)
+		return 0, false
+	}
+
+	return query.Result, true
+}
+
+// verifyDeletedRows compares the rows the task deleted according to its
+// result with the difference of the row counts before and after it ran.
+// Rows inserted during the run, or a task deleting other rows than it
+// reports, make them differ.
+func (srv *CleanUpService) verifyDeletedRows(ctx context.Context, task string, before int64, deleted int64) {
+	after, ok := srv.countTaskRows(ctx, task)
+	if !ok {
+		return
+	}
+
+	table := taskTables[task]
+	delta := before - after
+	if delta != deleted {
+		srv.log.Warn("Row count difference doesn't match the rows the cleanup task deleted", "task", task, "table", table,
+			"before", before, "after", after, "delta", delta, "deleted", deleted)
+		return
+	}
+
+	srv.logResult("Verified deleted rows", "task", task, "table", table, "before", before, "after", after, "deleted", deleted)
+}
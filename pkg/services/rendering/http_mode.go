@@ -27,7 +27,7 @@ var netClient = &http.Client{
 }
 
 func (rs *RenderingService) renderViaHttp(ctx context.Context, renderKey string, opts Opts) (*RenderResult, error) {
-	filePath, err := rs.getFilePathForNewImage()
+	filePath, err := rs.getFilePathForNewImage(opts.FilePrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -12,6 +12,10 @@ var ErrTimeout = errors.New("Timeout error. You can set timeout in seconds with
 var ErrNoRenderer = errors.New("No renderer plugin found nor is an external render server configured")
 var ErrPhantomJSNotInstalled = errors.New("PhantomJS executable not found")
 
+// AlertImagePrefix starts the file names of images rendered for alert
+// notifications, the cleanup keeps them for Cfg.AlertingImageRetention.
+const AlertImagePrefix = "alert-"
+
 type Opts struct {
 	Width             int
 	Height            int
@@ -25,6 +29,8 @@ type Opts struct {
 	ConcurrentLimit   int
 	DeviceScaleFactor float64
 	Headers           map[string][]string
+	// FilePrefix is prepended to the file name of the rendered image, see AlertImagePrefix
+	FilePrefix string
 }
 
 type RenderResult struct {
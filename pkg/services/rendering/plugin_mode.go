@@ -26,7 +26,7 @@ func (rs *RenderingService) renderViaPlugin(ctx context.Context, renderKey strin
 }
 
 func (rs *RenderingService) renderViaPluginV1(ctx context.Context, renderKey string, opts Opts) (*RenderResult, error) {
-	pngPath, err := rs.getFilePathForNewImage()
+	pngPath, err := rs.getFilePathForNewImage(opts.FilePrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -60,7 +60,7 @@ func (rs *RenderingService) renderViaPluginV1(ctx context.Context, renderKey str
 }
 
 func (rs *RenderingService) renderViaPluginV2(ctx context.Context, renderKey string, opts Opts) (*RenderResult, error) {
-	pngPath, err := rs.getFilePathForNewImage()
+	pngPath, err := rs.getFilePathForNewImage(opts.FilePrefix)
 	if err != nil {
 		return nil, err
 	}
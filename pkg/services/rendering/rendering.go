@@ -202,12 +202,12 @@ func (rs *RenderingService) GetRenderUser(key string) (*RenderUser, bool) {
 	return nil, false
 }
 
-func (rs *RenderingService) getFilePathForNewImage() (string, error) {
+func (rs *RenderingService) getFilePathForNewImage(prefix string) (string, error) {
 	rand, err := util.GetRandomString(20)
 	if err != nil {
 		return "", err
 	}
-	pngPath, err := filepath.Abs(filepath.Join(rs.Cfg.ImagesDir, rand))
+	pngPath, err := filepath.Abs(filepath.Join(rs.Cfg.ImagesDir, prefix+rand))
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,55 @@
+package snapshots
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// deleteTimeout limits a single delete request, so a slow snapshot server
+// doesn't hold up the caller.
+const deleteTimeout = time.Second * 5
+
+var client = &http.Client{
+	Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+}
+
+// DeleteExternalSnapshot calls the delete url of a snapshot published to an
+// external snapshot server. A snapshot the server doesn't know anymore counts
+// as deleted.
+func DeleteExternalSnapshot(ctx context.Context, deleteURL string) error {
+	ctx, cancelFn := context.WithTimeout(ctx, deleteTimeout)
+	defer cancelFn()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, deleteURL, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	// Gracefully ignore "snapshot not found" errors as they could have already
+	// been removed either via the cleanup script or by request.
+	if response.StatusCode == http.StatusInternalServerError {
+		var respJson map[string]interface{}
+		if err := json.NewDecoder(response.Body).Decode(&respJson); err != nil {
+			return err
+		}
+
+		if respJson["message"] == "Failed to get dashboard snapshot" {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unexpected response when deleting external snapshot, status code: %d", response.StatusCode)
+}
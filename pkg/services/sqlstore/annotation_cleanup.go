@@ -5,10 +5,19 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/setting"
 )
 
+func init() {
+	bus.AddHandlerCtx("sql", DeleteOrphanedAnnotations)
+	bus.AddHandlerCtx("sql", DeleteOrphanedAnnotationTags)
+}
+
+const defaultAnnotationCleanupBatchSize = 100
+
 // AnnotationCleanupService is responseible for cleaning old annotations.
 type AnnotationCleanupService struct {
 	batchSize int64
@@ -19,31 +28,47 @@ const (
 	alertAnnotationType     = "alert_id <> 0"
 	dashboardAnnotationType = "dashboard_id <> 0 AND alert_id = 0"
 	apiAnnotationType       = "alert_id = 0 AND dashboard_id = 0"
+
+	orphanedAnnotationType = "dashboard_id <> 0 AND NOT EXISTS (SELECT 1 FROM dashboard WHERE dashboard.id = annotation.dashboard_id)"
+
+	orphanedAnnotationTag = "NOT EXISTS (SELECT 1 FROM annotation WHERE annotation.id = annotation_tag.annotation_id)"
+	// unusedTag ignores the annotation_tag rows of deleted annotations, so a dry
+	// run counts the tags that become unused once those are deleted as well.
+	unusedTag = `NOT EXISTS (SELECT 1 FROM annotation_tag INNER JOIN annotation ON annotation.id = annotation_tag.annotation_id WHERE annotation_tag.tag_id = tag.id)
+		AND NOT EXISTS (SELECT 1 FROM alert_rule_tag WHERE alert_rule_tag.tag_id = tag.id)`
 )
 
 // CleanAnnotations deletes old annotations created by
 // alert rules, API requests and human made in the UI.
+// When cfg.CleanupDryRun is set the annotations are only counted and logged.
 func (acs *AnnotationCleanupService) CleanAnnotations(ctx context.Context, cfg *setting.Cfg) error {
-	err := acs.cleanAnnotations(ctx, cfg.AlertingAnnotationCleanupSetting, alertAnnotationType)
+	clean := acs.cleanAnnotations
+	if cfg.CleanupDryRun {
+		clean = acs.countAnnotationsToClean
+	}
+
+	err := clean(ctx, cfg.AlertingAnnotationCleanupSetting, alertAnnotationType)
 	if err != nil {
 		return err
 	}
 
-	err = acs.cleanAnnotations(ctx, cfg.APIAnnotationCleanupSettings, apiAnnotationType)
+	err = clean(ctx, cfg.APIAnnotationCleanupSettings, apiAnnotationType)
 	if err != nil {
 		return err
 	}
 
-	return acs.cleanAnnotations(ctx, cfg.DashboardAnnotationCleanupSettings, dashboardAnnotationType)
+	return clean(ctx, cfg.DashboardAnnotationCleanupSettings, dashboardAnnotationType)
 }
 
 func (acs *AnnotationCleanupService) cleanAnnotations(ctx context.Context, cfg setting.AnnotationCleanupSettings, annotationType string) error {
 	if cfg.MaxAge > 0 {
 		cutoffDate := time.Now().Add(-cfg.MaxAge).UnixNano() / int64(time.Millisecond)
 		deleteQuery := `DELETE FROM annotation WHERE id IN (SELECT id FROM (SELECT id FROM annotation WHERE %s AND created < %v ORDER BY id DESC %s) a)`
-		sql := fmt.Sprintf(deleteQuery, annotationType, cutoffDate, dialect.Limit(acs.batchSize))
+		query := func(limit int64) string {
+			return fmt.Sprintf(deleteQuery, annotationType, cutoffDate, dialect.Limit(limit))
+		}
 
-		err := acs.executeUntilDoneOrCancelled(ctx, sql)
+		_, err := executeUntilDoneOrCancelled(ctx, acs.batchSize, query)
 		if err != nil {
 			return err
 		}
@@ -51,22 +76,131 @@ func (acs *AnnotationCleanupService) cleanAnnotations(ctx context.Context, cfg s
 
 	if cfg.MaxCount > 0 {
 		deleteQuery := `DELETE FROM annotation WHERE id IN (SELECT id FROM (SELECT id FROM annotation WHERE %s ORDER BY id DESC %s) a)`
-		sql := fmt.Sprintf(deleteQuery, annotationType, dialect.LimitOffset(acs.batchSize, cfg.MaxCount))
-		return acs.executeUntilDoneOrCancelled(ctx, sql)
+		query := func(limit int64) string {
+			return fmt.Sprintf(deleteQuery, annotationType, dialect.LimitOffset(limit, cfg.MaxCount))
+		}
+		_, err := executeUntilDoneOrCancelled(ctx, acs.batchSize, query)
+		return err
 	}
 
 	return nil
 }
 
-func (acs *AnnotationCleanupService) executeUntilDoneOrCancelled(ctx context.Context, sql string) error {
+// countAnnotationsToClean logs how many annotations of the given type cleanAnnotations would delete.
+func (acs *AnnotationCleanupService) countAnnotationsToClean(ctx context.Context, cfg setting.AnnotationCleanupSettings, annotationType string) error {
+	return withDbSession(ctx, func(session *DBSession) error {
+		if cfg.MaxAge > 0 {
+			cutoffDate := time.Now().Add(-cfg.MaxAge).UnixNano() / int64(time.Millisecond)
+			count, err := countRows(session, fmt.Sprintf(`SELECT COUNT(*) AS count FROM annotation WHERE %s AND created < %v`, annotationType, cutoffDate))
+			if err != nil {
+				return err
+			}
+			acs.log.Info("[Dry run] Would delete annotations older than max age", "type", annotationType, "count", count)
+		}
+
+		if cfg.MaxCount > 0 {
+			count, err := countRows(session, fmt.Sprintf(`SELECT COUNT(*) AS count FROM annotation WHERE %s`, annotationType))
+			if err != nil {
+				return err
+			}
+
+			toDelete := count - cfg.MaxCount
+			if toDelete < 0 {
+				toDelete = 0
+			}
+			acs.log.Info("[Dry run] Would delete annotations exceeding max count", "type", annotationType, "count", toDelete)
+		}
+
+		return nil
+	})
+}
+
+// DeleteOrphanedAnnotations deletes, in batches, annotations whose dashboard has been deleted.
+func DeleteOrphanedAnnotations(ctx context.Context, cmd *models.CleanupAnnotationsCommand) error {
+	if cmd.DryRun {
+		return withDbSession(ctx, func(session *DBSession) error {
+			var err error
+			cmd.DeletedRows, err = countRows(session, `SELECT COUNT(*) AS count FROM annotation WHERE `+orphanedAnnotationType)
+			return err
+		})
+	}
+
+	batchSize := cmd.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultAnnotationCleanupBatchSize
+	}
+
+	deleteQuery := `DELETE FROM annotation WHERE id IN (SELECT id FROM (SELECT id FROM annotation WHERE %s ORDER BY id %s) a)`
+	query := func(limit int64) string {
+		return fmt.Sprintf(deleteQuery, orphanedAnnotationType, dialect.Limit(limit))
+	}
+
+	var err error
+	cmd.DeletedRows, err = executeUntilDoneOrCancelled(ctx, batchSize, query)
+	return err
+}
+
+// DeleteOrphanedAnnotationTags deletes, in batches, the annotation_tag rows of
+// deleted annotations and then, with cmd.PruneUnusedTags, the unused tags.
+func DeleteOrphanedAnnotationTags(ctx context.Context, cmd *models.DeleteOrphanedAnnotationTagsCommand) error {
+	if cmd.DryRun {
+		return withDbSession(ctx, func(session *DBSession) error {
+			var err error
+			cmd.DeletedAnnotationTags, err = countRows(session, `SELECT COUNT(*) AS count FROM annotation_tag WHERE `+orphanedAnnotationTag)
+			if err != nil || !cmd.PruneUnusedTags {
+				return err
+			}
+
+			cmd.DeletedTags, err = countRows(session, `SELECT COUNT(*) AS count FROM tag WHERE `+unusedTag)
+			return err
+		})
+	}
+
+	batchSize := cmd.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultAnnotationCleanupBatchSize
+	}
+
+	// annotation_tag has no id column, the rows are batched by annotation
+	deleteQuery := `DELETE FROM annotation_tag WHERE annotation_id IN (SELECT annotation_id FROM (SELECT annotation_id FROM annotation_tag WHERE %s ORDER BY annotation_id %s) t)`
+	query := func(limit int64) string {
+		return fmt.Sprintf(deleteQuery, orphanedAnnotationTag, dialect.Limit(limit))
+	}
+
+	var err error
+	cmd.DeletedAnnotationTags, err = executeUntilDoneOrCancelled(ctx, batchSize, query)
+	if err != nil || !cmd.PruneUnusedTags {
+		return err
+	}
+
+	deleteTagQuery := `DELETE FROM tag WHERE id IN (SELECT id FROM (SELECT id FROM tag WHERE %s ORDER BY id %s) t)`
+	query = func(limit int64) string {
+		return fmt.Sprintf(deleteTagQuery, unusedTag, dialect.Limit(limit))
+	}
+	cmd.DeletedTags, err = executeUntilDoneOrCancelled(ctx, batchSize, query)
+	return err
+}
+
+// executeUntilDoneOrCancelled runs the delete statement returned by query, which
+// deletes up to limit rows, until it doesn't affect any rows anymore or the
+// delete budget of ctx is used up, and returns the total number of deleted rows.
+// It pauses between the statements while the replicas lag behind.
+func executeUntilDoneOrCancelled(ctx context.Context, batchSize int64, query func(limit int64) string, args ...interface{}) (int64, error) {
+	budget := deleteBudgetFromContext(ctx)
+	var total int64
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return total, ctx.Err()
 		default:
+			limit := budget.limit(batchSize)
+			if limit <= 0 {
+				return total, nil
+			}
+
 			var affected int64
 			err := withDbSession(ctx, func(session *DBSession) error {
-				res, err := session.Exec(sql)
+				res, err := session.Exec(append([]interface{}{query(limit)}, args...)...)
 				if err != nil {
 					return err
 				}
@@ -76,11 +210,17 @@ func (acs *AnnotationCleanupService) executeUntilDoneOrCancelled(ctx context.Con
 				return err
 			})
 			if err != nil {
-				return err
+				return total, err
 			}
 
+			total += affected
+			budget.spend(affected)
 			if affected == 0 {
-				return nil
+				return total, nil
+			}
+
+			if err := deleteBackpressure.wait(ctx); err != nil {
+				return total, err
 			}
 		}
 	}
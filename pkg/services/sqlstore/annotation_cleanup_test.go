@@ -2,10 +2,13 @@ package sqlstore
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/grafana/grafana/pkg/components/simplejson"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/annotations"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/stretchr/testify/require"
@@ -43,6 +46,18 @@ func TestAnnotationCleanUp(t *testing.T) {
 			dashboardAnnotationCount: 7,
 			APIAnnotationCount:       7,
 		},
+		{
+			name: "dry run should not delete any annotations",
+			cfg: &setting.Cfg{
+				AlertingAnnotationCleanupSetting:   settingsFn(time.Hour*48, 3),
+				DashboardAnnotationCleanupSettings: settingsFn(time.Hour*48, 3),
+				APIAnnotationCleanupSettings:       settingsFn(time.Hour*48, 3),
+				CleanupDryRun:                      true,
+			},
+			alertAnnotationCount:     7,
+			dashboardAnnotationCount: 7,
+			APIAnnotationCount:       7,
+		},
 		{
 			name: "should remove annotations created before cut off point",
 			cfg: &setting.Cfg{
@@ -192,3 +207,115 @@ func createTestAnnotations(t *testing.T, sqlstore *SqlStore, expectedCount int,
 func settingsFn(maxAge time.Duration, maxCount int64) setting.AnnotationCleanupSettings {
 	return setting.AnnotationCleanupSettings{MaxAge: maxAge, MaxCount: maxCount}
 }
+
+func TestDeleteOrphanedAnnotations(t *testing.T) {
+	fakeSQL := InitTestDB(t)
+
+	saveCmd := models.SaveDashboardCommand{
+		OrgId: 1,
+		Dashboard: simplejson.NewFromAny(map[string]interface{}{
+			"title": "dashboard with annotations",
+		}),
+	}
+	require.NoError(t, SaveDashboard(&saveCmd))
+	dash := saveCmd.Result
+
+	session := fakeSQL.NewSession()
+	defer session.Close()
+
+	for _, dashboardID := range []int64{dash.Id, dash.Id + 100, dash.Id + 100, 0} {
+		_, err := session.Insert(&annotations.Item{OrgId: 1, DashboardId: dashboardID, Created: time.Now().UnixNano() / int64(time.Millisecond)})
+		require.NoError(t, err, "cannot insert annotation")
+	}
+
+	dryRunCmd := &models.CleanupAnnotationsCommand{DryRun: true}
+	require.NoError(t, DeleteOrphanedAnnotations(context.Background(), dryRunCmd))
+	require.Equal(t, int64(2), dryRunCmd.DeletedRows)
+	assertAnnotationCount(t, fakeSQL, "", 4)
+
+	cmd := &models.CleanupAnnotationsCommand{BatchSize: 1}
+	require.NoError(t, DeleteOrphanedAnnotations(context.Background(), cmd))
+	require.Equal(t, int64(2), cmd.DeletedRows)
+
+	assertAnnotationCount(t, fakeSQL, "", 2)
+	assertAnnotationCount(t, fakeSQL, fmt.Sprintf("dashboard_id = %d", dash.Id), 1)
+	assertAnnotationCount(t, fakeSQL, apiAnnotationType, 1)
+}
+
+func TestDeleteOrphanedAnnotationsWithDeleteBudget(t *testing.T) {
+	fakeSQL := InitTestDB(t)
+
+	session := fakeSQL.NewSession()
+	defer session.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := session.Insert(&annotations.Item{OrgId: 1, DashboardId: 1000, Created: time.Now().UnixNano() / int64(time.Millisecond)})
+		require.NoError(t, err, "cannot insert annotation")
+	}
+
+	ctx, budget := WithDeleteBudget(context.Background(), 3)
+	cmd := &models.CleanupAnnotationsCommand{BatchSize: 2}
+	require.NoError(t, DeleteOrphanedAnnotations(ctx, cmd))
+	require.Equal(t, int64(3), cmd.DeletedRows)
+	require.True(t, budget.Exhausted())
+	assertAnnotationCount(t, fakeSQL, "", 2)
+
+	// the next run deletes the rest
+	ctx, budget = WithDeleteBudget(context.Background(), 3)
+	cmd = &models.CleanupAnnotationsCommand{BatchSize: 2}
+	require.NoError(t, DeleteOrphanedAnnotations(ctx, cmd))
+	require.Equal(t, int64(2), cmd.DeletedRows)
+	require.False(t, budget.Exhausted())
+	assertAnnotationCount(t, fakeSQL, "", 0)
+}
+
+func TestDeleteOrphanedAnnotationTags(t *testing.T) {
+	fakeSQL := InitTestDB(t)
+
+	repo := SqlAnnotationRepo{}
+	kept := &annotations.Item{OrgId: 1, Tags: []string{"shared", "kept"}}
+	deleted := &annotations.Item{OrgId: 1, Tags: []string{"shared", "gone"}}
+	require.NoError(t, repo.Save(kept))
+	require.NoError(t, repo.Save(deleted))
+
+	session := fakeSQL.NewSession()
+	defer session.Close()
+
+	// the tag of an alert rule is kept without any annotation
+	alertTags, err := EnsureTagsExist(session, []*models.Tag{{Key: "alert"}})
+	require.NoError(t, err)
+	_, err = session.Exec("INSERT INTO alert_rule_tag (alert_id, tag_id) VALUES(?,?)", 1, alertTags[0].Id)
+	require.NoError(t, err)
+
+	// annotations deleted by the cleanup leave their tags behind
+	_, err = session.Exec("DELETE FROM annotation WHERE id = ?", deleted.Id)
+	require.NoError(t, err)
+
+	countRows := func(table string) int64 {
+		count, err := session.Table(table).Count()
+		require.NoError(t, err)
+		return count
+	}
+
+	dryRunCmd := &models.DeleteOrphanedAnnotationTagsCommand{DryRun: true, PruneUnusedTags: true}
+	require.NoError(t, DeleteOrphanedAnnotationTags(context.Background(), dryRunCmd))
+	require.Equal(t, int64(2), dryRunCmd.DeletedAnnotationTags)
+	require.Equal(t, int64(1), dryRunCmd.DeletedTags)
+	require.Equal(t, int64(4), countRows("annotation_tag"))
+
+	cmd := &models.DeleteOrphanedAnnotationTagsCommand{BatchSize: 1}
+	require.NoError(t, DeleteOrphanedAnnotationTags(context.Background(), cmd))
+	require.Equal(t, int64(2), cmd.DeletedAnnotationTags)
+	require.Zero(t, cmd.DeletedTags)
+	require.Equal(t, int64(2), countRows("annotation_tag"))
+	require.Equal(t, int64(4), countRows("tag"))
+
+	cmd = &models.DeleteOrphanedAnnotationTagsCommand{BatchSize: 1, PruneUnusedTags: true}
+	require.NoError(t, DeleteOrphanedAnnotationTags(context.Background(), cmd))
+	require.Zero(t, cmd.DeletedAnnotationTags)
+	require.Equal(t, int64(1), cmd.DeletedTags)
+
+	var keys []string
+	require.NoError(t, session.Table("tag").Cols("key").OrderBy("key").Find(&keys))
+	require.Equal(t, []string{"alert", "kept", "shared"}, keys)
+}
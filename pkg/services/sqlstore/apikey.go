@@ -14,6 +14,7 @@ func init() {
 	bus.AddHandler("sql", GetApiKeyByName)
 	bus.AddHandlerCtx("sql", DeleteApiKeyCtx)
 	bus.AddHandler("sql", AddApiKey)
+	bus.AddHandlerCtx("sql", DeleteExpiredAPIKeys)
 }
 
 func GetApiKeys(query *models.GetApiKeysQuery) error {
@@ -35,6 +36,26 @@ func DeleteApiKeyCtx(ctx context.Context, cmd *models.DeleteApiKeyCommand) error
 	})
 }
 
+// DeleteExpiredAPIKeys removes API keys which expired before cmd.ExpiredBefore.
+// Keys without expiry date are never removed.
+func DeleteExpiredAPIKeys(ctx context.Context, cmd *models.DeleteExpiredAPIKeysCommand) error {
+	return inTransactionCtx(ctx, func(sess *DBSession) error {
+		if cmd.DryRun {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM api_key WHERE expires IS NOT NULL AND expires < ?", cmd.ExpiredBefore.Unix())
+			return err
+		}
+
+		res, err := sess.Exec("DELETE FROM api_key WHERE expires IS NOT NULL AND expires < ?", cmd.ExpiredBefore.Unix())
+		if err != nil {
+			return err
+		}
+
+		cmd.DeletedRows, err = res.RowsAffected()
+		return err
+	})
+}
+
 func AddApiKey(cmd *models.AddApiKeyCommand) error {
 	return inTransaction(func(sess *DBSession) error {
 		key := models.ApiKey{OrgId: cmd.OrgId, Name: cmd.Name}
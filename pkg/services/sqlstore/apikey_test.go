@@ -1,11 +1,13 @@
 package sqlstore
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestApiKeyDataAccess(t *testing.T) {
@@ -116,6 +118,48 @@ func TestApiKeyDataAccess(t *testing.T) {
 	})
 }
 
+func TestDeleteExpiredAPIKeys(t *testing.T) {
+	InitTestDB(t)
+
+	now := time.Now()
+	for name, expires := range map[string]*int64{
+		"never-expires":     nil,
+		"expires-in-a-day":  int64Ptr(now.Add(time.Hour * 24).Unix()),
+		"expired-an-hour":   int64Ptr(now.Add(-time.Hour).Unix()),
+		"expired-last-week": int64Ptr(now.Add(-time.Hour * 24 * 7).Unix()),
+	} {
+		_, err := x.Insert(&models.ApiKey{OrgId: 1, Name: name, Key: name, Role: models.ROLE_VIEWER, Created: now, Updated: now, Expires: expires})
+		require.NoError(t, err)
+	}
+
+	t.Run("Dry run should only count keys expired before the cut off", func(t *testing.T) {
+		cmd := models.DeleteExpiredAPIKeysCommand{ExpiredBefore: now.Add(-time.Hour * 24), DryRun: true}
+		require.NoError(t, DeleteExpiredAPIKeys(context.Background(), &cmd))
+		assert.Equal(t, int64(1), cmd.DeletedRows)
+
+		query := models.GetApiKeysQuery{OrgId: 1, IncludeExpired: true}
+		require.NoError(t, GetApiKeys(&query))
+		assert.Len(t, query.Result, 4)
+	})
+
+	t.Run("Should keep recently expired and non expiring keys", func(t *testing.T) {
+		cmd := models.DeleteExpiredAPIKeysCommand{ExpiredBefore: now.Add(-time.Hour * 24)}
+		require.NoError(t, DeleteExpiredAPIKeys(context.Background(), &cmd))
+		assert.Equal(t, int64(1), cmd.DeletedRows)
+
+		query := models.GetApiKeysQuery{OrgId: 1, IncludeExpired: true}
+		require.NoError(t, GetApiKeys(&query))
+		assert.Len(t, query.Result, 3)
+		for _, key := range query.Result {
+			assert.NotEqual(t, "expired-last-week", key.Name)
+		}
+	})
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
 func TestApiKeyErrors(t *testing.T) {
 	mockTimeNow()
 	defer resetTimeNow()
@@ -0,0 +1,41 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+func init() {
+	bus.AddHandlerCtx("sql", DeleteExpiredCacheData)
+}
+
+const expiredCacheDataBatchSize = 1000
+
+// expiredCacheDataCondition matches the entries the database remote cache
+// no longer returns, it takes the current unix time as its argument.
+const expiredCacheDataCondition = "expires <> 0 AND ? - created_at >= expires"
+
+// DeleteExpiredCacheData deletes the expired entries of the database remote
+// cache in batches.
+func DeleteExpiredCacheData(ctx context.Context, cmd *models.DeleteExpiredCacheDataCommand) error {
+	now := cmd.Now.Unix()
+	if cmd.DryRun {
+		return withDbSession(ctx, func(sess *DBSession) error {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM cache_data WHERE "+expiredCacheDataCondition, now)
+			return err
+		})
+	}
+
+	query := func(limit int64) string {
+		return fmt.Sprintf("DELETE FROM cache_data WHERE cache_key IN (SELECT cache_key FROM (SELECT cache_key FROM cache_data WHERE %s ORDER BY cache_key %s) c)",
+			expiredCacheDataCondition, dialect.Limit(limit))
+	}
+
+	var err error
+	cmd.DeletedRows, err = executeUntilDoneOrCancelled(ctx, expiredCacheDataBatchSize, query, now)
+	return err
+}
@@ -0,0 +1,52 @@
+package sqlstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+func TestDeleteExpiredCacheData(t *testing.T) {
+	InitTestDB(t)
+
+	now := time.Now()
+	entries := []struct {
+		key       string
+		createdAt time.Time
+		expires   time.Duration
+	}{
+		{"expired", now.Add(-time.Hour), time.Minute},
+		{"expiring-now", now.Add(-time.Minute), time.Minute},
+		{"valid", now.Add(-time.Hour), time.Hour * 2},
+		{"never-expires", now.Add(-time.Hour * 24 * 365), 0},
+	}
+	for _, entry := range entries {
+		_, err := x.Exec("INSERT INTO cache_data (cache_key, data, expires, created_at) VALUES (?, ?, ?, ?)",
+			entry.key, []byte("data"), int64(entry.expires/time.Second), entry.createdAt.Unix())
+		require.NoError(t, err)
+	}
+
+	remaining := func() []string {
+		var keys []string
+		require.NoError(t, x.SQL("SELECT cache_key FROM cache_data ORDER BY cache_key").Find(&keys))
+		return keys
+	}
+
+	t.Run("Only counts the expired entries in dry run mode", func(t *testing.T) {
+		cmd := &models.DeleteExpiredCacheDataCommand{Now: now, DryRun: true}
+		require.NoError(t, DeleteExpiredCacheData(context.Background(), cmd))
+		require.Equal(t, int64(2), cmd.DeletedRows)
+		require.Len(t, remaining(), 4)
+	})
+
+	t.Run("Deletes the expired entries", func(t *testing.T) {
+		cmd := &models.DeleteExpiredCacheDataCommand{Now: now}
+		require.NoError(t, DeleteExpiredCacheData(context.Background(), cmd))
+		require.Equal(t, int64(2), cmd.DeletedRows)
+		require.Equal(t, []string{"never-expires", "valid"}, remaining())
+	})
+}
@@ -1,6 +1,9 @@
 package sqlstore
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/models"
 )
@@ -8,6 +11,40 @@ import (
 func init() {
 	bus.AddHandler("sql", UpdateDashboardAcl)
 	bus.AddHandler("sql", GetDashboardAclInfoList)
+	bus.AddHandlerCtx("sql", DeleteOrphanedDashboardAcl)
+}
+
+const orphanedDashboardAclBatchSize = 100
+
+// orphanedDashboardAclCondition matches the permissions of deleted dashboards,
+// users and teams. The default permissions of the Viewer and Editor roles have
+// a dashboard id of -1 and are never orphaned.
+func orphanedDashboardAclCondition() string {
+	return `dashboard_id <> -1 AND (
+		NOT EXISTS (SELECT 1 FROM dashboard WHERE dashboard.id = dashboard_acl.dashboard_id)
+		OR (user_id > 0 AND NOT EXISTS (SELECT 1 FROM ` + dialect.Quote("user") + ` u WHERE u.id = dashboard_acl.user_id))
+		OR (team_id > 0 AND NOT EXISTS (SELECT 1 FROM team WHERE team.id = dashboard_acl.team_id)))`
+}
+
+// DeleteOrphanedDashboardAcl deletes, in batches, the permissions that refer to
+// a dashboard, user or team that has been deleted.
+func DeleteOrphanedDashboardAcl(ctx context.Context, cmd *models.DeleteOrphanedDashboardAclCommand) error {
+	condition := orphanedDashboardAclCondition()
+	if cmd.DryRun {
+		return withDbSession(ctx, func(sess *DBSession) error {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM dashboard_acl WHERE "+condition)
+			return err
+		})
+	}
+
+	query := func(limit int64) string {
+		return fmt.Sprintf("DELETE FROM dashboard_acl WHERE id IN (SELECT id FROM (SELECT id FROM dashboard_acl WHERE %s ORDER BY id %s) a)", condition, dialect.Limit(limit))
+	}
+
+	var err error
+	cmd.DeletedRows, err = executeUntilDoneOrCancelled(ctx, orphanedDashboardAclBatchSize, query)
+	return err
 }
 
 func UpdateDashboardAcl(cmd *models.UpdateDashboardAclCommand) error {
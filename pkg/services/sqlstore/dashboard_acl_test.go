@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"context"
 	"testing"
 
 	"github.com/grafana/grafana/pkg/models"
@@ -240,3 +241,59 @@ func TestDashboardAclDataAccess(t *testing.T) {
 		})
 	})
 }
+
+func TestDeleteOrphanedDashboardAcl(t *testing.T) {
+	Convey("Testing deleting orphaned dashboard permissions", t, func() {
+		InitTestDB(t)
+
+		user := createUser("orphan", "Viewer", false)
+		deletedUser := createUser("deleted", "Viewer", false)
+		team := models.CreateTeamCommand{Name: "team", OrgId: 1}
+		So(CreateTeam(&team), ShouldBeNil)
+		deletedTeam := models.CreateTeamCommand{Name: "deleted team", OrgId: 1}
+		So(CreateTeam(&deletedTeam), ShouldBeNil)
+
+		dash := insertTestDashboard("kept dash", 1, 0, false)
+		deletedDash := insertTestDashboard("deleted dash", 1, 0, false)
+
+		err := testHelperUpdateDashboardAcl(dash.Id,
+			models.DashboardAcl{OrgId: 1, DashboardId: dash.Id, UserId: user.Id, Permission: models.PERMISSION_EDIT},
+			models.DashboardAcl{OrgId: 1, DashboardId: dash.Id, UserId: deletedUser.Id, Permission: models.PERMISSION_EDIT},
+			models.DashboardAcl{OrgId: 1, DashboardId: dash.Id, TeamId: team.Result.Id, Permission: models.PERMISSION_EDIT},
+			models.DashboardAcl{OrgId: 1, DashboardId: dash.Id, TeamId: deletedTeam.Result.Id, Permission: models.PERMISSION_EDIT},
+		)
+		So(err, ShouldBeNil)
+		err = testHelperUpdateDashboardAcl(deletedDash.Id,
+			models.DashboardAcl{OrgId: 1, DashboardId: deletedDash.Id, UserId: user.Id, Permission: models.PERMISSION_EDIT},
+		)
+		So(err, ShouldBeNil)
+
+		// bypass the regular deletes, which clean up the permissions themselves
+		_, err = x.Exec("DELETE FROM "+dialect.Quote("user")+" WHERE id = ?", deletedUser.Id)
+		So(err, ShouldBeNil)
+		_, err = x.Exec("DELETE FROM team WHERE id = ?", deletedTeam.Result.Id)
+		So(err, ShouldBeNil)
+		_, err = x.Exec("DELETE FROM dashboard WHERE id = ?", deletedDash.Id)
+		So(err, ShouldBeNil)
+
+		dryRun := models.DeleteOrphanedDashboardAclCommand{DryRun: true}
+		err = DeleteOrphanedDashboardAcl(context.Background(), &dryRun)
+		So(err, ShouldBeNil)
+		So(dryRun.DeletedRows, ShouldEqual, 3)
+
+		cmd := models.DeleteOrphanedDashboardAclCommand{}
+		err = DeleteOrphanedDashboardAcl(context.Background(), &cmd)
+		So(err, ShouldBeNil)
+		So(cmd.DeletedRows, ShouldEqual, 3)
+
+		// the default permissions are kept along with the valid ones
+		query := models.GetDashboardAclInfoListQuery{DashboardId: dash.Id, OrgId: 1}
+		err = GetDashboardAclInfoList(&query)
+		So(err, ShouldBeNil)
+		So(len(query.Result), ShouldEqual, 2)
+
+		count, err := x.Table("dashboard_acl").Where("dashboard_id = -1").Count()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+	})
+}
@@ -1,6 +1,10 @@
 package sqlstore
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/models"
 )
@@ -10,8 +14,12 @@ func init() {
 	bus.AddHandler("sql", SaveProvisionedDashboard)
 	bus.AddHandler("sql", GetProvisionedDataByDashboardId)
 	bus.AddHandler("sql", UnprovisionDashboard)
+	bus.AddHandlerCtx("sql", GetDashboardProvisionerNames)
+	bus.AddHandlerCtx("sql", DeleteStaleDashboardProvisioning)
 }
 
+const staleDashboardProvisioningBatchSize = 100
+
 type DashboardExtras struct {
 	Id          int64
 	DashboardId int64
@@ -88,3 +96,50 @@ func UnprovisionDashboard(cmd *models.UnprovisionDashboardCommand) error {
 	}
 	return nil
 }
+
+// GetDashboardProvisionerNames returns the distinct provisioner names of the
+// dashboard_provisioning table.
+func GetDashboardProvisionerNames(ctx context.Context, query *models.GetDashboardProvisionerNamesQuery) error {
+	return withDbSession(ctx, func(sess *DBSession) error {
+		query.Result = make([]string, 0)
+		return sess.Table("dashboard_provisioning").Distinct("name").OrderBy("name").Find(&query.Result)
+	})
+}
+
+// staleDashboardProvisioningCondition matches the provisioning records of
+// deleted dashboards and of the unknown provisioners.
+func staleDashboardProvisioningCondition(unknownProvisioners []string) (string, []interface{}) {
+	condition := "NOT EXISTS (SELECT 1 FROM dashboard WHERE dashboard.id = dashboard_provisioning.dashboard_id)"
+	if len(unknownProvisioners) == 0 {
+		return condition, nil
+	}
+
+	args := make([]interface{}, 0, len(unknownProvisioners))
+	for _, name := range unknownProvisioners {
+		args = append(args, name)
+	}
+
+	return condition + " OR name IN (?" + strings.Repeat(",?", len(unknownProvisioners)-1) + ")", args
+}
+
+// DeleteStaleDashboardProvisioning deletes, in batches, the provisioning records
+// of dashboards that have been deleted and of the provisioners that are no
+// longer configured.
+func DeleteStaleDashboardProvisioning(ctx context.Context, cmd *models.DeleteStaleDashboardProvisioningCommand) error {
+	condition, args := staleDashboardProvisioningCondition(cmd.UnknownProvisioners)
+	if cmd.DryRun {
+		return withDbSession(ctx, func(sess *DBSession) error {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM dashboard_provisioning WHERE "+condition, args...)
+			return err
+		})
+	}
+
+	query := func(limit int64) string {
+		return fmt.Sprintf("DELETE FROM dashboard_provisioning WHERE id IN (SELECT id FROM (SELECT id FROM dashboard_provisioning WHERE %s ORDER BY id %s) p)", condition, dialect.Limit(limit))
+	}
+
+	var err error
+	cmd.DeletedRows, err = executeUntilDoneOrCancelled(ctx, staleDashboardProvisioningBatchSize, query, args...)
+	return err
+}
@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -109,6 +110,64 @@ func TestDashboardProvisioningTest(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(query.Result, ShouldBeNil)
 			})
+
+			Convey("Given provisioning metadata of deleted dashboards and removed provisioners", func() {
+				_, err := x.Insert(&models.DashboardProvisioning{DashboardId: 3000, Name: "default", ExternalId: "/var/deleted.json"})
+				So(err, ShouldBeNil)
+				otherDashboardCmd := &models.SaveDashboardCommand{
+					OrgId: 1,
+					Dashboard: simplejson.NewFromAny(map[string]interface{}{
+						"id":    nil,
+						"title": "removed provisioner dashboard",
+					}),
+				}
+				err = SaveProvisionedDashboard(&models.SaveProvisionedDashboardCommand{
+					DashboardCmd:          otherDashboardCmd,
+					DashboardProvisioning: &models.DashboardProvisioning{Name: "removed", ExternalId: "/var/removed.json"},
+				})
+				So(err, ShouldBeNil)
+
+				Convey("Can query for the provisioner names", func() {
+					query := &models.GetDashboardProvisionerNamesQuery{}
+					So(GetDashboardProvisionerNames(context.Background(), query), ShouldBeNil)
+					So(query.Result, ShouldResemble, []string{"default", "removed"})
+				})
+
+				Convey("Only counts stale provisioning metadata in dry run mode", func() {
+					cmd := &models.DeleteStaleDashboardProvisioningCommand{DryRun: true}
+					So(DeleteStaleDashboardProvisioning(context.Background(), cmd), ShouldBeNil)
+					So(cmd.DeletedRows, ShouldEqual, 1)
+
+					count, err := x.Count(&models.DashboardProvisioning{})
+					So(err, ShouldBeNil)
+					So(count, ShouldEqual, 3)
+				})
+
+				Convey("Deletes the provisioning metadata of deleted dashboards", func() {
+					cmd := &models.DeleteStaleDashboardProvisioningCommand{}
+					So(DeleteStaleDashboardProvisioning(context.Background(), cmd), ShouldBeNil)
+					So(cmd.DeletedRows, ShouldEqual, 1)
+
+					query := &models.GetProvisionedDashboardDataQuery{Name: "default"}
+					So(GetProvisionedDashboardDataQuery(query), ShouldBeNil)
+					So(len(query.Result), ShouldEqual, 1)
+					So(query.Result[0].DashboardId, ShouldEqual, dashId)
+				})
+
+				Convey("Deletes the provisioning metadata of unknown provisioners", func() {
+					cmd := &models.DeleteStaleDashboardProvisioningCommand{UnknownProvisioners: []string{"removed"}}
+					So(DeleteStaleDashboardProvisioning(context.Background(), cmd), ShouldBeNil)
+					So(cmd.DeletedRows, ShouldEqual, 2)
+
+					query := &models.GetProvisionedDashboardDataQuery{Name: "removed"}
+					So(GetProvisionedDashboardDataQuery(query), ShouldBeNil)
+					So(query.Result, ShouldBeEmpty)
+
+					// the dashboard itself is kept
+					dashQuery := &models.GetDashboardQuery{Id: otherDashboardCmd.Result.Id, OrgId: 1}
+					So(GetDashboard(dashQuery), ShouldBeNil)
+				})
+			})
 		})
 	})
 }
@@ -1,6 +1,9 @@
 package sqlstore
 
 import (
+	"context"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana/pkg/bus"
@@ -13,21 +16,37 @@ func init() {
 	bus.AddHandler("sql", GetDashboardSnapshot)
 	bus.AddHandler("sql", DeleteDashboardSnapshot)
 	bus.AddHandler("sql", SearchDashboardSnapshots)
-	bus.AddHandler("sql", DeleteExpiredSnapshots)
+	bus.AddHandlerCtx("sql", DeleteExpiredSnapshots)
+	bus.AddHandlerCtx("sql", TrimSnapshotsOverLimit)
+	bus.AddHandler("sql", GetExpiredExternalSnapshots)
 }
 
 // DeleteExpiredSnapshots removes snapshots with old expiry dates.
 // SnapShotRemoveExpired is deprecated and should be removed in the future.
 // Snapshot expiry is decided by the user when they share the snapshot.
-func DeleteExpiredSnapshots(cmd *models.DeleteExpiredSnapshotsCommand) error {
-	return inTransaction(func(sess *DBSession) error {
+func DeleteExpiredSnapshots(ctx context.Context, cmd *models.DeleteExpiredSnapshotsCommand) error {
+	return inTransactionCtx(ctx, func(sess *DBSession) error {
 		if !setting.SnapShotRemoveExpired {
 			sqlog.Warn("[Deprecated] The snapshot_remove_expired setting is outdated. Please remove from your config.")
 			return nil
 		}
 
-		deleteExpiredSql := "DELETE FROM dashboard_snapshot WHERE expires < ?"
-		expiredResponse, err := sess.Exec(deleteExpiredSql, time.Now())
+		where, args := expiredSnapshotsWhere(cmd.Now, cmd.OrgMaxAge)
+		if len(cmd.KeepIds) > 0 {
+			where += " AND id NOT IN (?" + strings.Repeat(",?", len(cmd.KeepIds)-1) + ")"
+			for _, id := range cmd.KeepIds {
+				args = append(args, id)
+			}
+		}
+
+		if cmd.DryRun {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM dashboard_snapshot WHERE "+where, args...)
+			return err
+		}
+
+		deleteExpiredSql := "DELETE FROM dashboard_snapshot WHERE " + where
+		expiredResponse, err := sess.Exec(append([]interface{}{deleteExpiredSql}, args...)...)
 		if err != nil {
 			return err
 		}
@@ -37,6 +56,76 @@ func DeleteExpiredSnapshots(cmd *models.DeleteExpiredSnapshotsCommand) error {
 	})
 }
 
+// TrimSnapshotsOverLimit deletes the oldest snapshots of every org above the
+// limit, until it's back at the limit.
+func TrimSnapshotsOverLimit(ctx context.Context, cmd *models.TrimSnapshotsOverLimitCommand) error {
+	cmd.DeletedRows = 0
+	cmd.OrgDeletedRows = map[int64]int64{}
+	if cmd.Limit < 0 {
+		return nil
+	}
+
+	return inTransactionCtx(ctx, func(sess *DBSession) error {
+		var counts []struct {
+			OrgId int64
+			Count int64
+		}
+		if err := sess.SQL("SELECT org_id, COUNT(*) AS count FROM dashboard_snapshot GROUP BY org_id HAVING COUNT(*) > ? ORDER BY org_id", cmd.Limit).Find(&counts); err != nil {
+			return err
+		}
+
+		for _, org := range counts {
+			deleted := org.Count - cmd.Limit
+			if !cmd.DryRun {
+				res, err := sess.Exec("DELETE FROM dashboard_snapshot WHERE id IN (SELECT id FROM (SELECT id FROM dashboard_snapshot WHERE org_id = ? ORDER BY created, id "+
+					dialect.Limit(deleted)+") t)", org.OrgId)
+				if err != nil {
+					return err
+				}
+				if affected, err := res.RowsAffected(); err == nil {
+					deleted = affected
+				}
+			}
+
+			cmd.OrgDeletedRows[org.OrgId] = deleted
+			cmd.DeletedRows += deleted
+		}
+
+		return nil
+	})
+}
+
+// expiredSnapshotsWhere matches the snapshots that expired at now, as well as
+// the snapshots of the orgs in orgMaxAge that are older than their org's max age.
+func expiredSnapshotsWhere(now time.Time, orgMaxAge map[int64]time.Duration) (string, []interface{}) {
+	where := "(expires < ?"
+	args := []interface{}{now}
+
+	orgIDs := make([]int64, 0, len(orgMaxAge))
+	for orgID := range orgMaxAge {
+		orgIDs = append(orgIDs, orgID)
+	}
+	sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+	for _, orgID := range orgIDs {
+		where += " OR (org_id = ? AND created < ?)"
+		args = append(args, orgID, now.Add(-orgMaxAge[orgID]))
+	}
+
+	return where + ")", args
+}
+
+// GetExpiredExternalSnapshots returns the expired snapshots that have a copy on
+// an external snapshot server, which has to be deleted as well.
+func GetExpiredExternalSnapshots(query *models.GetExpiredExternalSnapshotsQuery) error {
+	where, args := expiredSnapshotsWhere(query.Now, query.OrgMaxAge)
+	args = append(args, true)
+
+	query.Result = make([]*models.DashboardSnapshot, 0)
+	return x.Cols("id", "external_delete_url").
+		Where(where+" AND external = ? AND external_delete_url <> ''", args...).
+		Find(&query.Result)
+}
+
 func CreateDashboardSnapshot(cmd *models.CreateDashboardSnapshotCommand) error {
 	return inTransaction(func(sess *DBSession) error {
 		// never
@@ -1,6 +1,8 @@
 package sqlstore
 
 import (
+	"context"
+	"sort"
 	"testing"
 	"time"
 
@@ -117,7 +119,12 @@ func TestDeleteExpiredSnapshots(t *testing.T) {
 		createTestSnapshot(sqlstore, "key2", -1200)
 		createTestSnapshot(sqlstore, "key3", -1200)
 
-		err := DeleteExpiredSnapshots(&models.DeleteExpiredSnapshotsCommand{})
+		dryRunCmd := models.DeleteExpiredSnapshotsCommand{Now: time.Now(), DryRun: true}
+		err := DeleteExpiredSnapshots(context.Background(), &dryRunCmd)
+		So(err, ShouldBeNil)
+		So(dryRunCmd.DeletedRows, ShouldEqual, 2)
+
+		err = DeleteExpiredSnapshots(context.Background(), &models.DeleteExpiredSnapshotsCommand{Now: time.Now()})
 		So(err, ShouldBeNil)
 
 		query := models.GetDashboardSnapshotsQuery{
@@ -130,7 +137,7 @@ func TestDeleteExpiredSnapshots(t *testing.T) {
 		So(len(query.Result), ShouldEqual, 1)
 		So(query.Result[0].Key, ShouldEqual, notExpiredsnapshot.Key)
 
-		err = DeleteExpiredSnapshots(&models.DeleteExpiredSnapshotsCommand{})
+		err = DeleteExpiredSnapshots(context.Background(), &models.DeleteExpiredSnapshotsCommand{Now: time.Now()})
 		So(err, ShouldBeNil)
 
 		query = models.GetDashboardSnapshotsQuery{
@@ -145,6 +152,101 @@ func TestDeleteExpiredSnapshots(t *testing.T) {
 	})
 }
 
+func TestDeleteSnapshotsOlderThanOrgMaxAge(t *testing.T) {
+	sqlstore := InitTestDB(t)
+
+	Convey("Testing dashboard snapshots clean up with org max age", t, func() {
+		setting.SnapShotRemoveExpired = true
+
+		tenDaysAgo := time.Now().Add(-time.Hour * 24 * 10)
+		oldSnapshot := createTestSnapshot(sqlstore, "old", 0)
+		recentSnapshot := createTestSnapshot(sqlstore, "recent", 0)
+		otherOrgSnapshot := createTestSnapshot(sqlstore, "other-org", 0)
+		_, err := sqlstore.engine.Exec("UPDATE dashboard_snapshot SET created = ? WHERE id IN (?, ?)", tenDaysAgo, oldSnapshot.Id, otherOrgSnapshot.Id)
+		So(err, ShouldBeNil)
+		_, err = sqlstore.engine.Exec("UPDATE dashboard_snapshot SET org_id = 2 WHERE id = ?", otherOrgSnapshot.Id)
+		So(err, ShouldBeNil)
+
+		cmd := models.DeleteExpiredSnapshotsCommand{Now: time.Now(), OrgMaxAge: map[int64]time.Duration{1: time.Hour * 24 * 7}}
+		err = DeleteExpiredSnapshots(context.Background(), &cmd)
+		So(err, ShouldBeNil)
+		So(cmd.DeletedRows, ShouldEqual, 1)
+
+		query := models.GetDashboardSnapshotsQuery{
+			OrgId:        1,
+			SignedInUser: &models.SignedInUser{OrgRole: models.ROLE_ADMIN},
+		}
+		err = SearchDashboardSnapshots(&query)
+		So(err, ShouldBeNil)
+		So(len(query.Result), ShouldEqual, 1)
+		So(query.Result[0].Key, ShouldEqual, recentSnapshot.Key)
+
+		// orgs without max age only lose expired snapshots
+		query = models.GetDashboardSnapshotsQuery{
+			OrgId:        2,
+			SignedInUser: &models.SignedInUser{OrgRole: models.ROLE_ADMIN},
+		}
+		err = SearchDashboardSnapshots(&query)
+		So(err, ShouldBeNil)
+		So(len(query.Result), ShouldEqual, 1)
+	})
+}
+
+func TestTrimSnapshotsOverLimit(t *testing.T) {
+	Convey("Testing dashboard snapshots clean up over the limit per org", t, func() {
+		sqlstore := InitTestDB(t)
+
+		// org 1 has four snapshots, "oldest" being the oldest, which is over the limit of 2
+		for i, key := range []string{"oldest", "old", "recent", "newest"} {
+			snapshot := createTestSnapshot(sqlstore, key, 0)
+			_, err := sqlstore.engine.Exec("UPDATE dashboard_snapshot SET created = ? WHERE id = ?", time.Now().Add(-time.Duration(4-i)*time.Hour), snapshot.Id)
+			So(err, ShouldBeNil)
+		}
+		// org 2 has two snapshots, which is within the limit
+		for _, key := range []string{"other-org-1", "other-org-2"} {
+			snapshot := createTestSnapshot(sqlstore, key, 0)
+			_, err := sqlstore.engine.Exec("UPDATE dashboard_snapshot SET org_id = 2 WHERE id = ?", snapshot.Id)
+			So(err, ShouldBeNil)
+		}
+
+		snapshotKeys := func(orgID int64) []string {
+			query := models.GetDashboardSnapshotsQuery{OrgId: orgID, SignedInUser: &models.SignedInUser{OrgRole: models.ROLE_ADMIN}}
+			So(SearchDashboardSnapshots(&query), ShouldBeNil)
+			var keys []string
+			for _, snapshot := range query.Result {
+				keys = append(keys, snapshot.Key)
+			}
+			sort.Strings(keys)
+			return keys
+		}
+
+		Convey("Only counts the snapshots over the limit in dry run mode", func() {
+			cmd := models.TrimSnapshotsOverLimitCommand{Limit: 2, DryRun: true}
+			So(TrimSnapshotsOverLimit(context.Background(), &cmd), ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, 2)
+			So(cmd.OrgDeletedRows, ShouldResemble, map[int64]int64{1: 2})
+			So(snapshotKeys(1), ShouldHaveLength, 4)
+		})
+
+		Convey("Deletes the oldest snapshots of orgs over the limit", func() {
+			cmd := models.TrimSnapshotsOverLimitCommand{Limit: 2}
+			So(TrimSnapshotsOverLimit(context.Background(), &cmd), ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, 2)
+			So(cmd.OrgDeletedRows, ShouldResemble, map[int64]int64{1: 2})
+			So(snapshotKeys(1), ShouldResemble, []string{"newest", "recent"})
+			So(snapshotKeys(2), ShouldResemble, []string{"other-org-1", "other-org-2"})
+		})
+
+		Convey("Keeps all snapshots without a limit", func() {
+			cmd := models.TrimSnapshotsOverLimitCommand{Limit: -1}
+			So(TrimSnapshotsOverLimit(context.Background(), &cmd), ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, 0)
+			So(snapshotKeys(1), ShouldHaveLength, 4)
+			So(snapshotKeys(2), ShouldHaveLength, 2)
+		})
+	})
+}
+
 func createTestSnapshot(sqlstore *SqlStore, key string, expires int64) *models.DashboardSnapshot {
 	cmd := models.CreateDashboardSnapshotCommand{
 		Key:       key,
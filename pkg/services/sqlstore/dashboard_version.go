@@ -1,7 +1,10 @@
 package sqlstore
 
 import (
+	"context"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/models"
@@ -11,7 +14,7 @@ import (
 func init() {
 	bus.AddHandler("sql", GetDashboardVersion)
 	bus.AddHandler("sql", GetDashboardVersions)
-	bus.AddHandler("sql", DeleteExpiredVersions)
+	bus.AddHandlerCtx("sql", DeleteExpiredVersions)
 }
 
 // GetDashboardVersion gets the dashboard version for the given dashboard ID and version number.
@@ -67,39 +70,107 @@ func GetDashboardVersions(query *models.GetDashboardVersionsQuery) error {
 	return nil
 }
 
+// expiredVersionsFromClause selects the versions of each dashboard exceeding the number of versions to keep.
+// The clause ends with the subtraction of the versions to keep, see orgVersionsToKeepExpr.
+const expiredVersionsFromClause = `FROM dashboard_version, (
+					SELECT dashboard_id, count(version) as count, min(version) as min
+					FROM dashboard_version
+					GROUP BY dashboard_id
+				) AS vtd
+				LEFT JOIN dashboard ON dashboard.id=vtd.dashboard_id
+				WHERE dashboard_version.dashboard_id=vtd.dashboard_id
+				AND dashboard_version.version < vtd.min + vtd.count - `
+
+// orgVersionsToKeepExpr returns the number of versions to keep per dashboard,
+// which is versionsToKeep unless its org has an override. Versions of deleted
+// dashboards have no org and fall back to versionsToKeep as well.
+func orgVersionsToKeepExpr(versionsToKeep int, orgVersionsToKeep map[int64]int, minVersionsToKeep int) (string, []interface{}) {
+	if len(orgVersionsToKeep) == 0 {
+		return "?", []interface{}{versionsToKeep}
+	}
+
+	orgIDs := make([]int64, 0, len(orgVersionsToKeep))
+	for orgID := range orgVersionsToKeep {
+		orgIDs = append(orgIDs, orgID)
+	}
+	sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+
+	expr := "CASE dashboard.org_id"
+	var args []interface{}
+	for _, orgID := range orgIDs {
+		keep := orgVersionsToKeep[orgID]
+		if keep < minVersionsToKeep {
+			keep = minVersionsToKeep
+		}
+		expr += " WHEN ? THEN ?"
+		args = append(args, orgID, keep)
+	}
+
+	return expr + " ELSE ? END", append(args, versionsToKeep)
+}
+
 const MAX_VERSIONS_TO_DELETE_PER_BATCH = 100
 const MAX_VERSION_DELETION_BATCHES = 50
 
-func DeleteExpiredVersions(cmd *models.DeleteExpiredVersionsCommand) error {
-	return deleteExpiredVersions(cmd, MAX_VERSIONS_TO_DELETE_PER_BATCH, MAX_VERSION_DELETION_BATCHES)
+func DeleteExpiredVersions(ctx context.Context, cmd *models.DeleteExpiredVersionsCommand) error {
+	perBatch := cmd.BatchSize
+	if perBatch < 1 {
+		perBatch = MAX_VERSIONS_TO_DELETE_PER_BATCH
+	}
+
+	return deleteExpiredVersions(ctx, cmd, perBatch, MAX_VERSION_DELETION_BATCHES)
 }
 
-func deleteExpiredVersions(cmd *models.DeleteExpiredVersionsCommand, perBatch int, maxBatches int) error {
+// deleteExpiredVersions deletes up to maxBatches batches of perBatch versions,
+// stopping early when ctx is cancelled between batches or its delete budget is used up.
+func deleteExpiredVersions(ctx context.Context, cmd *models.DeleteExpiredVersionsCommand, perBatch int, maxBatches int) error {
+	minVersionsToKeep := cmd.MinVersionsToKeep
+	if minVersionsToKeep < 1 {
+		minVersionsToKeep = 1
+	}
+
+	// the version formula of expiredVersionsFromClause is evaluated per dashboard,
+	// so no dashboard is reduced below the floor
 	versionsToKeep := setting.DashboardVersionsToKeep
-	if versionsToKeep < 1 {
-		versionsToKeep = 1
+	if versionsToKeep < minVersionsToKeep {
+		versionsToKeep = minVersionsToKeep
 	}
 
+	keepExpr, keepArgs := orgVersionsToKeepExpr(versionsToKeep, cmd.OrgVersionsToKeep, minVersionsToKeep)
+	fromClause := expiredVersionsFromClause + keepExpr
+
+	if cmd.DryRun {
+		return countExpiredVersions(ctx, cmd, fromClause, keepArgs, int64(perBatch*maxBatches))
+	}
+
+	budget := deleteBudgetFromContext(ctx)
+	progress := newDeleteProgress("dashboard_version", func() (count int64, err error) {
+		err = withDbSession(ctx, func(sess *DBSession) error {
+			count, err = countRows(sess, `SELECT COUNT(*) AS count `+fromClause, keepArgs...)
+			return err
+		})
+		return count, err
+	})
 	for batch := 0; batch < maxBatches; batch++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		limit := budget.limit(int64(perBatch))
+		if limit <= 0 {
+			break
+		}
+
 		deleted := int64(0)
 
-		batchErr := inTransaction(func(sess *DBSession) error {
+		batchErr := inTransactionCtx(ctx, func(sess *DBSession) error {
 			// Idea of this query is finding version IDs to delete based on formula:
 			// min_version_to_keep = min_version + (versions_count - versions_to_keep)
 			// where version stats is processed for each dashboard. This guarantees that we keep at least versions_to_keep
 			// versions, but in some cases (when versions are sparse) this number may be more.
-			versionIdsToDeleteQuery := `SELECT id
-				FROM dashboard_version, (
-					SELECT dashboard_id, count(version) as count, min(version) as min
-					FROM dashboard_version
-					GROUP BY dashboard_id
-				) AS vtd
-				WHERE dashboard_version.dashboard_id=vtd.dashboard_id
-				AND version < vtd.min + vtd.count - ?
-				LIMIT ?`
+			versionIdsToDeleteQuery := `SELECT dashboard_version.id ` + fromClause + ` LIMIT ?`
 
 			var versionIdsToDelete []interface{}
-			err := sess.SQL(versionIdsToDeleteQuery, versionsToKeep, perBatch).Find(&versionIdsToDelete)
+			err := sess.SQL(versionIdsToDeleteQuery, append(keepArgs, limit)...).Find(&versionIdsToDelete)
 			if err != nil {
 				return err
 			}
@@ -124,11 +195,42 @@ func deleteExpiredVersions(cmd *models.DeleteExpiredVersionsCommand, perBatch in
 		}
 
 		cmd.DeletedRows += deleted
+		budget.spend(deleted)
+		progress.batch(deleted)
 
-		if deleted < int64(perBatch) {
+		if deleted < limit {
 			break
 		}
+
+		if err := deleteBackpressure.wait(ctx); err != nil {
+			return err
+		}
+
+		if cmd.BatchDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(cmd.BatchDelay):
+			}
+		}
 	}
 
 	return nil
 }
+
+// countExpiredVersions counts the versions a single run of deleteExpiredVersions would delete.
+func countExpiredVersions(ctx context.Context, cmd *models.DeleteExpiredVersionsCommand, fromClause string, args []interface{}, maxRows int64) error {
+	return inTransactionCtx(ctx, func(sess *DBSession) error {
+		count, err := countRows(sess, `SELECT COUNT(*) AS count `+fromClause, args...)
+		if err != nil {
+			return err
+		}
+
+		if count > maxRows {
+			count = maxRows
+		}
+		cmd.DeletedRows = count
+
+		return nil
+	})
+}
@@ -1,8 +1,10 @@
 package sqlstore
 
 import (
+	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 
@@ -118,7 +120,7 @@ func TestDeleteExpiredVersions(t *testing.T) {
 		}
 
 		Convey("Clean up old dashboard versions", func() {
-			err := DeleteExpiredVersions(&models.DeleteExpiredVersionsCommand{})
+			err := DeleteExpiredVersions(context.Background(), &models.DeleteExpiredVersionsCommand{})
 			So(err, ShouldBeNil)
 
 			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1}
@@ -131,10 +133,118 @@ func TestDeleteExpiredVersions(t *testing.T) {
 			So(query.Result[0].Version, ShouldEqual, versionsToWrite)
 		})
 
+		Convey("Clean up old dashboard versions in several batches", func() {
+			cmd := models.DeleteExpiredVersionsCommand{BatchSize: 2, BatchDelay: time.Millisecond}
+			err := DeleteExpiredVersions(context.Background(), &cmd)
+			So(err, ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, versionsToWrite-versionsToKeep)
+
+			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1}
+			err = GetDashboardVersions(&query)
+			So(err, ShouldBeNil)
+
+			So(len(query.Result), ShouldEqual, versionsToKeep)
+		})
+
+		Convey("Stop cleaning up old dashboard versions when cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			cmd := models.DeleteExpiredVersionsCommand{BatchSize: 2}
+			err := DeleteExpiredVersions(ctx, &cmd)
+			So(err, ShouldEqual, context.Canceled)
+			So(cmd.DeletedRows, ShouldEqual, 0)
+
+			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1, Limit: versionsToWrite}
+			err = GetDashboardVersions(&query)
+			So(err, ShouldBeNil)
+
+			So(len(query.Result), ShouldEqual, versionsToWrite)
+		})
+
+		Convey("Don't delete more old dashboard versions than the delete budget allows", func() {
+			ctx, budget := WithDeleteBudget(context.Background(), 3)
+			cmd := models.DeleteExpiredVersionsCommand{BatchSize: 2}
+			err := DeleteExpiredVersions(ctx, &cmd)
+			So(err, ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, 3)
+			So(budget.Exhausted(), ShouldBeTrue)
+
+			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1, Limit: versionsToWrite}
+			err = GetDashboardVersions(&query)
+			So(err, ShouldBeNil)
+			So(len(query.Result), ShouldEqual, versionsToWrite-3)
+			So(query.Result[0].Version, ShouldEqual, versionsToWrite)
+		})
+
+		Convey("Only count old dashboard versions in dry run mode", func() {
+			cmd := models.DeleteExpiredVersionsCommand{DryRun: true}
+			err := DeleteExpiredVersions(context.Background(), &cmd)
+			So(err, ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, versionsToWrite-versionsToKeep)
+
+			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1, Limit: versionsToWrite}
+			err = GetDashboardVersions(&query)
+			So(err, ShouldBeNil)
+
+			So(len(query.Result), ShouldEqual, versionsToWrite)
+		})
+
+		Convey("Never keep less than the minimum number of versions", func() {
+			setting.DashboardVersionsToKeep = 2
+			minVersionsToKeep := 7
+
+			err := DeleteExpiredVersions(context.Background(), &models.DeleteExpiredVersionsCommand{MinVersionsToKeep: minVersionsToKeep})
+			So(err, ShouldBeNil)
+
+			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1, Limit: versionsToWrite}
+			err = GetDashboardVersions(&query)
+			So(err, ShouldBeNil)
+
+			So(len(query.Result), ShouldEqual, minVersionsToKeep)
+			So(query.Result[0].Version, ShouldEqual, versionsToWrite)
+		})
+
+		Convey("Keep the overridden number of versions for the dashboards of an org", func() {
+			otherOrgDash := insertTestDashboard("test dash 54", 2, 0, false, "diff-all")
+			for i := 0; i < versionsToWrite-1; i++ {
+				updateTestDashboard(otherOrgDash, map[string]interface{}{
+					"tags": "different-tag",
+				})
+			}
+
+			cmd := models.DeleteExpiredVersionsCommand{OrgVersionsToKeep: map[int64]int{2: 8, 3: 1}}
+			err := DeleteExpiredVersions(context.Background(), &cmd)
+			So(err, ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, versionsToWrite-versionsToKeep+versionsToWrite-8)
+
+			// orgs without override fall back to versions_to_keep
+			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1, Limit: versionsToWrite}
+			err = GetDashboardVersions(&query)
+			So(err, ShouldBeNil)
+			So(len(query.Result), ShouldEqual, versionsToKeep)
+
+			query = models.GetDashboardVersionsQuery{DashboardId: otherOrgDash.Id, OrgId: 2, Limit: versionsToWrite}
+			err = GetDashboardVersions(&query)
+			So(err, ShouldBeNil)
+			So(len(query.Result), ShouldEqual, 8)
+			So(query.Result[0].Version, ShouldEqual, versionsToWrite)
+		})
+
+		Convey("Never keep less than the minimum number of versions for an org override", func() {
+			err := DeleteExpiredVersions(context.Background(), &models.DeleteExpiredVersionsCommand{MinVersionsToKeep: 3, OrgVersionsToKeep: map[int64]int{1: 1}})
+			So(err, ShouldBeNil)
+
+			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1, Limit: versionsToWrite}
+			err = GetDashboardVersions(&query)
+			So(err, ShouldBeNil)
+			So(len(query.Result), ShouldEqual, 3)
+		})
+
 		Convey("Don't delete anything if there are no expired versions", func() {
 			setting.DashboardVersionsToKeep = versionsToWrite
 
-			err := DeleteExpiredVersions(&models.DeleteExpiredVersionsCommand{})
+			err := DeleteExpiredVersions(context.Background(), &models.DeleteExpiredVersionsCommand{})
 			So(err, ShouldBeNil)
 
 			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1, Limit: versionsToWrite}
@@ -155,7 +265,7 @@ func TestDeleteExpiredVersions(t *testing.T) {
 				})
 			}
 
-			err := deleteExpiredVersions(&models.DeleteExpiredVersionsCommand{}, perBatch, maxBatches)
+			err := deleteExpiredVersions(context.Background(), &models.DeleteExpiredVersionsCommand{}, perBatch, maxBatches)
 			So(err, ShouldBeNil)
 
 			query := models.GetDashboardVersionsQuery{DashboardId: savedDash.Id, OrgId: 1, Limit: versionsToWriteBigNumber}
@@ -0,0 +1,76 @@
+package sqlstore
+
+import (
+	"context"
+	"sync"
+)
+
+type deleteBudgetKey struct{}
+
+// DeleteBudget bounds the number of rows the batched deletes of the cleanup
+// handlers remove with the context it was added to, see WithDeleteBudget.
+type DeleteBudget struct {
+	mtx       sync.Mutex
+	remaining int64
+}
+
+// WithDeleteBudget returns a context that allows the batched deletes run with
+// it to remove up to maxRows rows in total. A maxRows of 0 or less doesn't
+// limit the deletes.
+func WithDeleteBudget(ctx context.Context, maxRows int64) (context.Context, *DeleteBudget) {
+	if maxRows <= 0 {
+		return ctx, nil
+	}
+
+	budget := &DeleteBudget{remaining: maxRows}
+	return context.WithValue(ctx, deleteBudgetKey{}, budget), budget
+}
+
+// Exhausted reports whether the deletes removed as many rows as the budget allows.
+func (b *DeleteBudget) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.remaining <= 0
+}
+
+// deleteBudgetFromContext returns the budget of ctx, or nil if the deletes
+// aren't limited.
+func deleteBudgetFromContext(ctx context.Context) *DeleteBudget {
+	budget, _ := ctx.Value(deleteBudgetKey{}).(*DeleteBudget)
+	return budget
+}
+
+// limit returns how many rows the next batch may delete, at most batchSize.
+func (b *DeleteBudget) limit(batchSize int64) int64 {
+	if b == nil {
+		return batchSize
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.remaining <= 0 {
+		return 0
+	}
+	if b.remaining < batchSize {
+		return b.remaining
+	}
+	return batchSize
+}
+
+// spend records rows deleted by a batch.
+func (b *DeleteBudget) spend(rows int64) {
+	if b == nil {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.remaining -= rows
+}
@@ -0,0 +1,71 @@
+package sqlstore
+
+import (
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+// progressLogBatches and progressLogInterval set how often the batched
+// cleanup deletes log their progress, 0 turns either off. SqlStore.Init sets
+// them from the Cfg.
+var (
+	progressLogBatches  int64
+	progressLogInterval = time.Minute
+)
+
+// deleteProgress logs the rows a batched delete deleted so far and the rows
+// left, every few batches or every interval, so operators see that a long
+// running delete progresses.
+type deleteProgress struct {
+	table        string
+	everyBatches int64
+	every        time.Duration
+	// remaining returns the number of rows left to delete, it's only called
+	// when the progress is logged
+	remaining func() (int64, error)
+	log       log.Logger
+	now       func() time.Time
+
+	started    time.Time
+	lastLogged time.Time
+	batches    int64
+	deleted    int64
+}
+
+func newDeleteProgress(table string, remaining func() (int64, error)) *deleteProgress {
+	now := time.Now()
+	return &deleteProgress{
+		table:        table,
+		everyBatches: progressLogBatches,
+		every:        progressLogInterval,
+		remaining:    remaining,
+		log:          log.New("sqlstore.cleanup"),
+		now:          time.Now,
+		started:      now,
+		lastLogged:   now,
+	}
+}
+
+// batch records a batch that deleted rows and logs the progress when it's due.
+func (p *deleteProgress) batch(deleted int64) {
+	p.batches++
+	p.deleted += deleted
+
+	now := p.now()
+	dueBatches := p.everyBatches > 0 && p.batches%p.everyBatches == 0
+	dueInterval := p.every > 0 && now.Sub(p.lastLogged) >= p.every
+	if !dueBatches && !dueInterval {
+		return
+	}
+	p.lastLogged = now
+
+	var remaining interface{} = "unknown"
+	if left, err := p.remaining(); err != nil {
+		p.log.Debug("Failed to count the rows left to delete", "table", p.table, "error", err)
+	} else {
+		remaining = left
+	}
+	p.log.Info("Deleting in batches", "table", p.table, "batches", p.batches, "deleted", p.deleted,
+		"elapsed", now.Sub(p.started), "remaining", remaining)
+}
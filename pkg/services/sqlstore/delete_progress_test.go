@@ -0,0 +1,78 @@
+package sqlstore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/stretchr/testify/require"
+)
+
+type progressLogger struct {
+	log.Logger
+	logged [][]interface{}
+}
+
+func (l *progressLogger) Info(msg string, ctx ...interface{}) {
+	l.logged = append(l.logged, ctx)
+}
+
+func (l *progressLogger) Debug(msg string, ctx ...interface{}) {}
+
+func TestDeleteProgress(t *testing.T) {
+	newProgress := func(everyBatches int64, every time.Duration, remaining func() (int64, error)) (*deleteProgress, *progressLogger, *time.Time) {
+		logger := &progressLogger{}
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		p := newDeleteProgress("test", remaining)
+		p.everyBatches, p.every, p.log = everyBatches, every, logger
+		p.now = func() time.Time { return now }
+		p.started, p.lastLogged = now, now
+		return p, logger, &now
+	}
+
+	t.Run("logs every few batches", func(t *testing.T) {
+		left := int64(100)
+		p, logger, _ := newProgress(2, 0, func() (int64, error) { return left, nil })
+		for i := 0; i < 5; i++ {
+			left -= 10
+			p.batch(10)
+		}
+
+		require.Len(t, logger.logged, 2)
+		require.Equal(t, []interface{}{"table", "test", "batches", int64(4), "deleted", int64(40), "elapsed", time.Duration(0),
+			"remaining", int64(60)}, logger.logged[1])
+	})
+
+	t.Run("logs every interval", func(t *testing.T) {
+		p, logger, now := newProgress(0, time.Minute, func() (int64, error) { return 0, nil })
+		p.batch(10)
+		*now = now.Add(time.Second * 30)
+		p.batch(10)
+		require.Empty(t, logger.logged)
+
+		*now = now.Add(time.Second * 30)
+		p.batch(10)
+		require.Len(t, logger.logged, 1)
+		require.Equal(t, time.Minute, logger.logged[0][7])
+
+		p.batch(10)
+		require.Len(t, logger.logged, 1)
+	})
+
+	t.Run("doesn't log when turned off", func(t *testing.T) {
+		p, logger, now := newProgress(0, 0, func() (int64, error) { return 0, nil })
+		for i := 0; i < 100; i++ {
+			*now = now.Add(time.Hour)
+			p.batch(10)
+		}
+		require.Empty(t, logger.logged)
+	})
+
+	t.Run("logs an unknown remaining count when it can't be counted", func(t *testing.T) {
+		p, logger, _ := newProgress(1, 0, func() (int64, error) { return 0, errors.New("count failed") })
+		p.batch(10)
+		require.Len(t, logger.logged, 1)
+		require.Equal(t, "unknown", logger.logged[0][9])
+	})
+}
@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"context"
 	"strconv"
 	"time"
 
@@ -12,7 +13,7 @@ var getTimeNow = time.Now
 
 func init() {
 	bus.AddHandler("sql", CreateLoginAttempt)
-	bus.AddHandler("sql", DeleteOldLoginAttempts)
+	bus.AddHandlerCtx("sql", DeleteOldLoginAttempts)
 	bus.AddHandler("sql", GetUserLoginAttemptCount)
 }
 
@@ -34,8 +35,14 @@ func CreateLoginAttempt(cmd *models.CreateLoginAttemptCommand) error {
 	})
 }
 
-func DeleteOldLoginAttempts(cmd *models.DeleteOldLoginAttemptsCommand) error {
-	return inTransaction(func(sess *DBSession) error {
+func DeleteOldLoginAttempts(ctx context.Context, cmd *models.DeleteOldLoginAttemptsCommand) error {
+	return inTransactionCtx(ctx, func(sess *DBSession) error {
+		if cmd.DryRun {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM login_attempt WHERE created < ?", cmd.OlderThan.Unix())
+			return err
+		}
+
 		var maxId int64
 		sql := "SELECT max(id) as id FROM login_attempt WHERE created < ?"
 		result, err := sess.Query(sql, cmd.OlderThan.Unix())
@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -86,7 +87,7 @@ func TestLoginAttempts(t *testing.T) {
 			cmd := models.DeleteOldLoginAttemptsCommand{
 				OlderThan: beginningOfTime,
 			}
-			err := DeleteOldLoginAttempts(&cmd)
+			err := DeleteOldLoginAttempts(context.Background(), &cmd)
 
 			So(err, ShouldBeNil)
 			So(cmd.DeletedRows, ShouldEqual, 0)
@@ -96,7 +97,7 @@ func TestLoginAttempts(t *testing.T) {
 			cmd := models.DeleteOldLoginAttemptsCommand{
 				OlderThan: timePlusOneMinute,
 			}
-			err := DeleteOldLoginAttempts(&cmd)
+			err := DeleteOldLoginAttempts(context.Background(), &cmd)
 
 			So(err, ShouldBeNil)
 			So(cmd.DeletedRows, ShouldEqual, 1)
@@ -106,17 +107,36 @@ func TestLoginAttempts(t *testing.T) {
 			cmd := models.DeleteOldLoginAttemptsCommand{
 				OlderThan: timePlusTwoMinutes,
 			}
-			err := DeleteOldLoginAttempts(&cmd)
+			err := DeleteOldLoginAttempts(context.Background(), &cmd)
 
 			So(err, ShouldBeNil)
 			So(cmd.DeletedRows, ShouldEqual, 2)
 		})
 
+		Convey("Should only count rows older than beginning of time + 2min in dry run mode", func() {
+			cmd := models.DeleteOldLoginAttemptsCommand{
+				OlderThan: timePlusTwoMinutes,
+				DryRun:    true,
+			}
+			err := DeleteOldLoginAttempts(context.Background(), &cmd)
+
+			So(err, ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, 2)
+
+			query := models.GetUserLoginAttemptCountQuery{
+				Username: user,
+				Since:    beginningOfTime,
+			}
+			err = GetUserLoginAttemptCount(&query)
+			So(err, ShouldBeNil)
+			So(query.Result, ShouldEqual, 3)
+		})
+
 		Convey("Should return deleted rows older than beginning of time + 2min and 1s", func() {
 			cmd := models.DeleteOldLoginAttemptsCommand{
 				OlderThan: timePlusTwoMinutes.Add(time.Second * 1),
 			}
-			err := DeleteOldLoginAttempts(&cmd)
+			err := DeleteOldLoginAttempts(context.Background(), &cmd)
 
 			So(err, ShouldBeNil)
 			So(cmd.DeletedRows, ShouldEqual, 3)
@@ -1,6 +1,11 @@
 package sqlstore
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/models"
 )
@@ -12,6 +17,7 @@ func init() {
 	bus.AddHandler("sql", SearchPlaylists)
 	bus.AddHandler("sql", GetPlaylist)
 	bus.AddHandler("sql", GetPlaylistItem)
+	bus.AddHandlerCtx("sql", DeleteOrphanedPlaylistItems)
 }
 
 func CreatePlaylist(cmd *models.CreatePlaylistCommand) error {
@@ -155,3 +161,181 @@ func GetPlaylistItem(query *models.GetPlaylistItemsByIdQuery) error {
 
 	return err
 }
+
+const orphanedPlaylistItemsBatchSize = 100
+
+// playlistItemRef is a playlist item with the org of its playlist, which is
+// 0 if the playlist has been deleted.
+type playlistItemRef struct {
+	Id    int64
+	Type  string
+	Value string
+	OrgId int64
+}
+
+// DeleteOrphanedPlaylistItems deletes, in batches, the playlist items of
+// deleted playlists and the items whose dashboard has been deleted, and with
+// cmd.UnusedTags the items of tags no dashboard of the org has anymore. The
+// item values are strings, so the orphans are found in Go rather than by
+// casting them in SQL, which every database does differently. The items are
+// checked orphanedPlaylistItemsBatchSize at a time, in the order of their ids.
+func DeleteOrphanedPlaylistItems(ctx context.Context, cmd *models.DeleteOrphanedPlaylistItemsCommand) error {
+	cmd.DeletedRows = 0
+
+	var lastID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var items []playlistItemRef
+		var orphans []int64
+		err := withDbSession(ctx, func(sess *DBSession) error {
+			err := sess.SQL(`SELECT playlist_item.id, playlist_item.type, playlist_item.value, playlist.org_id
+				FROM playlist_item LEFT JOIN playlist ON playlist.id = playlist_item.playlist_id
+				WHERE playlist_item.id > ? ORDER BY playlist_item.id`+dialect.Limit(orphanedPlaylistItemsBatchSize), lastID).Find(&items)
+			if err != nil {
+				return err
+			}
+
+			orphans, err = orphanedPlaylistItems(sess, items, cmd.UnusedTags)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		lastID = items[len(items)-1].Id
+
+		if cmd.DryRun {
+			cmd.DeletedRows += int64(len(orphans))
+		} else {
+			deleted, err := deletePlaylistItems(ctx, orphans)
+			cmd.DeletedRows += deleted
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(items) < orphanedPlaylistItemsBatchSize {
+			return nil
+		}
+	}
+}
+
+// deletePlaylistItems deletes the playlist items with the given ids.
+func deletePlaylistItems(ctx context.Context, ids []int64) (int64, error) {
+	values := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id)
+	}
+
+	var deleted int64
+	err := inTransactionCtx(ctx, func(sess *DBSession) error {
+		return playlistItemsInBatches(values, func(batch []interface{}) error {
+			res, err := sess.Exec(append([]interface{}{"DELETE FROM playlist_item WHERE id IN (?" + strings.Repeat(",?", len(batch)-1) + ")"}, batch...)...)
+			if err != nil {
+				return err
+			}
+			affected, err := res.RowsAffected()
+			deleted += affected
+			return err
+		})
+	})
+	if err != nil {
+		// the transaction was rolled back
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// orphanedPlaylistItems returns the ids of the items that refer to a deleted
+// playlist or dashboard, or to an unused tag if unusedTags is set.
+func orphanedPlaylistItems(sess *DBSession, items []playlistItemRef, unusedTags bool) ([]int64, error) {
+	var dashboardIDs []interface{}
+	var tags []interface{}
+	for _, item := range items {
+		switch item.Type {
+		case "dashboard_by_id":
+			if id, err := strconv.ParseInt(item.Value, 10, 64); err == nil {
+				dashboardIDs = append(dashboardIDs, id)
+			}
+		case "dashboard_by_tag":
+			tags = append(tags, item.Value)
+		}
+	}
+
+	dashboards := map[string]bool{}
+	err := playlistItemsInBatches(dashboardIDs, func(batch []interface{}) error {
+		var rows []struct {
+			Id    int64
+			OrgId int64
+		}
+		err := sess.SQL("SELECT id, org_id FROM dashboard WHERE id IN (?"+strings.Repeat(",?", len(batch)-1)+")", batch...).Find(&rows)
+		for _, row := range rows {
+			dashboards[fmt.Sprintf("%d/%d", row.OrgId, row.Id)] = true
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usedTags := map[string]bool{}
+	if unusedTags {
+		err := playlistItemsInBatches(tags, func(batch []interface{}) error {
+			var rows []struct {
+				Term  string
+				OrgId int64
+			}
+			err := sess.SQL(`SELECT DISTINCT dashboard_tag.term, dashboard.org_id FROM dashboard_tag
+				INNER JOIN dashboard ON dashboard.id = dashboard_tag.dashboard_id
+				WHERE dashboard_tag.term IN (?`+strings.Repeat(",?", len(batch)-1)+")", batch...).Find(&rows)
+			for _, row := range rows {
+				usedTags[fmt.Sprintf("%d/%s", row.OrgId, row.Term)] = true
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var orphans []int64
+	for _, item := range items {
+		orphaned := item.OrgId == 0
+		switch item.Type {
+		case "dashboard_by_id":
+			// values that aren't ids can't be played either
+			id, err := strconv.ParseInt(item.Value, 10, 64)
+			orphaned = orphaned || err != nil || !dashboards[fmt.Sprintf("%d/%d", item.OrgId, id)]
+		case "dashboard_by_tag":
+			orphaned = orphaned || (unusedTags && !usedTags[fmt.Sprintf("%d/%s", item.OrgId, item.Value)])
+		}
+		if orphaned {
+			orphans = append(orphans, item.Id)
+		}
+	}
+
+	return orphans, nil
+}
+
+// playlistItemsInBatches calls find with up to orphanedPlaylistItemsBatchSize of the
+// values at a time, so the IN lists stay below the parameter limits of the databases.
+func playlistItemsInBatches(values []interface{}, find func(batch []interface{}) error) error {
+	for len(values) > 0 {
+		n := len(values)
+		if n > orphanedPlaylistItemsBatchSize {
+			n = orphanedPlaylistItemsBatchSize
+		}
+		if err := find(values[:n]); err != nil {
+			return err
+		}
+		values = values[n:]
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package sqlstore
 
 import (
+	"context"
+	"strconv"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -41,3 +43,83 @@ func TestPlaylistDataAccess(t *testing.T) {
 		})
 	})
 }
+
+func TestDeleteOrphanedPlaylistItems(t *testing.T) {
+	Convey("Testing deleting orphaned playlist items", t, func() {
+		InitTestDB(t)
+
+		kept := insertTestDashboard("kept", 1, 0, false, "prod")
+		otherOrg := insertTestDashboard("other org", 2, 0, false, "staging")
+		id := func(dashboard *models.Dashboard) string {
+			return strconv.FormatInt(dashboard.Id, 10)
+		}
+
+		cmd := models.CreatePlaylistCommand{Name: "office", Interval: "10m", OrgId: 1, Items: []models.PlaylistItemDTO{
+			{Title: "kept", Value: id(kept), Type: "dashboard_by_id"},
+			{Title: "deleted", Value: "9999", Type: "dashboard_by_id"},
+			{Title: "other org", Value: id(otherOrg), Type: "dashboard_by_id"},
+			{Title: "invalid", Value: "kept", Type: "dashboard_by_id"},
+			{Title: "prod", Value: "prod", Type: "dashboard_by_tag"},
+			{Title: "staging", Value: "staging", Type: "dashboard_by_tag"},
+			{Title: "unused", Value: "unused", Type: "dashboard_by_tag"},
+		}}
+		So(CreatePlaylist(&cmd), ShouldBeNil)
+		_, err := x.Insert(&models.PlaylistItem{PlaylistId: 9999, Type: "dashboard_by_id", Value: id(kept)})
+		So(err, ShouldBeNil)
+
+		titles := func() []string {
+			query := models.GetPlaylistItemsByIdQuery{PlaylistId: cmd.Result.Id}
+			So(GetPlaylistItem(&query), ShouldBeNil)
+			var titles []string
+			for _, item := range *query.Result {
+				titles = append(titles, item.Title)
+			}
+			return titles
+		}
+
+		Convey("Only counts the orphaned items in dry run mode", func() {
+			dryRun := models.DeleteOrphanedPlaylistItemsCommand{DryRun: true}
+			So(DeleteOrphanedPlaylistItems(context.Background(), &dryRun), ShouldBeNil)
+			So(dryRun.DeletedRows, ShouldEqual, 4)
+			So(titles(), ShouldHaveLength, 7)
+		})
+
+		Convey("Deletes the items of deleted playlists and dashboards and keeps the tags", func() {
+			delete := models.DeleteOrphanedPlaylistItemsCommand{}
+			So(DeleteOrphanedPlaylistItems(context.Background(), &delete), ShouldBeNil)
+			So(delete.DeletedRows, ShouldEqual, 4)
+			So(titles(), ShouldResemble, []string{"kept", "prod", "staging", "unused"})
+
+			count, err := x.Where("playlist_id = ?", 9999).Count(&models.PlaylistItem{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("Deletes the items of tags no dashboard of the org has", func() {
+			delete := models.DeleteOrphanedPlaylistItemsCommand{UnusedTags: true}
+			So(DeleteOrphanedPlaylistItems(context.Background(), &delete), ShouldBeNil)
+			So(delete.DeletedRows, ShouldEqual, 6)
+			So(titles(), ShouldResemble, []string{"kept", "prod"})
+		})
+
+		Convey("Pages through more items than fit in a batch", func() {
+			for i := 0; i < orphanedPlaylistItemsBatchSize*2+1; i++ {
+				_, err := x.Insert(&models.PlaylistItem{PlaylistId: 9999, Type: "dashboard_by_tag", Value: "prod"})
+				So(err, ShouldBeNil)
+			}
+
+			dryRun := models.DeleteOrphanedPlaylistItemsCommand{DryRun: true}
+			So(DeleteOrphanedPlaylistItems(context.Background(), &dryRun), ShouldBeNil)
+			So(dryRun.DeletedRows, ShouldEqual, orphanedPlaylistItemsBatchSize*2+5)
+
+			delete := models.DeleteOrphanedPlaylistItemsCommand{}
+			So(DeleteOrphanedPlaylistItems(context.Background(), &delete), ShouldBeNil)
+			So(delete.DeletedRows, ShouldEqual, orphanedPlaylistItemsBatchSize*2+5)
+			So(titles(), ShouldResemble, []string{"kept", "prod", "staging", "unused"})
+
+			count, err := x.Where("playlist_id = ?", 9999).Count(&models.PlaylistItem{})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+	})
+}
@@ -1,6 +1,8 @@
 package sqlstore
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"time"
 
@@ -14,6 +16,37 @@ func init() {
 	bus.AddHandler("sql", GetPreferences)
 	bus.AddHandler("sql", GetPreferencesWithDefaults)
 	bus.AddHandler("sql", SavePreferences)
+	bus.AddHandlerCtx("sql", DeleteOrphanedPreferences)
+}
+
+const orphanedPreferencesBatchSize = 100
+
+// orphanedPreferencesCondition matches the preferences of deleted users and
+// teams. The org preferences have neither a user nor a team and are kept.
+func orphanedPreferencesCondition() string {
+	return `(user_id > 0 AND NOT EXISTS (SELECT 1 FROM ` + dialect.Quote("user") + ` u WHERE u.id = preferences.user_id))
+		OR (team_id > 0 AND NOT EXISTS (SELECT 1 FROM team WHERE team.id = preferences.team_id))`
+}
+
+// DeleteOrphanedPreferences deletes, in batches, the preferences that refer to
+// a user or team that has been deleted.
+func DeleteOrphanedPreferences(ctx context.Context, cmd *models.DeleteOrphanedPreferencesCommand) error {
+	condition := orphanedPreferencesCondition()
+	if cmd.DryRun {
+		return withDbSession(ctx, func(sess *DBSession) error {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM preferences WHERE "+condition)
+			return err
+		})
+	}
+
+	query := func(limit int64) string {
+		return fmt.Sprintf("DELETE FROM preferences WHERE id IN (SELECT id FROM (SELECT id FROM preferences WHERE %s ORDER BY id %s) p)", condition, dialect.Limit(limit))
+	}
+
+	var err error
+	cmd.DeletedRows, err = executeUntilDoneOrCancelled(ctx, orphanedPreferencesBatchSize, query)
+	return err
 }
 
 func GetPreferencesWithDefaults(query *models.GetPreferencesWithDefaultsQuery) error {
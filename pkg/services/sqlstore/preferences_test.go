@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"context"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -113,3 +114,57 @@ func TestPreferencesDataAccess(t *testing.T) {
 		})
 	})
 }
+
+func TestDeleteOrphanedPreferences(t *testing.T) {
+	// createUser turns on the auto assignment of users to an org
+	defaultAutoAssign, defaultOrgID, defaultRole := setting.AutoAssignOrg, setting.AutoAssignOrgId, setting.AutoAssignOrgRole
+	defer func() {
+		setting.AutoAssignOrg, setting.AutoAssignOrgId, setting.AutoAssignOrgRole = defaultAutoAssign, defaultOrgID, defaultRole
+	}()
+
+	Convey("Testing deleting orphaned preferences", t, func() {
+		InitTestDB(t)
+
+		user := createUser("user", "Viewer", false)
+		deletedUser := createUser("deleted", "Viewer", false)
+		team := models.CreateTeamCommand{Name: "team", OrgId: 1}
+		So(CreateTeam(&team), ShouldBeNil)
+		deletedTeam := models.CreateTeamCommand{Name: "deleted team", OrgId: 1}
+		So(CreateTeam(&deletedTeam), ShouldBeNil)
+
+		for _, cmd := range []models.SavePreferencesCommand{
+			{OrgId: 1, HomeDashboardId: 1},
+			{OrgId: 1, UserId: user.Id, HomeDashboardId: 2},
+			{OrgId: 1, UserId: deletedUser.Id, HomeDashboardId: 3},
+			{OrgId: 1, TeamId: team.Result.Id, HomeDashboardId: 4},
+			{OrgId: 1, TeamId: deletedTeam.Result.Id, HomeDashboardId: 5},
+		} {
+			cmd := cmd
+			So(SavePreferences(&cmd), ShouldBeNil)
+		}
+
+		// delete the rows directly, DeleteUser would remove the user preferences too
+		_, err := x.Exec("DELETE FROM "+dialect.Quote("user")+" WHERE id = ?", deletedUser.Id)
+		So(err, ShouldBeNil)
+		_, err = x.Exec("DELETE FROM team WHERE id = ?", deletedTeam.Result.Id)
+		So(err, ShouldBeNil)
+
+		dryRun := models.DeleteOrphanedPreferencesCommand{DryRun: true}
+		err = DeleteOrphanedPreferences(context.Background(), &dryRun)
+		So(err, ShouldBeNil)
+		So(dryRun.DeletedRows, ShouldEqual, 2)
+
+		cmd := models.DeleteOrphanedPreferencesCommand{}
+		err = DeleteOrphanedPreferences(context.Background(), &cmd)
+		So(err, ShouldBeNil)
+		So(cmd.DeletedRows, ShouldEqual, 2)
+
+		var kept []models.Preferences
+		err = x.OrderBy("home_dashboard_id").Find(&kept)
+		So(err, ShouldBeNil)
+		So(len(kept), ShouldEqual, 3)
+		So(kept[0].HomeDashboardId, ShouldEqual, 1)
+		So(kept[1].HomeDashboardId, ShouldEqual, 2)
+		So(kept[2].HomeDashboardId, ShouldEqual, 4)
+	})
+}
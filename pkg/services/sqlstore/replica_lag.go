@@ -0,0 +1,131 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// postgresReplicaLagQuery returns the replay lag of the slowest standby in seconds.
+const postgresReplicaLagQuery = "SELECT COALESCE(EXTRACT(EPOCH FROM MAX(replay_lag)), 0) AS lag FROM pg_stat_replication"
+
+// mysqlReplicaLagQuery reports the lag of the database Grafana connects to if
+// it's a replica itself, primaries need a custom query.
+const mysqlReplicaLagQuery = "SHOW SLAVE STATUS"
+
+// replicaLag pauses the batched cleanup deletes while the replication lag of
+// the database exceeds Cfg.CleanupReplicaLagThreshold, so the deletes don't
+// leave the replicas behind. A nil replicaLag never pauses.
+type replicaLag struct {
+	threshold     time.Duration
+	checkInterval time.Duration
+	query         string
+	log           log.Logger
+}
+
+// deleteBackpressure is checked between the batches of the cleanup deletes.
+var deleteBackpressure *replicaLag
+
+// newReplicaLag returns the replica lag check configured in cfg, or nil if it
+// is turned off or not supported by the database.
+func newReplicaLag(cfg *setting.Cfg, driver string) *replicaLag {
+	if cfg == nil || cfg.CleanupReplicaLagThreshold <= 0 {
+		return nil
+	}
+
+	logger := log.New("sqlstore.replicalag")
+	query := cfg.CleanupReplicaLagQuery
+	if query == "" {
+		switch driver {
+		case migrator.POSTGRES:
+			query = postgresReplicaLagQuery
+		case migrator.MYSQL:
+			query = mysqlReplicaLagQuery
+		default:
+			logger.Warn("Checking the replica lag isn't supported by the database, ignoring replica_lag_threshold", "type", driver)
+			return nil
+		}
+	}
+
+	checkInterval := cfg.CleanupReplicaLagCheckInterval
+	if checkInterval <= 0 {
+		checkInterval = time.Second * 10
+	}
+
+	return &replicaLag{threshold: cfg.CleanupReplicaLagThreshold, checkInterval: checkInterval, query: query, log: logger}
+}
+
+// wait blocks while the replica lag exceeds the threshold, checking it again
+// every check interval, until the lag recovered or ctx is done. A failing
+// check doesn't pause the deletes.
+func (r *replicaLag) wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	var pausedAt time.Time
+	for {
+		lag, err := r.current(ctx)
+		if err != nil {
+			r.log.Warn("Failed to check the replica lag, not pausing the cleanup", "error", err)
+			return nil
+		}
+		if lag <= r.threshold {
+			if !pausedAt.IsZero() {
+				r.log.Info("Replica lag recovered, resuming the cleanup", "lag", lag, "paused", time.Since(pausedAt))
+			}
+			return nil
+		}
+
+		if pausedAt.IsZero() {
+			pausedAt = time.Now()
+			r.log.Info("Replica lag exceeds the threshold, pausing the cleanup", "lag", lag, "threshold", r.threshold)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.checkInterval):
+		}
+	}
+}
+
+// current returns the replication lag reported by the lag query, which either
+// returns it in seconds as its only column or is SHOW SLAVE STATUS.
+func (r *replicaLag) current(ctx context.Context) (time.Duration, error) {
+	var lag time.Duration
+	err := withDbSession(ctx, func(sess *DBSession) error {
+		result, err := sess.QueryString(r.query)
+		if err != nil || len(result) == 0 {
+			return err
+		}
+
+		var value string
+		if r.query == mysqlReplicaLagQuery {
+			value = result[0]["Seconds_Behind_Master"]
+		} else {
+			for _, v := range result[0] {
+				value = v
+			}
+		}
+		// NULL while the replication is stopped, which doesn't pause the deletes
+		if value = strings.TrimSpace(value); value == "" {
+			return nil
+		}
+
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid replica lag %q: %w", value, err)
+		}
+		lag = time.Duration(seconds * float64(time.Second))
+		return nil
+	})
+
+	return lag, err
+}
@@ -0,0 +1,79 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func TestReplicaLag(t *testing.T) {
+	Convey("Testing the replica lag backpressure", t, func() {
+		InitTestDB(t)
+		// every connection to an in-memory SQLite database gets a database of its
+		// own, the lag checks and the updates below must share the one with the table
+		x.SetMaxOpenConns(1)
+
+		_, err := x.Exec("CREATE TABLE IF NOT EXISTS test_replica_lag (seconds INTEGER)")
+		So(err, ShouldBeNil)
+		_, err = x.Exec("DELETE FROM test_replica_lag")
+		So(err, ShouldBeNil)
+		_, err = x.Exec("INSERT INTO test_replica_lag (seconds) VALUES (5)")
+		So(err, ShouldBeNil)
+
+		cfg := &setting.Cfg{
+			CleanupReplicaLagThreshold:     time.Second,
+			CleanupReplicaLagCheckInterval: time.Millisecond * 10,
+			CleanupReplicaLagQuery:         "SELECT seconds FROM test_replica_lag",
+		}
+
+		Convey("Is off without a threshold or a lag query for the database", func() {
+			So(newReplicaLag(&setting.Cfg{}, migrator.POSTGRES), ShouldBeNil)
+			So(newReplicaLag(&setting.Cfg{CleanupReplicaLagThreshold: time.Second}, migrator.SQLITE), ShouldBeNil)
+			So(newReplicaLag(&setting.Cfg{CleanupReplicaLagThreshold: time.Second}, migrator.POSTGRES).query, ShouldEqual, postgresReplicaLagQuery)
+			So(newReplicaLag(cfg, migrator.SQLITE), ShouldNotBeNil)
+
+			var off *replicaLag
+			So(off.wait(context.Background()), ShouldBeNil)
+		})
+
+		Convey("Doesn't pause while the lag is below the threshold", func() {
+			cfg.CleanupReplicaLagThreshold = time.Second * 10
+			So(newReplicaLag(cfg, migrator.SQLITE).wait(context.Background()), ShouldBeNil)
+		})
+
+		Convey("Pauses until the lag recovered", func() {
+			done := make(chan error)
+			go func() {
+				done <- newReplicaLag(cfg, migrator.SQLITE).wait(context.Background())
+			}()
+
+			select {
+			case <-done:
+				t.Fatal("didn't pause while the replicas lag behind")
+			case <-time.After(time.Millisecond * 50):
+			}
+
+			_, err := x.Exec("UPDATE test_replica_lag SET seconds = 0")
+			So(err, ShouldBeNil)
+			So(<-done, ShouldBeNil)
+		})
+
+		Convey("Stops pausing when cancelled", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+			defer cancel()
+			err := newReplicaLag(cfg, migrator.SQLITE).wait(ctx)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+
+		Convey("Doesn't pause when the lag can't be checked", func() {
+			cfg.CleanupReplicaLagQuery = "SELECT seconds FROM missing_replica_lag"
+			So(newReplicaLag(cfg, migrator.SQLITE).wait(context.Background()), ShouldBeNil)
+		})
+	})
+}
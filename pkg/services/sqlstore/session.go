@@ -66,6 +66,20 @@ func withDbSession(ctx context.Context, callback dbTransactionFunc) error {
 	return callback(sess)
 }
 
+// countRows runs a `SELECT COUNT(*) AS count ...` query and returns the count.
+func countRows(sess *DBSession, sql string, args ...interface{}) (int64, error) {
+	result, err := sess.Query(append([]interface{}{sql}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	return toInt64(result[0]["count"]), nil
+}
+
 func (sess *DBSession) InsertId(bean interface{}) (int64, error) {
 	table := sess.DB().Mapper.Obj2Table(getTypeName(bean))
 
@@ -96,7 +96,13 @@ func (ss *SqlStore) Init() error {
 
 	// Init repo instances
 	annotations.SetRepository(&SqlAnnotationRepo{})
-	annotations.SetAnnotationCleaner(&AnnotationCleanupService{batchSize: 100, log: log.New("annotationcleaner")})
+	annotationCleanupBatchSize := ss.Cfg.AnnotationCleanupJobBatchSize
+	if annotationCleanupBatchSize < 1 {
+		annotationCleanupBatchSize = defaultAnnotationCleanupBatchSize
+	}
+	annotations.SetAnnotationCleaner(&AnnotationCleanupService{batchSize: annotationCleanupBatchSize, log: log.New("annotationcleaner")})
+	deleteBackpressure = newReplicaLag(ss.Cfg, ss.Dialect.DriverName())
+	progressLogBatches, progressLogInterval = ss.Cfg.CleanupProgressLogBatches, ss.Cfg.CleanupProgressLogInterval
 	ss.Bus.SetTransactionManager(ss)
 
 	// Register handlers
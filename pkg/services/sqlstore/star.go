@@ -1,6 +1,9 @@
 package sqlstore
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/models"
 )
@@ -10,6 +13,36 @@ func init() {
 	bus.AddHandler("sql", UnstarDashboard)
 	bus.AddHandler("sql", GetUserStars)
 	bus.AddHandler("sql", IsStarredByUser)
+	bus.AddHandlerCtx("sql", DeleteOrphanedStars)
+}
+
+const orphanedStarsBatchSize = 100
+
+// orphanedStarsCondition matches the stars of deleted dashboards and users.
+func orphanedStarsCondition() string {
+	return `NOT EXISTS (SELECT 1 FROM dashboard WHERE dashboard.id = star.dashboard_id)
+		OR NOT EXISTS (SELECT 1 FROM ` + dialect.Quote("user") + ` u WHERE u.id = star.user_id)`
+}
+
+// DeleteOrphanedStars deletes, in batches, the stars that refer to a dashboard
+// or user that has been deleted.
+func DeleteOrphanedStars(ctx context.Context, cmd *models.DeleteOrphanedStarsCommand) error {
+	condition := orphanedStarsCondition()
+	if cmd.DryRun {
+		return withDbSession(ctx, func(sess *DBSession) error {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM star WHERE "+condition)
+			return err
+		})
+	}
+
+	query := func(limit int64) string {
+		return fmt.Sprintf("DELETE FROM star WHERE id IN (SELECT id FROM (SELECT id FROM star WHERE %s ORDER BY id %s) s)", condition, dialect.Limit(limit))
+	}
+
+	var err error
+	cmd.DeletedRows, err = executeUntilDoneOrCancelled(ctx, orphanedStarsBatchSize, query)
+	return err
 }
 
 func IsStarredByUser(query *models.IsStarredByUserQuery) error {
@@ -1,9 +1,11 @@
 package sqlstore
 
 import (
+	"context"
 	"testing"
 
 	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/setting"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -38,3 +40,54 @@ func TestUserStarsDataAccess(t *testing.T) {
 		})
 	})
 }
+
+func TestDeleteOrphanedStars(t *testing.T) {
+	// createUser turns on the auto assignment of users to an org
+	defaultAutoAssign, defaultOrgID, defaultRole := setting.AutoAssignOrg, setting.AutoAssignOrgId, setting.AutoAssignOrgRole
+	defer func() {
+		setting.AutoAssignOrg, setting.AutoAssignOrgId, setting.AutoAssignOrgRole = defaultAutoAssign, defaultOrgID, defaultRole
+	}()
+
+	Convey("Testing deleting orphaned stars", t, func() {
+		InitTestDB(t)
+
+		user := createUser("user", "Viewer", false)
+		deletedUser := createUser("deleted", "Viewer", false)
+		dash := insertTestDashboard("kept dash", 1, 0, false)
+		deletedDash := insertTestDashboard("deleted dash", 1, 0, false)
+
+		for _, cmd := range []models.StarDashboardCommand{
+			{UserId: user.Id, DashboardId: dash.Id},
+			{UserId: user.Id, DashboardId: deletedDash.Id},
+			{UserId: deletedUser.Id, DashboardId: dash.Id},
+		} {
+			cmd := cmd
+			So(StarDashboard(&cmd), ShouldBeNil)
+		}
+
+		// delete the rows directly, the regular deletes remove the stars too
+		_, err := x.Exec("DELETE FROM "+dialect.Quote("user")+" WHERE id = ?", deletedUser.Id)
+		So(err, ShouldBeNil)
+		_, err = x.Exec("DELETE FROM dashboard WHERE id = ?", deletedDash.Id)
+		So(err, ShouldBeNil)
+
+		dryRun := models.DeleteOrphanedStarsCommand{DryRun: true}
+		err = DeleteOrphanedStars(context.Background(), &dryRun)
+		So(err, ShouldBeNil)
+		So(dryRun.DeletedRows, ShouldEqual, 2)
+
+		cmd := models.DeleteOrphanedStarsCommand{}
+		err = DeleteOrphanedStars(context.Background(), &cmd)
+		So(err, ShouldBeNil)
+		So(cmd.DeletedRows, ShouldEqual, 2)
+
+		query := models.GetUserStarsQuery{UserId: user.Id}
+		err = GetUserStars(&query)
+		So(err, ShouldBeNil)
+		So(query.Result, ShouldResemble, map[int64]bool{dash.Id: true})
+
+		count, err := x.Table("star").Count()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+	})
+}
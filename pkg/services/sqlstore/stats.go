@@ -17,6 +17,7 @@ func init() {
 	bus.AddHandler("sql", GetUserStats)
 	bus.AddHandlerCtx("sql", GetAlertNotifiersUsageStats)
 	bus.AddHandlerCtx("sql", GetSystemUserCountStats)
+	bus.AddHandlerCtx("sql", GetTableRowCount)
 }
 
 const activeUserTimeLimit = time.Hour * 24 * 30
@@ -182,6 +183,20 @@ func GetSystemUserCountStats(ctx context.Context, query *models.GetSystemUserCou
 	})
 }
 
+// GetTableRowCount counts the rows of query.Table, which must be a table
+// name known to the caller rather than user input.
+func GetTableRowCount(ctx context.Context, query *models.GetTableRowCountQuery) error {
+	return withDbSession(ctx, func(sess *DBSession) error {
+		var count int64
+		if _, err := sess.SQL("SELECT COUNT(*) FROM " + dialect.Quote(query.Table)).Get(&count); err != nil {
+			return err
+		}
+
+		query.Result = count
+		return nil
+	})
+}
+
 func GetUserStats(query *models.GetUserStatsQuery) error {
 	err := updateUserRoleCountsIfNecessary(query.MustUpdate)
 	if err != nil {
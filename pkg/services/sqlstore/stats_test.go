@@ -30,6 +30,13 @@ func TestStatsDataAccess(t *testing.T) {
 		assert.NoError(t, err)
 	})
 
+	t.Run("Get table row count should count all rows", func(t *testing.T) {
+		query := models.GetTableRowCountQuery{Table: "user"}
+		err := GetTableRowCount(context.Background(), &query)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), query.Result)
+	})
+
 	t.Run("Get datasource stats should not results in error", func(t *testing.T) {
 		query := models.GetDataSourceStatsQuery{}
 		err := GetDataSourceStats(&query)
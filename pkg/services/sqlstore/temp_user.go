@@ -1,6 +1,9 @@
 package sqlstore
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana/pkg/bus"
@@ -13,6 +16,150 @@ func init() {
 	bus.AddHandler("sql", UpdateTempUserStatus)
 	bus.AddHandler("sql", GetTempUserByCode)
 	bus.AddHandler("sql", UpdateTempUserWithEmailSent)
+	bus.AddHandlerCtx("sql", DeleteExpiredUserInvites)
+	bus.AddHandlerCtx("sql", MarkExpiredUserInvites)
+	bus.AddHandlerCtx("sql", DeleteDuplicateUserInvites)
+}
+
+const expiredUserInvitesBatchSize = 100
+
+// duplicateUserInvitesCondition matches the pending invites with a newer
+// pending invite for the same email and org. Ids grow with every invite, they
+// tell the most recent invite apart even if two were created in the same second.
+func duplicateUserInvitesCondition() string {
+	return `status = '` + string(models.TmpUserInvitePending) + `' AND EXISTS (SELECT 1 FROM temp_user newer
+		WHERE newer.org_id = temp_user.org_id AND newer.email = temp_user.email
+		AND newer.status = temp_user.status AND newer.id > temp_user.id)`
+}
+
+// DeleteDuplicateUserInvites deletes, in batches, the pending invites that have
+// been superseded by a newer one, regardless of their age. Only the most recent
+// invite link of an email and org keeps working.
+func DeleteDuplicateUserInvites(ctx context.Context, cmd *models.DeleteDuplicateUserInvitesCommand) error {
+	condition := duplicateUserInvitesCondition()
+	if cmd.DryRun {
+		return withDbSession(ctx, func(sess *DBSession) error {
+			var err error
+			cmd.DeletedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM temp_user WHERE "+condition)
+			return err
+		})
+	}
+
+	query := func(limit int64) string {
+		return fmt.Sprintf("DELETE FROM temp_user WHERE id IN (SELECT id FROM (SELECT id FROM temp_user WHERE %s ORDER BY id %s) t)", condition, dialect.Limit(limit))
+	}
+
+	var err error
+	cmd.DeletedRows, err = executeUntilDoneOrCancelled(ctx, expiredUserInvitesBatchSize, query)
+	return err
+}
+
+// DeleteExpiredUserInvites deletes the invites created before cmd.OlderThan in
+// batches, so a large temp_user table isn't locked by a single statement.
+//
+// temp_user.created is a DATETIME column, so it's compared with cmd.OlderThan
+// itself rather than with its Unix timestamp.
+func DeleteExpiredUserInvites(ctx context.Context, cmd *models.DeleteExpiredUserInvitesCommand) error {
+	where := "created < ?"
+	args := []interface{}{cmd.OlderThan}
+	if len(cmd.Statuses) > 0 {
+		where += " AND status IN (?" + strings.Repeat(",?", len(cmd.Statuses)-1) + ")"
+		for _, status := range cmd.Statuses {
+			args = append(args, string(status))
+		}
+	}
+	if !cmd.UpdatedBefore.IsZero() {
+		where += " AND updated < ?"
+		args = append(args, cmd.UpdatedBefore)
+	}
+
+	countSQL := "SELECT COUNT(*) AS count FROM temp_user WHERE " + where
+	deleteSQL := func(limit int64) string {
+		return "DELETE FROM temp_user WHERE id IN (SELECT id FROM (SELECT id FROM temp_user WHERE " + where + " ORDER BY id " +
+			dialect.Limit(limit) + ") t)"
+	}
+
+	return withDbSession(ctx, func(sess *DBSession) error {
+		expired, err := countRows(sess, countSQL, args...)
+		if err != nil {
+			return err
+		}
+		if cmd.DryRun || expired == 0 {
+			cmd.DeletedRows = expired
+			return nil
+		}
+
+		budget := deleteBudgetFromContext(ctx)
+		progress := newDeleteProgress("temp_user", func() (int64, error) {
+			return countRows(sess, countSQL, args...)
+		})
+		var rowsAffectedUnsupported bool
+		for batch := int64(0); batch*expiredUserInvitesBatchSize < expired; batch++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			limit := budget.limit(expiredUserInvitesBatchSize)
+			if limit <= 0 {
+				break
+			}
+
+			res, err := sess.Exec(append([]interface{}{deleteSQL(limit)}, args...)...)
+			if err != nil {
+				return err
+			}
+
+			affected, err := res.RowsAffected()
+			if err != nil {
+				// assume the whole batch got deleted, so the budget still holds
+				budget.spend(limit)
+				rowsAffectedUnsupported = true
+				continue
+			}
+			budget.spend(affected)
+			if affected == 0 {
+				break
+			}
+			cmd.DeletedRows += affected
+			progress.batch(affected)
+
+			if err := deleteBackpressure.wait(ctx); err != nil {
+				return err
+			}
+		}
+
+		// not every driver reports affected rows, count what is left instead
+		if rowsAffectedUnsupported {
+			left, err := countRows(sess, countSQL, args...)
+			if err != nil {
+				return err
+			}
+			cmd.DeletedRows = expired - left
+		}
+
+		return nil
+	})
+}
+
+// MarkExpiredUserInvites changes the status of the expired invites or sign ups
+// to their expired status, so their links stop working while they can still
+// be restored.
+func MarkExpiredUserInvites(ctx context.Context, cmd *models.MarkExpiredUserInvitesCommand) error {
+	return withDbSession(ctx, func(sess *DBSession) error {
+		if cmd.DryRun {
+			var err error
+			cmd.MarkedRows, err = countRows(sess, "SELECT COUNT(*) AS count FROM temp_user WHERE created < ? AND status = ?",
+				cmd.OlderThan, string(cmd.Status))
+			return err
+		}
+
+		res, err := sess.Exec("UPDATE temp_user SET status = ?, updated = ? WHERE created < ? AND status = ?",
+			string(cmd.ExpiredStatus), cmd.MarkedAt, cmd.OlderThan, string(cmd.Status))
+		if err != nil {
+			return err
+		}
+		cmd.MarkedRows, _ = res.RowsAffected()
+		return nil
+	})
 }
 
 func UpdateTempUserStatus(cmd *models.UpdateTempUserStatusCommand) error {
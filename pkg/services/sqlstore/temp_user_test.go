@@ -1,7 +1,10 @@
 package sqlstore
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana/pkg/models"
 	. "github.com/smartystreets/goconvey/convey"
@@ -64,6 +67,119 @@ func TestTempUserCommandsAndQueries(t *testing.T) {
 				So(query.Result[0].EmailSent, ShouldBeTrue)
 				So(query.Result[0].EmailSentOn, ShouldHappenOnOrAfter, (query.Result[0].Created))
 			})
+
+			Convey("Should keep invites that haven't expired yet", func() {
+				cmd := models.DeleteExpiredUserInvitesCommand{OlderThan: time.Now().Add(-time.Hour)}
+				err := DeleteExpiredUserInvites(context.Background(), &cmd)
+				So(err, ShouldBeNil)
+				So(cmd.DeletedRows, ShouldEqual, 0)
+			})
+
+			Convey("Should only delete expired rows with the given statuses", func() {
+				err := CreateTempUser(&models.CreateTempUserCommand{OrgId: 2256, Code: "sign-up", Status: models.TmpUserSignUpStarted})
+				So(err, ShouldBeNil)
+
+				cmd := models.DeleteExpiredUserInvitesCommand{
+					OlderThan: time.Now().Add(time.Minute),
+					Statuses:  []models.TempUserStatus{models.TmpUserSignUpStarted, models.TmpUserRevoked},
+				}
+				err = DeleteExpiredUserInvites(context.Background(), &cmd)
+				So(err, ShouldBeNil)
+				So(cmd.DeletedRows, ShouldEqual, 1)
+
+				query := models.GetTempUsersQuery{OrgId: 2256, Status: models.TmpUserInvitePending}
+				err = GetTempUsersQuery(&query)
+				So(err, ShouldBeNil)
+				So(query.Result, ShouldHaveLength, 1)
+			})
+
+			Convey("Should mark expired invites and delete them once they were marked long enough", func() {
+				olderThan := time.Now().Add(time.Minute)
+				markedAt := time.Now().Add(-time.Hour)
+
+				dryRun := models.MarkExpiredUserInvitesCommand{OlderThan: olderThan, Status: models.TmpUserInvitePending,
+					ExpiredStatus: models.TmpUserInviteExpired, MarkedAt: markedAt, DryRun: true}
+				So(MarkExpiredUserInvites(context.Background(), &dryRun), ShouldBeNil)
+				So(dryRun.MarkedRows, ShouldEqual, 1)
+
+				mark := models.MarkExpiredUserInvitesCommand{OlderThan: olderThan, Status: models.TmpUserInvitePending,
+					ExpiredStatus: models.TmpUserInviteExpired, MarkedAt: markedAt}
+				So(MarkExpiredUserInvites(context.Background(), &mark), ShouldBeNil)
+				So(mark.MarkedRows, ShouldEqual, 1)
+
+				query := models.GetTempUserByCodeQuery{Code: "asd"}
+				So(GetTempUserByCode(&query), ShouldBeNil)
+				So(query.Result.Status, ShouldEqual, models.TmpUserInviteExpired)
+
+				marked := []models.TempUserStatus{models.TmpUserInviteExpired}
+				cmd := models.DeleteExpiredUserInvitesCommand{OlderThan: olderThan, Statuses: marked, UpdatedBefore: markedAt}
+				So(DeleteExpiredUserInvites(context.Background(), &cmd), ShouldBeNil)
+				So(cmd.DeletedRows, ShouldEqual, 0)
+
+				cmd = models.DeleteExpiredUserInvitesCommand{OlderThan: olderThan, Statuses: marked, UpdatedBefore: time.Now()}
+				So(DeleteExpiredUserInvites(context.Background(), &cmd), ShouldBeNil)
+				So(cmd.DeletedRows, ShouldEqual, 1)
+			})
+
+			Convey("Should be able to delete expired invites in batches", func() {
+				for i := 0; i < expiredUserInvitesBatchSize+10; i++ {
+					err := CreateTempUser(&models.CreateTempUserCommand{OrgId: 2256, Code: fmt.Sprintf("code-%d", i), Status: models.TmpUserInvitePending})
+					So(err, ShouldBeNil)
+				}
+				olderThan := time.Now().Add(time.Minute)
+
+				dryRun := models.DeleteExpiredUserInvitesCommand{OlderThan: olderThan, DryRun: true}
+				err := DeleteExpiredUserInvites(context.Background(), &dryRun)
+				So(err, ShouldBeNil)
+				So(dryRun.DeletedRows, ShouldEqual, expiredUserInvitesBatchSize+11)
+
+				cmd := models.DeleteExpiredUserInvitesCommand{OlderThan: olderThan}
+				err = DeleteExpiredUserInvites(context.Background(), &cmd)
+				So(err, ShouldBeNil)
+				So(cmd.DeletedRows, ShouldEqual, expiredUserInvitesBatchSize+11)
+
+				query := models.GetTempUsersQuery{OrgId: 2256, Status: models.TmpUserInvitePending}
+				err = GetTempUsersQuery(&query)
+				So(err, ShouldBeNil)
+				So(query.Result, ShouldBeEmpty)
+			})
+
+			Convey("Should only keep the newest pending invite per email and org", func() {
+				for _, invite := range []models.CreateTempUserCommand{
+					{OrgId: 2256, Email: "e@as.co", Code: "asd-2", Status: models.TmpUserInvitePending},
+					{OrgId: 2256, Email: "e@as.co", Code: "asd-3", Status: models.TmpUserInvitePending},
+					{OrgId: 2256, Email: "e@as.co", Code: "asd-revoked", Status: models.TmpUserRevoked},
+					{OrgId: 2256, Email: "other@as.co", Code: "other", Status: models.TmpUserInvitePending},
+					{OrgId: 2257, Email: "e@as.co", Code: "other-org", Status: models.TmpUserInvitePending},
+				} {
+					invite := invite
+					So(CreateTempUser(&invite), ShouldBeNil)
+				}
+
+				dryRun := models.DeleteDuplicateUserInvitesCommand{DryRun: true}
+				err := DeleteDuplicateUserInvites(context.Background(), &dryRun)
+				So(err, ShouldBeNil)
+				So(dryRun.DeletedRows, ShouldEqual, 2)
+
+				cmd := models.DeleteDuplicateUserInvitesCommand{}
+				err = DeleteDuplicateUserInvites(context.Background(), &cmd)
+				So(err, ShouldBeNil)
+				So(cmd.DeletedRows, ShouldEqual, 2)
+
+				var kept []models.TempUser
+				err = x.OrderBy("id").Find(&kept)
+				So(err, ShouldBeNil)
+				codes := []string{}
+				for _, invite := range kept {
+					codes = append(codes, invite.Code)
+				}
+				So(codes, ShouldResemble, []string{"asd-3", "asd-revoked", "other", "other-org"})
+
+				// nothing is left to deduplicate
+				err = DeleteDuplicateUserInvites(context.Background(), &cmd)
+				So(err, ShouldBeNil)
+				So(cmd.DeletedRows, ShouldEqual, 0)
+			})
 		})
 	})
 }
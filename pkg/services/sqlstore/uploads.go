@@ -0,0 +1,71 @@
+package sqlstore
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+func init() {
+	bus.AddHandlerCtx("sql", FindUploadReferences)
+}
+
+// uploadReferencesBatchSize is how many dashboards are loaded at once while
+// looking for references to uploaded files.
+const uploadReferencesBatchSize = 100
+
+func FindUploadReferences(ctx context.Context, query *models.FindUploadReferencesQuery) error {
+	query.Result = map[string]bool{}
+	if len(query.Names) == 0 {
+		return nil
+	}
+
+	return withDbSession(ctx, func(sess *DBSession) error {
+		var lastID int64
+		for {
+			var dashboards []struct {
+				Id   int64
+				Data string
+			}
+			err := sess.SQL("SELECT id, data FROM dashboard WHERE id > ? ORDER BY id"+dialect.Limit(uploadReferencesBatchSize), lastID).Find(&dashboards)
+			if err != nil {
+				return err
+			}
+			for _, dashboard := range dashboards {
+				markUploadReferences(query, dashboard.Data)
+			}
+			if len(dashboards) < uploadReferencesBatchSize || len(query.Result) == len(query.Names) {
+				break
+			}
+			lastID = dashboards[len(dashboards)-1].Id
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		var dataSources []struct {
+			Url      string
+			JsonData string
+		}
+		if err := sess.SQL("SELECT url, json_data FROM data_source").Find(&dataSources); err != nil {
+			return err
+		}
+		for _, ds := range dataSources {
+			markUploadReferences(query, ds.Url)
+			markUploadReferences(query, ds.JsonData)
+		}
+
+		return nil
+	})
+}
+
+// markUploadReferences adds the names found in content to the query result.
+func markUploadReferences(query *models.FindUploadReferencesQuery, content string) {
+	for _, name := range query.Names {
+		if !query.Result[name] && strings.Contains(content, name) {
+			query.Result[name] = true
+		}
+	}
+}
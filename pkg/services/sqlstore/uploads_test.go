@@ -0,0 +1,46 @@
+package sqlstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/components/simplejson"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindUploadReferences(t *testing.T) {
+	InitTestDB(t)
+
+	err := SaveDashboard(&models.SaveDashboardCommand{
+		OrgId: 1,
+		Dashboard: simplejson.NewFromAny(map[string]interface{}{
+			"title": "Uploads",
+			"panels": []interface{}{
+				map[string]interface{}{"type": "text", "content": "![logo](/public/uploads/images/logo.png)"},
+			},
+		}),
+	})
+	require.NoError(t, err)
+
+	err = AddDataSource(&models.AddDataSourceCommand{
+		OrgId:    1,
+		Name:     "sales",
+		Type:     "csv",
+		Access:   models.DS_ACCESS_PROXY,
+		JsonData: simplejson.NewFromAny(map[string]interface{}{"path": "csv/sales.csv"}),
+	})
+	require.NoError(t, err)
+
+	t.Run("Finds the files dashboards and data sources refer to", func(t *testing.T) {
+		query := &models.FindUploadReferencesQuery{Names: []string{"images/logo.png", "csv/sales.csv", "csv/orphaned.csv"}}
+		require.NoError(t, FindUploadReferences(context.Background(), query))
+		require.Equal(t, map[string]bool{"images/logo.png": true, "csv/sales.csv": true}, query.Result)
+	})
+
+	t.Run("Finds nothing without names", func(t *testing.T) {
+		query := &models.FindUploadReferencesQuery{}
+		require.NoError(t, FindUploadReferences(context.Background(), query))
+		require.Empty(t, query.Result)
+	})
+}
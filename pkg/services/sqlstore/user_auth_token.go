@@ -0,0 +1,88 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+)
+
+func init() {
+	bus.AddHandlerCtx("sql", DeleteExpiredAuthTokens)
+}
+
+const expiredAuthTokensBatchSize = 1000
+
+// DeleteExpiredAuthTokens deletes the user auth tokens that outlived the
+// maximum (inactive) login lifetime in batches, so session lookups aren't
+// blocked by a single large delete.
+func DeleteExpiredAuthTokens(ctx context.Context, cmd *models.DeleteExpiredAuthTokensCommand) error {
+	createdBefore := cmd.CreatedBefore.Unix()
+	rotatedBefore := cmd.RotatedBefore.Unix()
+
+	countSQL := "SELECT COUNT(*) AS count FROM user_auth_token WHERE created_at <= ? OR rotated_at <= ?"
+	deleteSQL := func(limit int64) string {
+		return "DELETE FROM user_auth_token WHERE id IN (SELECT id FROM (SELECT id FROM user_auth_token WHERE created_at <= ? OR rotated_at <= ? ORDER BY id " +
+			dialect.Limit(limit) + ") t)"
+	}
+
+	return withDbSession(ctx, func(sess *DBSession) error {
+		expired, err := countRows(sess, countSQL, createdBefore, rotatedBefore)
+		if err != nil {
+			return err
+		}
+		if cmd.DryRun || expired == 0 {
+			cmd.DeletedRows = expired
+			return nil
+		}
+
+		budget := deleteBudgetFromContext(ctx)
+		progress := newDeleteProgress("user_auth_token", func() (int64, error) {
+			return countRows(sess, countSQL, createdBefore, rotatedBefore)
+		})
+		var rowsAffectedUnsupported bool
+		for batch := int64(0); batch*expiredAuthTokensBatchSize < expired; batch++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			limit := budget.limit(expiredAuthTokensBatchSize)
+			if limit <= 0 {
+				break
+			}
+
+			res, err := sess.Exec(deleteSQL(limit), createdBefore, rotatedBefore)
+			if err != nil {
+				return err
+			}
+
+			affected, err := res.RowsAffected()
+			if err != nil {
+				// assume the whole batch got deleted, so the budget still holds
+				budget.spend(limit)
+				rowsAffectedUnsupported = true
+				continue
+			}
+			budget.spend(affected)
+			if affected == 0 {
+				break
+			}
+			cmd.DeletedRows += affected
+			progress.batch(affected)
+
+			if err := deleteBackpressure.wait(ctx); err != nil {
+				return err
+			}
+		}
+
+		// not every driver reports affected rows, count what is left instead
+		if rowsAffectedUnsupported {
+			left, err := countRows(sess, countSQL, createdBefore, rotatedBefore)
+			if err != nil {
+				return err
+			}
+			cmd.DeletedRows = expired - left
+		}
+
+		return nil
+	})
+}
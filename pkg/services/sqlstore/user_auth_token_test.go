@@ -0,0 +1,76 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeleteExpiredAuthTokens(t *testing.T) {
+	Convey("Testing deleting expired user auth tokens", t, func() {
+		InitTestDB(t)
+
+		insertToken := func(token string, createdAt, rotatedAt time.Time) {
+			rawSQL := "INSERT INTO user_auth_token (user_id, auth_token, prev_auth_token, user_agent, client_ip, auth_token_seen, rotated_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+			_, err := x.Exec(rawSQL, 1, token+"-A", token+"-B", "", "", false, rotatedAt.Unix(), createdAt.Unix(), createdAt.Unix())
+			So(err, ShouldBeNil)
+		}
+		countTokens := func() int64 {
+			count, err := x.Table("user_auth_token").Count()
+			So(err, ShouldBeNil)
+			return count
+		}
+
+		now := time.Now()
+		cmd := models.DeleteExpiredAuthTokensCommand{
+			CreatedBefore: now.Add(-30 * 24 * time.Hour),
+			RotatedBefore: now.Add(-7 * 24 * time.Hour),
+		}
+
+		Convey("Should delete tokens rotated before the inactive lifetime", func() {
+			for i := 0; i < 3; i++ {
+				insertToken(fmt.Sprintf("old%d", i), cmd.RotatedBefore, cmd.RotatedBefore)
+				insertToken(fmt.Sprintf("new%d", i), cmd.RotatedBefore, cmd.RotatedBefore.Add(time.Second))
+			}
+
+			err := DeleteExpiredAuthTokens(context.Background(), &cmd)
+			So(err, ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, 3)
+			So(countTokens(), ShouldEqual, 3)
+		})
+
+		Convey("Should delete tokens created before the maximum lifetime", func() {
+			for i := 0; i < 3; i++ {
+				insertToken(fmt.Sprintf("old%d", i), cmd.CreatedBefore, now)
+				insertToken(fmt.Sprintf("new%d", i), cmd.CreatedBefore.Add(time.Second), now)
+			}
+
+			err := DeleteExpiredAuthTokens(context.Background(), &cmd)
+			So(err, ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, 3)
+			So(countTokens(), ShouldEqual, 3)
+		})
+
+		Convey("Should delete expired tokens in batches", func() {
+			for i := 0; i < expiredAuthTokensBatchSize+10; i++ {
+				insertToken(fmt.Sprintf("token%d", i), cmd.CreatedBefore, cmd.RotatedBefore)
+			}
+
+			dryRun := cmd
+			dryRun.DryRun = true
+			err := DeleteExpiredAuthTokens(context.Background(), &dryRun)
+			So(err, ShouldBeNil)
+			So(dryRun.DeletedRows, ShouldEqual, expiredAuthTokensBatchSize+10)
+			So(countTokens(), ShouldEqual, expiredAuthTokensBatchSize+10)
+
+			err = DeleteExpiredAuthTokens(context.Background(), &cmd)
+			So(err, ShouldBeNil)
+			So(cmd.DeletedRows, ShouldEqual, expiredAuthTokensBatchSize+10)
+			So(countTokens(), ShouldEqual, 0)
+		})
+	})
+}
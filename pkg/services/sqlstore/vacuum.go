@@ -0,0 +1,81 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+)
+
+func init() {
+	bus.AddHandlerCtx("sql", VacuumDatabase)
+}
+
+// sqliteAutoVacuumIncremental is the auto_vacuum pragma value of databases
+// created with auto_vacuum = INCREMENTAL.
+const sqliteAutoVacuumIncremental = 2
+
+// VacuumDatabase shrinks the SQLite database file after large deletes, which
+// otherwise only mark pages as free. Databases that support incremental vacuum
+// are vacuumed incrementally, all others are rebuilt with VACUUM.
+// MySQL and Postgres reclaim space on their own, so they are left alone.
+func VacuumDatabase(ctx context.Context, cmd *models.VacuumDatabaseCommand) error {
+	if dialect.DriverName() != migrator.SQLITE {
+		return nil
+	}
+
+	return withDbSession(ctx, func(sess *DBSession) error {
+		before, err := sqliteFileSize(sess)
+		if err != nil {
+			return err
+		}
+
+		autoVacuum, err := sqlitePragma(sess, "auto_vacuum")
+		if err != nil {
+			return err
+		}
+
+		vacuumSQL := "VACUUM"
+		if autoVacuum == sqliteAutoVacuumIncremental {
+			vacuumSQL = "PRAGMA incremental_vacuum"
+		}
+		if _, err := sess.Exec(vacuumSQL); err != nil {
+			return err
+		}
+
+		after, err := sqliteFileSize(sess)
+		if err != nil {
+			return err
+		}
+
+		cmd.Vacuumed = true
+		cmd.ReclaimedBytes = before - after
+		return nil
+	})
+}
+
+func sqliteFileSize(sess *DBSession) (int64, error) {
+	pageCount, err := sqlitePragma(sess, "page_count")
+	if err != nil {
+		return 0, err
+	}
+	pageSize, err := sqlitePragma(sess, "page_size")
+	if err != nil {
+		return 0, err
+	}
+
+	return pageCount * pageSize, nil
+}
+
+func sqlitePragma(sess *DBSession, name string) (int64, error) {
+	result, err := sess.Query("PRAGMA " + name)
+	if err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	return toInt64(result[0][name]), nil
+}
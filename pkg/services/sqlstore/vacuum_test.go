@@ -0,0 +1,27 @@
+package sqlstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVacuumDatabase(t *testing.T) {
+	Convey("Testing vacuuming the database", t, func() {
+		InitTestDB(t)
+
+		cmd := models.VacuumDatabaseCommand{}
+		err := VacuumDatabase(context.Background(), &cmd)
+		So(err, ShouldBeNil)
+
+		if dialect.DriverName() == migrator.SQLITE {
+			So(cmd.Vacuumed, ShouldBeTrue)
+			So(cmd.ReclaimedBytes, ShouldBeGreaterThanOrEqualTo, 0)
+		} else {
+			So(cmd.Vacuumed, ShouldBeFalse)
+		}
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -263,6 +264,22 @@ type Cfg struct {
 	CookieSameSiteMode               http.SameSite
 
 	TempDataLifetime                 time.Duration
+	TempDataRemoveEmptyDirs          bool
+	TempDataExcludePatterns          []string
+	TempDataLifetimeByExtension      []string
+	TempDataMaxSize                  int64
+	TempDataMaxFiles                 int
+	TempDataTrashDir                 string
+	TempDataUseChangeTime            bool
+	TempDataTimeSource               string
+	TempDataForceCleanup             bool
+	TempDataMinFreeSpace             int
+	TempDataCriticalFreeSpace        int
+	TempDataInUseGrace               time.Duration
+	TempDataSkipForeignFiles         bool
+	TempDataAgeHistogram             bool
+	TempDataTrashLifetime            time.Duration
+	AlertingImageRetention           time.Duration
 	MetricsEndpointEnabled           bool
 	MetricsEndpointBasicAuthUsername string
 	MetricsEndpointBasicAuthPassword string
@@ -274,6 +291,15 @@ type Cfg struct {
 	DisableSanitizeHtml              bool
 	EnterpriseLicensePath            string
 
+	// Exports
+	ExportsDir         string
+	ExportDataLifetime time.Duration
+	ExportDataMaxSize  int64
+
+	// Uploads
+	UploadsDir         string
+	UploadDataLifetime time.Duration
+
 	// Dashboards
 	DefaultHomeDashboardPath string
 
@@ -297,6 +323,17 @@ type Cfg struct {
 
 	EditorsCanAdmin bool
 
+	UserInviteMaxLifetimeDays    int
+	VerificationEmailMaxLifetime time.Duration
+	// UserInviteRecoveryWindow is how long expired invites and sign ups are
+	// only marked as expired before they are deleted, 0 deletes them right away
+	UserInviteRecoveryWindow time.Duration
+
+	// Keep expired external snapshots until their copy on the snapshot server is deleted
+	SnapshotExternalDeleteRequired bool
+	// SnapshotMaxAgePerOrg deletes the snapshots of an org once they are older, even before they expire
+	SnapshotMaxAgePerOrg map[int64]time.Duration
+
 	ApiKeyMaxSecondsToLive int64
 
 	// Use to enable new features which may still be in alpha/beta stage.
@@ -308,6 +345,101 @@ type Cfg struct {
 	AlertingAnnotationCleanupSetting   AnnotationCleanupSettings
 	DashboardAnnotationCleanupSettings AnnotationCleanupSettings
 	APIAnnotationCleanupSettings       AnnotationCleanupSettings
+	AnnotationCleanupJobBatchSize      int64
+
+	// Cleanup
+	CleanupInterval         time.Duration
+	CleanupDryRun           bool
+	CleanupRunOnStartup     bool
+	CleanupStartupDelay     time.Duration
+	CleanupConcurrency      int
+	CleanupRetryAttempts    int
+	CleanupRetryBackoff     time.Duration
+	CleanupFailureThreshold int
+	CleanupCycleTimeout     time.Duration
+	CleanupRunOnSignal      bool
+	// CleanupMaintenanceFile puts the cleanup in maintenance mode while the file exists.
+	CleanupMaintenanceFile string
+
+	// CleanupLogLevel is the level the outcome of every cleanup task run is logged at, debug or info
+	CleanupLogLevel string
+	// CleanupVerifyCounts counts the rows of the table of a database task before
+	// and after every run and warns when the difference isn't what it deleted
+	CleanupVerifyCounts bool
+
+	// CleanupDeletionThreshold is the number of rows or files deleted by a single
+	// task run above which events.CleanupDeletionThresholdExceeded is published
+	CleanupDeletionThreshold int64
+	// CleanupMaxRowsPerCycle caps the rows a single task run deletes, the rest
+	// is deleted by the following runs
+	CleanupMaxRowsPerCycle int64
+
+	// CleanupReplicaLagThreshold pauses the batched deletes while the replicas
+	// of the database lag behind by more than it, 0 turns the check off
+	CleanupReplicaLagThreshold     time.Duration
+	CleanupReplicaLagCheckInterval time.Duration
+	// CleanupReplicaLagQuery returns the replica lag in seconds, it defaults to
+	// a query of pg_stat_replication on Postgres and SHOW SLAVE STATUS on MySQL
+	CleanupReplicaLagQuery string
+
+	// CleanupProgressLogBatches and CleanupProgressLogInterval set how often the
+	// batched deletes log their progress, 0 turns either off
+	CleanupProgressLogBatches  int64
+	CleanupProgressLogInterval time.Duration
+
+	// CleanupSqliteVacuum shrinks SQLite databases after CleanupSqliteVacuumThreshold rows got deleted
+	CleanupSqliteVacuum          bool
+	CleanupSqliteVacuumThreshold int64
+
+	// MinDashboardVersionsToKeep is the number of versions the cleanup never goes below for any dashboard
+	MinDashboardVersionsToKeep        int
+	DashboardVersionsToKeepPerOrg     map[int64]int
+	DashboardVersionsDeleteBatchSize  int
+	DashboardVersionsDeleteBatchDelay time.Duration
+	ExpiredTokenRetention             time.Duration
+	LoginAttemptsRetention            time.Duration
+	ServerLockRetention               time.Duration
+
+	// CleanupUnknownProvisioners also deletes the dashboard provisioning records
+	// of provisioners that are no longer configured
+	CleanupUnknownProvisioners bool
+	// CleanupUnusedTags deletes the tags no annotation or alert rule uses
+	CleanupUnusedTags bool
+	// CleanupUnusedPlaylistTags deletes the playlist items of tags no dashboard
+	// of the org uses
+	CleanupUnusedPlaylistTags bool
+
+	CleanupTempFiles             CleanupTaskSettings
+	CleanupExpiredSnapshots      CleanupTaskSettings
+	CleanupExpiredVersions       CleanupTaskSettings
+	CleanupOldAnnotations        CleanupTaskSettings
+	CleanupOrphanedAnnotations   CleanupTaskSettings
+	CleanupOldLoginAttempts      CleanupTaskSettings
+	CleanupExpiredAPIKeys        CleanupTaskSettings
+	CleanupExpiredUserInvites    CleanupTaskSettings
+	CleanupExpiredAuthTokens     CleanupTaskSettings
+	CleanupOrphanedDashboardAcl  CleanupTaskSettings
+	CleanupStaleServerLocks      CleanupTaskSettings
+	CleanupOrphanedPreferences   CleanupTaskSettings
+	CleanupOrphanedStars         CleanupTaskSettings
+	CleanupOrphanedPlaylistItems CleanupTaskSettings
+	CleanupDuplicateUserInvites  CleanupTaskSettings
+	CleanupExpiredCacheData      CleanupTaskSettings
+	CleanupExportFiles           CleanupTaskSettings
+	CleanupUploadedFiles         CleanupTaskSettings
+	// CleanupAlertImageBucket prunes the alert images uploaded to the S3 external image storage, it's off by default.
+	CleanupAlertImageBucket CleanupTaskSettings
+	// CleanupSnapshotsOverLimit deletes the oldest snapshots of orgs with more
+	// than SnapshotMaxPerOrg snapshots, it's off by default.
+	CleanupSnapshotsOverLimit CleanupTaskSettings
+	SnapshotMaxPerOrg         int64
+
+	CleanupStaleDashboardProvisioning CleanupTaskSettings
+
+	// CleanupDeepScrub runs all orphan checks in one pass, CleanupDeepScrubDryRun
+	// only counts the rows it would delete
+	CleanupDeepScrub       CleanupTaskSettings
+	CleanupDeepScrubDryRun bool
 }
 
 // IsExpressionsEnabled returns whether the expressions feature is enabled.
@@ -403,6 +535,9 @@ func applyEnvVariableOverrides(file *ini.File) error {
 }
 
 func (cfg *Cfg) readAnnotationSettings() {
+	section := cfg.Raw.Section("annotations")
+	cfg.AnnotationCleanupJobBatchSize = section.Key("cleanupjob_batchsize").MustInt64(100)
+
 	dashboardAnnotation := cfg.Raw.Section("annotations.dashboard")
 	apiIAnnotation := cfg.Raw.Section("annotations.api")
 	alertingSection := cfg.Raw.Section("alerting")
@@ -708,13 +843,26 @@ func (cfg *Cfg) Load(args *CommandLineArgs) error {
 		return err
 	}
 
-	if err := readSnapshotsSettings(iniFile); err != nil {
+	if err := readSnapshotsSettings(iniFile, cfg); err != nil {
 		return err
 	}
 
 	// read dashboard settings
 	dashboards := iniFile.Section("dashboards")
 	DashboardVersionsToKeep = dashboards.Key("versions_to_keep").MustInt(20)
+	cfg.MinDashboardVersionsToKeep = dashboards.Key("min_versions_to_keep").MustInt(1)
+	if cfg.MinDashboardVersionsToKeep < 1 {
+		cfg.MinDashboardVersionsToKeep = 1
+	}
+	cfg.DashboardVersionsToKeepPerOrg = map[int64]int{}
+	for orgID, value := range cfg.readOrgOverrides(dashboards, "versions_to_keep_per_org") {
+		versionsToKeep, err := strconv.Atoi(value)
+		if err != nil || versionsToKeep < 1 {
+			cfg.Logger.Warn("Ignoring invalid versions to keep of org", "orgId", orgID, "value", value)
+			continue
+		}
+		cfg.DashboardVersionsToKeepPerOrg[orgID] = versionsToKeep
+	}
 	MinRefreshInterval, err = valueAsString(dashboards, "min_refresh_interval", "5s")
 	if err != nil {
 		return err
@@ -733,6 +881,29 @@ func (cfg *Cfg) Load(args *CommandLineArgs) error {
 	}
 
 	cfg.TempDataLifetime = iniFile.Section("paths").Key("temp_data_lifetime").MustDuration(time.Second * 3600 * 24)
+	cfg.TempDataRemoveEmptyDirs = iniFile.Section("paths").Key("temp_data_remove_empty_dirs").MustBool(false)
+	cfg.TempDataExcludePatterns = util.SplitString(iniFile.Section("paths").Key("temp_data_exclude_patterns").String())
+	cfg.TempDataLifetimeByExtension = util.SplitString(iniFile.Section("paths").Key("temp_data_lifetime_by_extension").String())
+	cfg.TempDataMaxSize = iniFile.Section("paths").Key("temp_data_max_size").MustInt64(0)
+	cfg.TempDataMaxFiles = iniFile.Section("paths").Key("temp_data_max_files").MustInt(0)
+	if trashDir := iniFile.Section("paths").Key("temp_data_trash_dir").String(); trashDir != "" {
+		cfg.TempDataTrashDir = makeAbsolute(trashDir, HomePath)
+	}
+	cfg.TempDataTrashLifetime = iniFile.Section("paths").Key("temp_data_trash_lifetime").MustDuration(time.Hour * 24 * 7)
+	cfg.TempDataUseChangeTime = iniFile.Section("paths").Key("temp_data_use_change_time").MustBool(false)
+	cfg.TempDataTimeSource = iniFile.Section("paths").Key("temp_data_time_source").In("mtime", []string{"mtime", "ctime", "atime"})
+	cfg.TempDataForceCleanup = iniFile.Section("paths").Key("temp_data_force_cleanup").MustBool(false)
+	cfg.TempDataMinFreeSpace = iniFile.Section("paths").Key("temp_data_min_free_space").MustInt(0)
+	cfg.TempDataCriticalFreeSpace = iniFile.Section("paths").Key("temp_data_critical_free_space").MustInt(0)
+	cfg.TempDataInUseGrace = iniFile.Section("paths").Key("temp_data_in_use_grace").MustDuration(0)
+	cfg.TempDataSkipForeignFiles = iniFile.Section("paths").Key("temp_data_skip_foreign_files").MustBool(false)
+	cfg.TempDataAgeHistogram = iniFile.Section("paths").Key("temp_data_age_histogram").MustBool(false)
+	cfg.ExportsDir = filepath.Join(cfg.DataPath, "exports")
+	cfg.ExportDataLifetime = iniFile.Section("paths").Key("export_data_lifetime").MustDuration(time.Hour * 24)
+	cfg.ExportDataMaxSize = iniFile.Section("paths").Key("export_data_max_size").MustInt64(0)
+	cfg.UploadsDir = filepath.Join(cfg.DataPath, "uploads")
+	cfg.UploadDataLifetime = iniFile.Section("paths").Key("upload_data_lifetime").MustDuration(time.Hour * 24 * 7)
+	cfg.AlertingImageRetention = iniFile.Section("alerting").Key("image_retention").MustDuration(time.Hour * 24 * 7)
 	cfg.MetricsEndpointEnabled = iniFile.Section("metrics").Key("enabled").MustBool(true)
 	cfg.MetricsEndpointBasicAuthUsername, err = valueAsString(iniFile.Section("metrics"), "basic_auth_username", "")
 	if err != nil {
@@ -792,6 +963,7 @@ func (cfg *Cfg) Load(args *CommandLineArgs) error {
 	cfg.readSmtpSettings()
 	cfg.readQuotaSettings()
 	cfg.readAnnotationSettings()
+	cfg.readCleanupSettings()
 
 	if VerifyEmailEnabled && !cfg.Smtp.Enabled {
 		log.Warnf("require_email_validation is enabled but smtp is disabled")
@@ -1112,6 +1284,10 @@ func readAuthSettings(iniFile *ini.File, cfg *Cfg) error {
 	return nil
 }
 
+// minUserInviteMaxLifetimeDays protects pending invites from being deleted
+// right away by a misconfigured lifetime.
+const minUserInviteMaxLifetimeDays = 1
+
 func readUserSettings(iniFile *ini.File, cfg *Cfg) error {
 	users := iniFile.Section("users")
 	AllowUserSignUp = users.Key("allow_sign_up").MustBool(true)
@@ -1147,6 +1323,14 @@ func readUserSettings(iniFile *ini.File, cfg *Cfg) error {
 	}
 	ViewersCanEdit = users.Key("viewers_can_edit").MustBool(false)
 	cfg.EditorsCanAdmin = users.Key("editors_can_admin").MustBool(false)
+	cfg.UserInviteMaxLifetimeDays = users.Key("user_invite_max_lifetime_days").MustInt(30)
+	if cfg.UserInviteMaxLifetimeDays < minUserInviteMaxLifetimeDays {
+		cfg.Logger.Warn("User invite lifetime is below the minimum, expired user invites will not be deleted",
+			"user_invite_max_lifetime_days", cfg.UserInviteMaxLifetimeDays, "minimum", minUserInviteMaxLifetimeDays)
+		cfg.UserInviteMaxLifetimeDays = 0
+	}
+	cfg.VerificationEmailMaxLifetime = users.Key("verification_email_max_lifetime").MustDuration(time.Hour * 24 * 7)
+	cfg.UserInviteRecoveryWindow = users.Key("user_invite_recovery_window").MustDuration(0)
 
 	return nil
 }
@@ -1206,7 +1390,7 @@ func readAlertingSettings(iniFile *ini.File) error {
 	return nil
 }
 
-func readSnapshotsSettings(iniFile *ini.File) error {
+func readSnapshotsSettings(iniFile *ini.File, cfg *Cfg) error {
 	snapshots := iniFile.Section("snapshots")
 	var err error
 	ExternalSnapshotUrl, err = valueAsString(snapshots, "external_snapshot_url", "")
@@ -1220,10 +1404,44 @@ func readSnapshotsSettings(iniFile *ini.File) error {
 	ExternalEnabled = snapshots.Key("external_enabled").MustBool(true)
 	SnapShotRemoveExpired = snapshots.Key("snapshot_remove_expired").MustBool(true)
 	SnapshotPublicMode = snapshots.Key("public_mode").MustBool(false)
+	cfg.SnapshotExternalDeleteRequired = snapshots.Key("external_delete_required").MustBool(false)
+
+	cfg.SnapshotMaxAgePerOrg = map[int64]time.Duration{}
+	for orgID, value := range cfg.readOrgOverrides(snapshots, "max_age_per_org") {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge <= 0 {
+			cfg.Logger.Warn("Ignoring invalid snapshot max age of org", "orgId", orgID, "value", value)
+			continue
+		}
+		cfg.SnapshotMaxAgePerOrg[orgID] = maxAge
+	}
 
 	return nil
 }
 
+// readOrgOverrides reads a comma separated list of <org id>:<value> pairs,
+// e.g. 1:90,3:7, and returns the values by org id. Malformed pairs are logged
+// and skipped.
+func (cfg *Cfg) readOrgOverrides(section *ini.Section, key string) map[int64]string {
+	overrides := map[int64]string{}
+	for _, pair := range util.SplitString(section.Key(key).String()) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			cfg.Logger.Warn("Ignoring invalid org override", "key", key, "value", pair)
+			continue
+		}
+
+		orgID, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+		if err != nil {
+			cfg.Logger.Warn("Ignoring org override with invalid org id", "key", key, "value", pair)
+			continue
+		}
+		overrides[orgID] = strings.TrimSpace(parts[1])
+	}
+
+	return overrides
+}
+
 func readServerSettings(iniFile *ini.File, cfg *Cfg) error {
 	server := iniFile.Section("server")
 	var err error
@@ -0,0 +1,156 @@
+package setting
+
+import (
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	defaultCleanupInterval         = time.Minute * 10
+	defaultCleanupRetryAttempts    = 3
+	defaultCleanupRetryBackoff     = time.Second
+	defaultCleanupFailureThreshold = 3
+	defaultLoginAttemptsRetention  = time.Minute * 10
+	// minLoginAttemptsRetention matches the window of the brute force login
+	// protection, deleting attempts earlier would weaken it.
+	minLoginAttemptsRetention = time.Minute * 5
+
+	defaultServerLockRetention = time.Hour * 24 * 90
+	// minServerLockRetention keeps the locks of tasks that run daily, deleting
+	// a lock that is in use only makes its next run create it again.
+	minServerLockRetention = time.Hour * 24
+
+	defaultDeepScrubInterval = time.Hour * 24
+)
+
+// CleanupTaskSettings holds the [cleanup] settings of a single cleanup task.
+type CleanupTaskSettings struct {
+	Enabled  bool
+	Interval time.Duration
+}
+
+func (cfg *Cfg) readCleanupSettings() {
+	cleanup := cfg.Raw.Section("cleanup")
+
+	cfg.CleanupInterval = cleanup.Key("interval").MustDuration(defaultCleanupInterval)
+	if cfg.CleanupInterval <= 0 {
+		cfg.Logger.Warn("Invalid cleanup interval, falling back to default", "interval", cfg.CleanupInterval, "default", defaultCleanupInterval)
+		cfg.CleanupInterval = defaultCleanupInterval
+	}
+
+	cfg.CleanupDryRun = cleanup.Key("dry_run").MustBool(false)
+	cfg.CleanupRunOnStartup = cleanup.Key("run_on_startup").MustBool(true)
+	cfg.CleanupStartupDelay = cleanup.Key("startup_delay").MustDuration(time.Second * 5)
+	cfg.CleanupCycleTimeout = cleanup.Key("cycle_timeout").MustDuration(0)
+	cfg.CleanupRunOnSignal = cleanup.Key("run_on_signal").MustBool(true)
+	if maintenanceFile := cleanup.Key("maintenance_file").String(); maintenanceFile != "" {
+		cfg.CleanupMaintenanceFile = makeAbsolute(maintenanceFile, cfg.DataPath)
+	}
+
+	cfg.CleanupConcurrency = cleanup.Key("concurrency").MustInt(1)
+	if cfg.CleanupConcurrency < 1 {
+		cfg.Logger.Warn("Invalid cleanup concurrency, falling back to one task at a time", "concurrency", cfg.CleanupConcurrency)
+		cfg.CleanupConcurrency = 1
+	}
+
+	cfg.CleanupLogLevel = cleanup.Key("log_level").In("debug", []string{"debug", "info"})
+	cfg.CleanupVerifyCounts = cleanup.Key("verify_counts").MustBool(false)
+
+	cfg.CleanupRetryAttempts = cleanup.Key("retry_attempts").MustInt(defaultCleanupRetryAttempts)
+	if cfg.CleanupRetryAttempts < 1 {
+		cfg.Logger.Warn("Invalid cleanup retry attempts, falling back to a single attempt", "attempts", cfg.CleanupRetryAttempts)
+		cfg.CleanupRetryAttempts = 1
+	}
+	cfg.CleanupRetryBackoff = cleanup.Key("retry_backoff").MustDuration(defaultCleanupRetryBackoff)
+	cfg.CleanupFailureThreshold = cleanup.Key("failure_threshold").MustInt(defaultCleanupFailureThreshold)
+	cfg.CleanupDeletionThreshold = cleanup.Key("deletion_threshold").MustInt64(0)
+	cfg.CleanupMaxRowsPerCycle = cleanup.Key("max_rows_per_cycle").MustInt64(0)
+	cfg.CleanupReplicaLagThreshold = cleanup.Key("replica_lag_threshold").MustDuration(0)
+	cfg.CleanupReplicaLagCheckInterval = cleanup.Key("replica_lag_check_interval").MustDuration(time.Second * 10)
+	cfg.CleanupReplicaLagQuery = cleanup.Key("replica_lag_query").MustString("")
+	cfg.CleanupProgressLogBatches = cleanup.Key("progress_log_batches").MustInt64(0)
+	cfg.CleanupProgressLogInterval = cleanup.Key("progress_log_interval").MustDuration(time.Minute)
+
+	cfg.CleanupSqliteVacuum = cleanup.Key("sqlite_vacuum").MustBool(false)
+	cfg.CleanupSqliteVacuumThreshold = cleanup.Key("sqlite_vacuum_threshold").MustInt64(10000)
+
+	cfg.DashboardVersionsDeleteBatchSize = cleanup.Key("dashboard_versions_batch_size").MustInt(100)
+	if cfg.DashboardVersionsDeleteBatchSize < 1 {
+		cfg.DashboardVersionsDeleteBatchSize = 100
+	}
+	cfg.DashboardVersionsDeleteBatchDelay = cleanup.Key("dashboard_versions_batch_delay").MustDuration(time.Millisecond * 100)
+
+	cfg.ExpiredTokenRetention = cleanup.Key("expired_token_retention").MustDuration(time.Hour * 24 * 7)
+
+	cfg.LoginAttemptsRetention = cleanup.Key("login_attempts_retention").MustDuration(defaultLoginAttemptsRetention)
+	if cfg.LoginAttemptsRetention < minLoginAttemptsRetention {
+		cfg.Logger.Warn("Invalid login attempts retention, falling back to default", "retention", cfg.LoginAttemptsRetention, "minimum", minLoginAttemptsRetention, "default", defaultLoginAttemptsRetention)
+		cfg.LoginAttemptsRetention = defaultLoginAttemptsRetention
+	}
+
+	cfg.ServerLockRetention = cleanup.Key("server_lock_retention").MustDuration(defaultServerLockRetention)
+	if cfg.ServerLockRetention < minServerLockRetention {
+		cfg.Logger.Warn("Invalid server lock retention, falling back to default", "retention", cfg.ServerLockRetention, "minimum", minServerLockRetention, "default", defaultServerLockRetention)
+		cfg.ServerLockRetention = defaultServerLockRetention
+	}
+
+	cfg.CleanupTempFiles = cfg.readCleanupTaskSettings(cleanup, "delete_temp_files")
+	cfg.CleanupExpiredSnapshots = cfg.readCleanupTaskSettings(cleanup, "delete_expired_snapshots")
+	cfg.CleanupExpiredVersions = cfg.readCleanupTaskSettings(cleanup, "delete_expired_versions")
+	cfg.CleanupOldAnnotations = cfg.readCleanupTaskSettings(cleanup, "delete_old_annotations")
+	cfg.CleanupOrphanedAnnotations = cfg.readCleanupTaskSettings(cleanup, "delete_orphaned_annotations")
+	cfg.CleanupOldLoginAttempts = cfg.readCleanupTaskSettings(cleanup, "delete_old_login_attempts")
+	cfg.CleanupExpiredAPIKeys = cfg.readCleanupTaskSettings(cleanup, "delete_expired_api_keys")
+	cfg.CleanupExpiredUserInvites = cfg.readCleanupTaskSettings(cleanup, "delete_expired_user_invites")
+	cfg.CleanupExpiredAuthTokens = cfg.readCleanupTaskSettings(cleanup, "delete_expired_auth_tokens")
+	cfg.CleanupOrphanedDashboardAcl = cfg.readCleanupTaskSettings(cleanup, "delete_orphaned_dashboard_acl")
+	cfg.CleanupStaleServerLocks = cfg.readCleanupTaskSettings(cleanup, "delete_stale_server_locks")
+	cfg.CleanupOrphanedPreferences = cfg.readCleanupTaskSettings(cleanup, "delete_orphaned_preferences")
+	cfg.CleanupOrphanedStars = cfg.readCleanupTaskSettings(cleanup, "delete_orphaned_stars")
+	cfg.CleanupOrphanedPlaylistItems = cfg.readCleanupTaskSettings(cleanup, "delete_orphaned_playlist_items")
+	cfg.CleanupDuplicateUserInvites = cfg.readCleanupTaskSettings(cleanup, "delete_duplicate_user_invites")
+	cfg.CleanupExpiredCacheData = cfg.readCleanupTaskSettings(cleanup, "delete_expired_cache_data")
+	cfg.CleanupExportFiles = cfg.readCleanupTaskSettings(cleanup, "delete_export_files")
+	cfg.CleanupStaleDashboardProvisioning = cfg.readCleanupTaskSettings(cleanup, "delete_stale_dashboard_provisioning")
+	cfg.CleanupUnknownProvisioners = cleanup.Key("delete_unknown_provisioners").MustBool(false)
+	cfg.CleanupUnusedTags = cleanup.Key("delete_unused_tags").MustBool(false)
+	cfg.CleanupUnusedPlaylistTags = cleanup.Key("delete_unused_playlist_tags").MustBool(false)
+
+	// uploaded files and alert images may be needed outside of Grafana, deleting them is opt-in
+	cfg.CleanupUploadedFiles = cfg.readCleanupTaskSettingsWithDefaults(cleanup, "delete_uploaded_files", CleanupTaskSettings{Interval: cfg.CleanupInterval})
+	cfg.CleanupAlertImageBucket = cfg.readCleanupTaskSettingsWithDefaults(cleanup, "delete_alert_image_bucket", CleanupTaskSettings{Interval: cfg.CleanupInterval})
+
+	// trimming deletes snapshots that haven't expired yet, so it's opt-in as well
+	cfg.CleanupSnapshotsOverLimit = cfg.readCleanupTaskSettingsWithDefaults(cleanup, "delete_snapshots_over_limit", CleanupTaskSettings{Interval: cfg.CleanupInterval})
+	cfg.SnapshotMaxPerOrg = cleanup.Key("snapshot_max_per_org").MustInt64(-1)
+	if cfg.CleanupSnapshotsOverLimit.Enabled && cfg.SnapshotMaxPerOrg < 0 {
+		cfg.Logger.Warn("Not deleting snapshots over limit, snapshot_max_per_org isn't set")
+		cfg.CleanupSnapshotsOverLimit.Enabled = false
+	}
+
+	// the deep scrub scans large tables, it's opt-in and runs daily by default
+	cfg.CleanupDeepScrub = cfg.readCleanupTaskSettingsWithDefaults(cleanup, "deep_scrub", CleanupTaskSettings{Interval: defaultDeepScrubInterval})
+	cfg.CleanupDeepScrubDryRun = cleanup.Key("deep_scrub_dry_run").MustBool(false)
+}
+
+// readCleanupTaskSettings reads the <key> switch and the <key>_interval of a
+// cleanup task, the interval defaults to the global cleanup interval.
+func (cfg *Cfg) readCleanupTaskSettings(cleanup *ini.Section, key string) CleanupTaskSettings {
+	return cfg.readCleanupTaskSettingsWithDefaults(cleanup, key, CleanupTaskSettings{Enabled: true, Interval: cfg.CleanupInterval})
+}
+
+// readCleanupTaskSettingsWithDefaults reads the <key> switch and the
+// <key>_interval of a cleanup task that aren't set to the given defaults.
+func (cfg *Cfg) readCleanupTaskSettingsWithDefaults(cleanup *ini.Section, key string, defaults CleanupTaskSettings) CleanupTaskSettings {
+	settings := CleanupTaskSettings{
+		Enabled:  cleanup.Key(key).MustBool(defaults.Enabled),
+		Interval: cleanup.Key(key + "_interval").MustDuration(defaults.Interval),
+	}
+	if settings.Interval <= 0 {
+		cfg.Logger.Warn("Invalid cleanup task interval, falling back to default", "key", key+"_interval", "interval", settings.Interval, "default", defaults.Interval)
+		settings.Interval = defaults.Interval
+	}
+
+	return settings
+}
@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -49,6 +50,102 @@ func TestLoadingSettings(t *testing.T) {
 			}
 		})
 
+		Convey("Should disable user invite cleanup for lifetimes below one day", func() {
+			for value, expected := range map[string]int{"0": 0, "-3": 0, "7": 7} {
+				cfg := NewCfg()
+				err := cfg.Load(&CommandLineArgs{
+					HomePath: "../../",
+					Args:     []string{"cfg:users.user_invite_max_lifetime_days=" + value},
+				})
+				So(err, ShouldBeNil)
+				So(cfg.UserInviteMaxLifetimeDays, ShouldEqual, expected)
+			}
+		})
+
+		Convey("Should fall back to the default login attempts retention on bad input", func() {
+			for value, expected := range map[string]time.Duration{"24h": time.Hour * 24, "1m": time.Minute * 10, "-1h": time.Minute * 10, "soon": time.Minute * 10} {
+				cfg := NewCfg()
+				err := cfg.Load(&CommandLineArgs{
+					HomePath: "../../",
+					Args:     []string{"cfg:cleanup.login_attempts_retention=" + value},
+				})
+				So(err, ShouldBeNil)
+				So(cfg.LoginAttemptsRetention, ShouldEqual, expected)
+			}
+		})
+
+		Convey("Should fall back to the default server lock retention on bad input", func() {
+			for value, expected := range map[string]time.Duration{"720h": time.Hour * 720, "1h": time.Hour * 2160, "never": time.Hour * 2160} {
+				cfg := NewCfg()
+				err := cfg.Load(&CommandLineArgs{
+					HomePath: "../../",
+					Args:     []string{"cfg:cleanup.server_lock_retention=" + value},
+				})
+				So(err, ShouldBeNil)
+				So(cfg.ServerLockRetention, ShouldEqual, expected)
+			}
+		})
+
+		Convey("Should keep the deep scrub opt-in and daily by default", func() {
+			cfg := NewCfg()
+			err := cfg.Load(&CommandLineArgs{HomePath: "../../"})
+			So(err, ShouldBeNil)
+			So(cfg.CleanupDeepScrub, ShouldResemble, CleanupTaskSettings{Interval: time.Hour * 24})
+			So(cfg.CleanupOrphanedStars.Enabled, ShouldBeTrue)
+
+			cfg = NewCfg()
+			err = cfg.Load(&CommandLineArgs{
+				HomePath: "../../",
+				Args:     []string{"cfg:cleanup.deep_scrub=true", "cfg:cleanup.deep_scrub_interval=-1h"},
+			})
+			So(err, ShouldBeNil)
+			So(cfg.CleanupDeepScrub, ShouldResemble, CleanupTaskSettings{Enabled: true, Interval: time.Hour * 24})
+		})
+
+		Convey("Should keep deleting uploaded files opt-in", func() {
+			cfg := NewCfg()
+			err := cfg.Load(&CommandLineArgs{HomePath: "../../"})
+			So(err, ShouldBeNil)
+			So(cfg.CleanupUploadedFiles, ShouldResemble, CleanupTaskSettings{Interval: cfg.CleanupInterval})
+		})
+
+		Convey("Should keep trimming snapshots over the limit opt-in", func() {
+			cfg := NewCfg()
+			err := cfg.Load(&CommandLineArgs{HomePath: "../../"})
+			So(err, ShouldBeNil)
+			So(cfg.CleanupSnapshotsOverLimit.Enabled, ShouldBeFalse)
+			So(cfg.SnapshotMaxPerOrg, ShouldEqual, -1)
+
+			// there is nothing to trim without a limit
+			cfg = NewCfg()
+			err = cfg.Load(&CommandLineArgs{HomePath: "../../", Args: []string{"cfg:cleanup.delete_snapshots_over_limit=true"}})
+			So(err, ShouldBeNil)
+			So(cfg.CleanupSnapshotsOverLimit.Enabled, ShouldBeFalse)
+
+			cfg = NewCfg()
+			err = cfg.Load(&CommandLineArgs{
+				HomePath: "../../",
+				Args:     []string{"cfg:cleanup.delete_snapshots_over_limit=true", "cfg:cleanup.snapshot_max_per_org=100"},
+			})
+			So(err, ShouldBeNil)
+			So(cfg.CleanupSnapshotsOverLimit.Enabled, ShouldBeTrue)
+			So(cfg.SnapshotMaxPerOrg, ShouldEqual, 100)
+		})
+
+		Convey("Should read per org retention overrides", func() {
+			cfg := NewCfg()
+			err := cfg.Load(&CommandLineArgs{
+				HomePath: "../../",
+				Args: []string{
+					"cfg:dashboards.versions_to_keep_per_org=1:90, 2:7, 3:0, x:5, 4",
+					"cfg:snapshots.max_age_per_org=1:2160h,2:soon",
+				},
+			})
+			So(err, ShouldBeNil)
+			So(cfg.DashboardVersionsToKeepPerOrg, ShouldResemble, map[int64]int{1: 90, 2: 7})
+			So(cfg.SnapshotMaxAgePerOrg, ShouldResemble, map[int64]time.Duration{1: time.Hour * 2160})
+		})
+
 		Convey("Should be able to override via environment variables", func() {
 			os.Setenv("GF_SECURITY_ADMIN_USER", "superduper")
 